@@ -23,8 +23,10 @@ import (
 
 	logrushooksentry "github.com/maksim-paskal/logrus-hook-sentry"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/api"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/client"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/config"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/metrics"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/registry"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/sentry"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/web"
 	"github.com/pkg/errors"
@@ -56,6 +58,14 @@ func Start(ctx context.Context) error {
 		return errors.Wrap(err, "failed to create sentry cache")
 	}
 
+	if err := client.StartInformers(ctx); err != nil {
+		return errors.Wrap(err, "failed to start informers")
+	}
+
+	registry.MirrorHealth().RegisterMirrors(config.Get().Rules)
+
+	go registry.MirrorHealth().Run(ctx)
+
 	if len(*testPod)+len(*testNamespace) > 0 {
 		patchBytes, err := api.TestPOD(ctx, *testNamespace, *testPod)
 		if err != nil {
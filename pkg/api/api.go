@@ -16,17 +16,21 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"reflect"
-	"regexp"
 	"strings"
 	"time"
 
-	"github.com/distribution/reference"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/client"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/conditions"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/config"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/imagepolicy"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/ingress"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/metrics"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/namespacepolicy"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/patch"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/probes"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/registry"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/utils"
 	"github.com/pkg/errors"
@@ -120,6 +124,8 @@ func (m *Mutation) Mutate(ctx context.Context, input *MutateInput) *admissionv1.
 		return m.mutateNamespace(ctx, input)
 	case "ingresses.networking.k8s.io.v1":
 		return m.mutateIngress(ctx, input)
+	case "httproutes.gateway.networking.k8s.io.v1", "grpcroutes.gateway.networking.k8s.io.v1":
+		return m.mutateRoute(ctx, input)
 	}
 
 	return m.mutateError(string(input.AdmissionReview.Request.UID), errors.Errorf("unknown resource type %s", input.GetType())) //nolint:lll
@@ -159,82 +165,140 @@ func (m *Mutation) createSecret(ctx context.Context, namespace string, secret *t
 	return nil
 }
 
-func (m *Mutation) replaceIngressHost(ingress networkingv1.Ingress, host string) (string, bool) {
-	ingressSuffix := *config.Get().IngressSuffix
+func (m *Mutation) mutateIngress(ctx context.Context, input *MutateInput) *admissionv1.AdmissionResponse { //nolint:funlen,cyclop
+	req := input.AdmissionReview.Request
 
-	if ingress.Annotations != nil {
-		if suffix, ok := ingress.Annotations[types.AnnotationDefaultIngressSuffix]; ok {
-			ingressSuffix = suffix
-		}
+	ingressObj := networkingv1.Ingress{}
+
+	if err := json.Unmarshal(req.Object.Raw, &ingressObj); err != nil {
+		return m.mutateError(ingressObj.Name, err)
 	}
 
-	if len(ingressSuffix) == 0 {
-		return host, false
+	ignored := m.checkIgnoreAnnotation(ingressObj.Annotations)
+
+	ingressInfo := ingress.NewIngressInfo(&ingressObj)
+	ingressInfo.Namespace = req.Namespace
+
+	// namespace is needed only for ingress rules
+	if !ignored && len(config.Get().IngressRules) > 0 {
+		namespace, err := input.GetNamespace(ctx)
+		if err != nil {
+			return m.mutateError("namespace not found", err)
+		}
+
+		ingressInfo.NamespaceLabels = namespace.Labels
+		ingressInfo.NamespaceAnnotations = namespace.Annotations
+
+		if err := ingress.SelectRules(ingressInfo, config.Get().IngressRules); err != nil {
+			return m.mutateError(ingressObj.Name, err)
+		}
 	}
 
-	if strings.HasSuffix(host, ".") {
-		return host + ingressSuffix, true
+	// hosts are checked before ignore annotation, so check can not be skipped by ingress owner
+	hosts := ingress.GetRuleHosts(&ingressObj)
+
+	if !ignored {
+		var err error
+
+		hosts, err = ingress.GetHosts(ingressInfo, &ingressObj, *config.Get().IngressSuffix)
+		if err != nil {
+			return m.mutateError(ingressObj.Name, err)
+		}
 	}
 
-	return host, false
-}
+	// hosts of existing ingress are already stored after mutation
+	oldHosts := make([]string, 0)
 
-func (m *Mutation) mutateIngressHosts(ingress networkingv1.Ingress) []types.PatchOperation {
-	mutationPatch := make([]types.PatchOperation, 0)
+	if len(req.OldObject.Raw) > 0 {
+		oldIngress := networkingv1.Ingress{}
 
-	for ruleID, rule := range ingress.Spec.Rules {
-		if host, ok := m.replaceIngressHost(ingress, rule.Host); ok {
-			mutationPatch = append(mutationPatch, types.PatchOperation{
-				Op:    "replace",
-				Path:  fmt.Sprintf("/spec/rules/%d/host", ruleID),
-				Value: host,
-			})
+		if err := json.Unmarshal(req.OldObject.Raw, &oldIngress); err != nil {
+			return m.mutateError(ingressObj.Name, err)
 		}
+
+		oldHosts = ingress.GetRuleHosts(&oldIngress)
 	}
 
-	for tlsID, tls := range ingress.Spec.TLS {
-		for hostID, host := range tls.Hosts {
-			if newHost, ok := m.replaceIngressHost(ingress, host); ok {
-				mutationPatch = append(mutationPatch, types.PatchOperation{
-					Op:    "replace",
-					Path:  fmt.Sprintf("/spec/tls/%d/hosts/%d", tlsID, hostID),
-					Value: newHost,
-				})
-			}
+	warnings, err := ingress.CheckDuplicateHosts(req.Namespace, hosts, oldHosts, config.Get().GetIngressHostPolicy())
+	if err != nil {
+		var denyError *types.DenyError
+		if errors.As(err, &denyError) {
+			return m.mutateDeny(req.Namespace, denyError)
 		}
+
+		return m.mutateError(ingressObj.Name, err)
 	}
 
-	return mutationPatch
+	if ignored {
+		metrics.MutationsIgnored.WithLabelValues(ingressObj.Name).Inc()
+
+		return &admissionv1.AdmissionResponse{
+			Allowed: true,
+			Warnings: append(warnings,
+				fmt.Sprintf("%s, ingress %s", types.WarningObjectDoedNotNeedMutation, ingressObj.Name),
+			),
+		}
+	}
+
+	mutationPatch, err := ingress.NewPatch(ingressInfo, &ingressObj, *config.Get().IngressSuffix)
+	if err != nil {
+		return m.mutateError(ingressObj.Name, err)
+	}
+
+	// annotations can be added by ingress rules, whole map can not be replaced
+	if m.patchContainsPath(mutationPatch, "/metadata/annotations") {
+		injected := map[string]string{types.AnnotationInjected: "true"}
+
+		mutationPatch = append(mutationPatch, types.NewMetadataPatch("/metadata/annotations", map[string]string{}, injected)...)
+	} else {
+		mutationPatch = append(mutationPatch, m.injectAnnotation(ingressObj.Annotations))
+	}
+
+	patchBytes, err := json.Marshal(mutationPatch)
+	if err != nil {
+		return m.mutateError(ingressObj.Name, err)
+	}
+
+	return &admissionv1.AdmissionResponse{
+		Allowed: true,
+		Result: &metav1.Status{
+			Status: metav1.StatusSuccess,
+		},
+		Warnings: warnings,
+		Patch:    patchBytes,
+		PatchType: func() *admissionv1.PatchType {
+			return utils.Pnt(admissionv1.PatchTypeJSONPatch)
+		}(),
+	}
 }
 
-func (m *Mutation) mutateIngress(_ context.Context, input *MutateInput) *admissionv1.AdmissionResponse {
+// mutate gateway api HTTPRoute and GRPCRoute.
+func (m *Mutation) mutateRoute(_ context.Context, input *MutateInput) *admissionv1.AdmissionResponse {
 	req := input.AdmissionReview.Request
 
-	ingress := networkingv1.Ingress{}
+	route := types.GatewayRoute{}
 
-	if err := json.Unmarshal(req.Object.Raw, &ingress); err != nil {
-		return m.mutateError(ingress.Name, err)
+	if err := json.Unmarshal(req.Object.Raw, &route); err != nil {
+		return m.mutateError(route.Name, err)
 	}
 
-	if m.checkIgnoreAnnotation(ingress.Annotations) {
-		metrics.MutationsIgnored.WithLabelValues(ingress.Name).Inc()
+	if m.checkIgnoreAnnotation(route.Annotations) {
+		metrics.MutationsIgnored.WithLabelValues(route.Name).Inc()
 
 		return &admissionv1.AdmissionResponse{
 			Allowed: true,
 			Warnings: []string{
-				fmt.Sprintf("%s, ingress %s", types.WarningObjectDoedNotNeedMutation, ingress.Name),
+				fmt.Sprintf("%s, %s %s", types.WarningObjectDoedNotNeedMutation, req.Resource.Resource, route.Name),
 			},
 		}
 	}
 
-	mutationPatch := make([]types.PatchOperation, 0)
-
-	mutationPatch = append(mutationPatch, m.mutateIngressHosts(ingress)...)
-	mutationPatch = append(mutationPatch, m.injectAnnotation(ingress.Annotations))
+	mutationPatch := ingress.NewRoutePatch(&route, *config.Get().IngressSuffix)
+	mutationPatch = append(mutationPatch, m.injectAnnotation(route.Annotations))
 
 	patchBytes, err := json.Marshal(mutationPatch)
 	if err != nil {
-		return m.mutateError(ingress.Name, err)
+		return m.mutateError(route.Name, err)
 	}
 
 	return &admissionv1.AdmissionResponse{
@@ -320,6 +384,9 @@ func (m *Mutation) mutateNamespace(ctx context.Context, input *MutateInput) *adm
 
 // mutate pod.
 func (m *Mutation) mutatePod(ctx context.Context, input *MutateInput) *admissionv1.AdmissionResponse { //nolint:funlen,cyclop,lll
+	// registry timeout is counted from admission start, not for every image
+	ctx = registry.WithAdmissionStart(ctx)
+
 	namespace, err := input.GetNamespace(ctx)
 	if err != nil {
 		return m.mutateError("namespace not found", err)
@@ -333,26 +400,167 @@ func (m *Mutation) mutatePod(ctx context.Context, input *MutateInput) *admission
 		return m.mutateError(namespace.Name, err)
 	}
 
-	if m.checkIgnoreAnnotation(pod.Annotations) {
-		metrics.MutationsIgnored.WithLabelValues(namespace.Name).Inc()
+	ephemeralContainersUpdate := req.SubResource == subResourceEphemeralContainers
 
-		return &admissionv1.AdmissionResponse{
-			Allowed: true,
-			Warnings: []string{
-				fmt.Sprintf("%s, pod %s/%s", types.WarningObjectDoedNotNeedMutation, namespace.Name, pod.Name),
-			},
+	// only policy deny blocks ignored pod, other errors do not change ignored pod
+	ignored := m.checkIgnoreAnnotation(pod.Annotations)
+
+	containerInfos, err := m.getContainerInfos(req, namespace, &pod)
+	if err != nil {
+		if ignored {
+			log.WithError(err).Warn("error getting containers of ignored pod")
+
+			return m.mutateIgnoredPod(namespace.Name, &pod, nil)
+		}
+
+		return m.mutateError(namespace.Name, err)
+	}
+
+	// policies are checked before ignore annotation, so they can not be skipped by pod owner
+	for _, containerInfo := range containerInfos {
+		if err := m.checkPolicies(ctx, containerInfo, ephemeralContainersUpdate, ignored); err != nil {
+			var denyError *types.DenyError
+			if errors.As(err, &denyError) {
+				return m.mutateDeny(namespace.Name, denyError)
+			}
+
+			if ignored {
+				log.WithError(err).Warn("error checking policies of ignored pod")
+
+				continue
+			}
+
+			return m.mutateError(namespace.Name, err)
 		}
 	}
 
+	if ignored {
+		return m.mutateIgnoredPod(namespace.Name, &pod, containerInfos)
+	}
+
+	warnings := make([]string, 0)
+
 	mutationPatch := make([]types.PatchOperation, 0)
 
+	for _, containerInfo := range containerInfos {
+		pathOps, err := patch.NewPatch(ctx, containerInfo)
+		if err != nil {
+			var denyError *types.DenyError
+			if errors.As(err, &denyError) {
+				return m.mutateDeny(namespace.Name, denyError)
+			}
+
+			return m.mutateError(namespace.Name, err)
+		}
+
+		warnings = append(warnings, containerInfo.Warnings...)
+
+		for _, pathOp := range pathOps {
+			if m.patchContains(mutationPatch, pathOp) {
+				log.Debugf("patch already exists: %s", pathOp)
+			} else {
+				mutationPatch = append(mutationPatch, pathOp)
+			}
+		}
+	}
+
+	// grace period of last container can be lower than preStop of other containers
+	mutationPatch = probes.MergeTerminationGracePeriod(mutationPatch)
+
+	// if no patches found return empty response
+	if len(mutationPatch) == 0 {
+		return &admissionv1.AdmissionResponse{
+			Allowed:  true,
+			Warnings: append(warnings, types.WarningNoPatchGenerated),
+		}
+	}
+
+	// pod metadata can not be changed with ephemeralcontainers subresource
+	if !ephemeralContainersUpdate {
+		// annotations can be added by patches, whole map can not be replaced
+		if m.patchContainsPath(mutationPatch, "/metadata/annotations") {
+			injected := map[string]string{types.AnnotationInjected: "true"}
+
+			mutationPatch = append(mutationPatch, types.NewMetadataPatch("/metadata/annotations", map[string]string{}, injected)...)
+		} else {
+			mutationPatch = append(mutationPatch, m.injectAnnotation(pod.Annotations))
+		}
+	}
+
+	patchBytes, err := json.Marshal(mutationPatch)
+	if err != nil {
+		return m.mutateError(namespace.Name, err)
+	}
+
+	log.Debugf("patch=%s", string(patchBytes))
+
+	metrics.MutationsTotal.WithLabelValues(namespace.Name).Inc()
+
+	return &admissionv1.AdmissionResponse{
+		Allowed: true,
+		Result: &metav1.Status{
+			Status: metav1.StatusSuccess,
+		},
+		Warnings: warnings,
+		Patch:    patchBytes,
+		PatchType: func() *admissionv1.PatchType {
+			return utils.Pnt(admissionv1.PatchTypeJSONPatch)
+		}(),
+	}
+}
+
+// check policies that deny pod, patches that can fix policy violation are not applied to ignored pod.
+func (m *Mutation) checkPolicies(ctx context.Context, containerInfo *types.ContainerInfo, ephemeralContainersUpdate, ignored bool) error { //nolint:lll
+	if err := imagepolicy.Check(ctx, containerInfo, ignored); err != nil {
+		return errors.Wrap(err, "error checking image policy")
+	}
+
+	// pod scheduling fields can not be changed with ephemeralcontainers subresource
+	if ephemeralContainersUpdate {
+		return nil
+	}
+
+	if err := namespacepolicy.Check(containerInfo); err != nil {
+		return errors.Wrap(err, "error checking namespace policy")
+	}
+
+	return nil
+}
+
+// pod with ignore annotation is allowed without patch.
+func (m *Mutation) mutateIgnoredPod(namespaceName string, pod *corev1.Pod, containerInfos []*types.ContainerInfo) *admissionv1.AdmissionResponse { //nolint:lll
+	metrics.MutationsIgnored.WithLabelValues(namespaceName).Inc()
+
+	warnings := make([]string, 0)
+
+	for _, containerInfo := range containerInfos {
+		warnings = append(warnings, containerInfo.Warnings...)
+	}
+
+	return &admissionv1.AdmissionResponse{
+		Allowed: true,
+		Warnings: append(warnings,
+			fmt.Sprintf("%s, pod %s/%s", types.WarningObjectDoedNotNeedMutation, namespaceName, pod.Name),
+		),
+	}
+}
+
+// returns containers for mutation with selected rules, containers without rules are skipped.
+func (m *Mutation) getContainerInfos(req *admissionv1.AdmissionRequest, namespace *corev1.Namespace, pod *corev1.Pod) ([]*types.ContainerInfo, error) { //nolint:lll
 	var ownerKind, ownerName string
 	if len(pod.OwnerReferences) > 0 {
 		ownerKind = pod.OwnerReferences[0].Kind
 		ownerName = pod.OwnerReferences[0].Name
 	}
 
-	for _, podContainer := range types.PodContainersFromPod(namespace, &pod) {
+	podContainers, err := m.getContainersForMutation(req, namespace, pod)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting containers")
+	}
+
+	result := make([]*types.ContainerInfo, 0)
+
+	for _, podContainer := range podContainers {
 		containerInfo := &types.ContainerInfo{
 			OwnerKind:            ownerKind,
 			OwnerName:            ownerName,
@@ -369,7 +577,7 @@ func (m *Mutation) mutatePod(ctx context.Context, input *MutateInput) *admission
 
 		imageInfo, err := GetImageInfo(podContainer.Container.Image)
 		if err != nil {
-			return m.mutateError(namespace.Name, err)
+			return nil, errors.Wrap(err, "error parsing image")
 		}
 
 		containerInfo.Image = imageInfo
@@ -380,7 +588,7 @@ func (m *Mutation) mutatePod(ctx context.Context, input *MutateInput) *admission
 		for _, rule := range config.Get().Rules {
 			match, err := conditions.Check(containerInfo, rule.Conditions)
 			if err != nil {
-				return m.mutateError(namespace.Name, err)
+				return nil, errors.Wrap(err, "error checking conditions")
 			}
 
 			if match {
@@ -393,49 +601,50 @@ func (m *Mutation) mutatePod(ctx context.Context, input *MutateInput) *admission
 			continue
 		}
 
-		pathOps, err := patch.NewPatch(ctx, containerInfo)
-		if err != nil {
-			return m.mutateError(namespace.Name, err)
-		}
+		result = append(result, containerInfo)
+	}
 
-		for _, pathOp := range pathOps {
-			if m.patchContains(mutationPatch, pathOp) {
-				log.Debugf("patch already exists: %s", pathOp)
-			} else {
-				mutationPatch = append(mutationPatch, pathOp)
+	return result, nil
+}
+
+const subResourceEphemeralContainers = "ephemeralcontainers"
+
+// ephemeral containers are added with ephemeralcontainers subresource,
+// existing ephemeral containers can not be changed.
+func (m *Mutation) getContainersForMutation(req *admissionv1.AdmissionRequest, namespace *corev1.Namespace, pod *corev1.Pod) ([]*types.PodContainer, error) { //nolint:lll
+	result := make([]*types.PodContainer, 0)
+
+	if req.SubResource != subResourceEphemeralContainers {
+		for _, podContainer := range types.PodContainersFromPod(namespace, pod) {
+			if podContainer.Type != types.PodContainerTypeEphemeralContainer {
+				result = append(result, podContainer)
 			}
 		}
-	}
 
-	// if no patches found return empty response
-	if len(mutationPatch) == 0 {
-		return &admissionv1.AdmissionResponse{
-			Allowed:  true,
-			Warnings: []string{types.WarningNoPatchGenerated},
-		}
+		return result, nil
 	}
 
-	mutationPatch = append(mutationPatch, m.injectAnnotation(pod.Annotations))
+	existingContainers := make(map[string]bool)
 
-	patchBytes, err := json.Marshal(mutationPatch)
-	if err != nil {
-		return m.mutateError(namespace.Name, err)
-	}
+	if len(req.OldObject.Raw) > 0 {
+		oldPod := corev1.Pod{}
 
-	log.Debugf("patch=%s", string(patchBytes))
+		if err := json.Unmarshal(req.OldObject.Raw, &oldPod); err != nil {
+			return nil, errors.Wrap(err, "error unmarshal old pod")
+		}
 
-	metrics.MutationsTotal.WithLabelValues(namespace.Name).Inc()
+		for _, container := range oldPod.Spec.EphemeralContainers {
+			existingContainers[container.Name] = true
+		}
+	}
 
-	return &admissionv1.AdmissionResponse{
-		Allowed: true,
-		Result: &metav1.Status{
-			Status: metav1.StatusSuccess,
-		},
-		Patch: patchBytes,
-		PatchType: func() *admissionv1.PatchType {
-			return utils.Pnt(admissionv1.PatchTypeJSONPatch)
-		}(),
+	for _, podContainer := range types.PodContainersFromPod(namespace, pod) {
+		if podContainer.Type == types.PodContainerTypeEphemeralContainer && !existingContainers[podContainer.Container.Name] {
+			result = append(result, podContainer)
+		}
 	}
+
+	return result, nil
 }
 
 func (m *Mutation) patchContains(patches []types.PatchOperation, patch types.PatchOperation) bool {
@@ -450,6 +659,17 @@ func (m *Mutation) patchContains(patches []types.PatchOperation, patch types.Pat
 	return false
 }
 
+// check that patches change path or its children.
+func (m *Mutation) patchContainsPath(patches []types.PatchOperation, path string) bool {
+	for _, p := range patches {
+		if p.Path == path || strings.HasPrefix(p.Path, path+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
 // some objects does not need mutation
 // pod-admission-controller/ignore=true.
 func (m *Mutation) checkIgnoreAnnotation(annotations map[string]string) bool {
@@ -506,6 +726,23 @@ func (m *Mutation) mutateError(namespaceName string, err error) *admissionv1.Adm
 	}
 }
 
+// deny admission request.
+func (m *Mutation) mutateDeny(namespaceName string, err error) *admissionv1.AdmissionResponse {
+	log.WithError(err).Warn("Denied mutation")
+
+	metrics.MutationsDenied.WithLabelValues(namespaceName).Inc()
+
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Reason:  metav1.StatusReasonForbidden,
+			Code:    http.StatusForbidden,
+			Message: err.Error(),
+		},
+	}
+}
+
 // parse http request.
 func ParseRequest(ctx context.Context, body []byte) ([]byte, error) {
 	obj, gvk, err := deserializer.Decode(body, nil, &admissionv1.AdmissionReview{})
@@ -540,31 +777,7 @@ func ParseRequest(ctx context.Context, body []byte) ([]byte, error) {
 
 // parse image to repo, slug path and tag.
 func GetImageInfo(image string) (*types.ContainerImage, error) {
-	// check if image is has fully qualified name
-	refName, err := reference.ParseNormalizedNamed(image)
-	if err != nil {
-		return nil, errors.Wrapf(err, "error parsing image name %s", image)
-	}
-
-	// get only lowered the image name
-	imageName := strings.ToLower(reference.Path(refName))
-
-	// replace all non alphanumeric characters with a dash
-	imageName = regexp.MustCompile("[^A-Za-z0-9]+").ReplaceAllString(imageName, "-")
-
-	result := types.ContainerImage{
-		Domain: reference.Domain(refName),
-		Path:   reference.Path(refName),
-		Name:   image,
-		Slug:   strings.Trim(imageName, "-"),
-		Tag:    "latest",
-	}
-
-	if tag, ok := refName.(reference.Tagged); ok {
-		result.Tag = tag.Tag()
-	}
-
-	return &result, nil
+	return types.NewContainerImage(image)
 }
 
 func TestPOD(ctx context.Context, namespace, podName string) ([]byte, error) {
@@ -16,6 +16,7 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"reflect"
 	"strings"
@@ -24,8 +25,12 @@ import (
 	"github.com/maksim-paskal/pod-admission-controller/pkg/api"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/config"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/utils"
+	jsonpatch "gopkg.in/evanphx/json-patch.v4"
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 )
@@ -122,52 +127,605 @@ func TestMutation(t *testing.T) { //nolint:funlen
 	}
 }
 
+func TestMutationDeny(t *testing.T) {
+	if err := flag.Set("config", "testdata/config-test.yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := config.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	podJSON, err := json.Marshal(corev1.Pod{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name:  "test-deny",
+					Image: "test/test:test",
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{
+							corev1.ResourceCPU: resource.MustParse("1m"),
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := api.MutateInput{
+		Namespace: &corev1.Namespace{},
+		AdmissionReview: &admissionv1.AdmissionReview{
+			Request: &admissionv1.AdmissionRequest{
+				Namespace: "test",
+				Resource: metav1.GroupVersionResource{
+					Resource: "pods",
+					Version:  "v1",
+				},
+				Object: runtime.RawExtension{
+					Raw: podJSON,
+				},
+			},
+		},
+	}
+
+	response := api.NewMutation().Mutate(t.Context(), &input)
+
+	if response.Allowed {
+		t.Fatal("pod must be denied")
+	}
+
+	if response.Result.Code != http.StatusForbidden {
+		t.Fatalf("code must be %d, got %d", http.StatusForbidden, response.Result.Code)
+	}
+
+	if !strings.Contains(response.Result.Message, "test-deny") {
+		t.Fatalf("message must contain container name, got %s", response.Result.Message)
+	}
+}
+
+func TestMutationImagePolicy(t *testing.T) {
+	if err := flag.Set("config", "testdata/config-test.yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := config.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	// policy can not be skipped with ignore annotation
+	for _, annotations := range []map[string]string{nil, {types.AnnotationIgnore: "true"}} {
+		podJSON, err := json.Marshal(corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: annotations,
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{
+						Name:  "test-image-policy",
+						Image: "alpine:3.12",
+					},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		input := api.MutateInput{
+			Namespace: &corev1.Namespace{},
+			AdmissionReview: &admissionv1.AdmissionReview{
+				Request: &admissionv1.AdmissionRequest{
+					Namespace: "test",
+					Resource: metav1.GroupVersionResource{
+						Resource: "pods",
+						Version:  "v1",
+					},
+					Object: runtime.RawExtension{
+						Raw: podJSON,
+					},
+				},
+			},
+		}
+
+		response := api.NewMutation().Mutate(t.Context(), &input)
+
+		if response.Allowed {
+			t.Fatalf("pod with annotations %v must be denied", annotations)
+		}
+
+		if !strings.Contains(response.Result.Message, "container test-image-policy image alpine:3.12") {
+			t.Fatalf("message must contain container name, got %s", response.Result.Message)
+		}
+	}
+}
+
+func TestMutationMetadata(t *testing.T) {
+	if err := flag.Set("config", "testdata/config-test.yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := config.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	podJSON, err := json.Marshal(corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{"app": "test"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name:  "test-metadata",
+					Image: "alpine:3.12",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := api.MutateInput{
+		Namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test"}},
+		AdmissionReview: &admissionv1.AdmissionReview{
+			Request: &admissionv1.AdmissionRequest{
+				Namespace: "test",
+				Resource: metav1.GroupVersionResource{
+					Resource: "pods",
+					Version:  "v1",
+				},
+				Object: runtime.RawExtension{
+					Raw: podJSON,
+				},
+			},
+		},
+	}
+
+	response := api.NewMutation().Mutate(t.Context(), &input)
+
+	patch, err := jsonpatch.DecodePatch(response.Patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patchedJSON, err := patch.Apply(podJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patchedPod := corev1.Pod{}
+
+	if err := json.Unmarshal(patchedJSON, &patchedPod); err != nil {
+		t.Fatal(err)
+	}
+
+	wantLabels := map[string]string{"app": "test", "team.example.com/name": "test"}
+	if !reflect.DeepEqual(patchedPod.Labels, wantLabels) {
+		t.Fatalf("want labels %v, got %v", wantLabels, patchedPod.Labels)
+	}
+
+	wantAnnotations := map[string]string{"owner": "test", types.AnnotationInjected: "true"}
+	if !reflect.DeepEqual(patchedPod.Annotations, wantAnnotations) {
+		t.Fatalf("want annotations %v, got %v", wantAnnotations, patchedPod.Annotations)
+	}
+}
+
+func TestMutationIngress(t *testing.T) { //nolint:funlen
+	if err := flag.Set("config", "testdata/config-test.yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := config.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	ingressJSON, err := json.Marshal(networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-ingress-rules",
+			Annotations: map[string]string{"app": "test"},
+		},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{{Host: "app."}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := api.MutateInput{
+		Namespace: &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   "test",
+				Labels: map[string]string{"rps": "10"},
+			},
+		},
+		AdmissionReview: &admissionv1.AdmissionReview{
+			Request: &admissionv1.AdmissionRequest{
+				Namespace: "test",
+				Resource: metav1.GroupVersionResource{
+					Group:    "networking.k8s.io",
+					Resource: "ingresses",
+					Version:  "v1",
+				},
+				Object: runtime.RawExtension{
+					Raw: ingressJSON,
+				},
+			},
+		},
+	}
+
+	response := api.NewMutation().Mutate(t.Context(), &input)
+
+	patch, err := jsonpatch.DecodePatch(response.Patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patchedJSON, err := patch.Apply(ingressJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patchedIngress := networkingv1.Ingress{}
+
+	if err := json.Unmarshal(patchedJSON, &patchedIngress); err != nil {
+		t.Fatal(err)
+	}
+
+	wantSpec := networkingv1.IngressSpec{
+		IngressClassName: utils.Pnt("nginx"),
+		Rules:            []networkingv1.IngressRule{{Host: "app.test.example.com"}},
+		TLS:              []networkingv1.IngressTLS{{Hosts: []string{"app.test.example.com"}, SecretName: "wildcard-test"}},
+	}
+	if !reflect.DeepEqual(patchedIngress.Spec, wantSpec) {
+		t.Fatalf("want spec %+v, got %+v", wantSpec, patchedIngress.Spec)
+	}
+
+	wantAnnotations := map[string]string{
+		"app":                                   "test",
+		"nginx.ingress.kubernetes.io/limit-rps": "10",
+		types.AnnotationInjected:                "true",
+	}
+	if !reflect.DeepEqual(patchedIngress.Annotations, wantAnnotations) {
+		t.Fatalf("want annotations %v, got %v", wantAnnotations, patchedIngress.Annotations)
+	}
+}
+
+func TestMutationRoute(t *testing.T) { //nolint:funlen
+	if err := flag.Set("ingress.suffix", "example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if err := flag.Set("ingress.suffix", ""); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	tests := []struct {
+		name        string
+		resource    string
+		annotations map[string]string
+		want        []string
+	}{
+		{
+			name:     "httproute",
+			resource: "httproutes",
+			want:     []string{"test.example.com", "test.com"},
+		},
+		{
+			name:     "grpcroute",
+			resource: "grpcroutes",
+			want:     []string{"test.example.com", "test.com"},
+		},
+		{
+			name:        "ignore annotation",
+			resource:    "httproutes",
+			annotations: map[string]string{types.AnnotationIgnore: "true"},
+			want:        []string{"test.", "test.com"},
+		},
+	}
+
+	for _, tc := range tests {
+		routeJSON, err := json.Marshal(types.GatewayRoute{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Annotations: tc.annotations},
+			Spec:       types.GatewayRouteSpec{Hostnames: []string{"test.", "test.com"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		input := api.MutateInput{
+			AdmissionReview: &admissionv1.AdmissionReview{
+				Request: &admissionv1.AdmissionRequest{
+					Namespace: "test",
+					Resource: metav1.GroupVersionResource{
+						Group:    "gateway.networking.k8s.io",
+						Resource: tc.resource,
+						Version:  "v1",
+					},
+					Object: runtime.RawExtension{
+						Raw: routeJSON,
+					},
+				},
+			},
+		}
+
+		response := api.NewMutation().Mutate(t.Context(), &input)
+		if !response.Allowed {
+			t.Fatalf("%s: route must be allowed, got %+v", tc.name, response.Result)
+		}
+
+		patchedJSON := routeJSON
+
+		if len(response.Patch) > 0 {
+			patch, err := jsonpatch.DecodePatch(response.Patch)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			patchedJSON, err = patch.Apply(routeJSON)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		patchedRoute := types.GatewayRoute{}
+
+		if err := json.Unmarshal(patchedJSON, &patchedRoute); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(patchedRoute.Spec.Hostnames, tc.want) {
+			t.Fatalf("%s: want hostnames %v, got %v", tc.name, tc.want, patchedRoute.Spec.Hostnames)
+		}
+	}
+}
+
+func TestMutationIgnoredPod(t *testing.T) { //nolint:funlen
+	if err := flag.Set("config", "testdata/config-test.yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := config.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		container string
+		image     string
+		allowed   bool
+	}{
+		{name: "image that can not be parsed", container: "test-image-policy", image: "Not Valid:Image", allowed: true},
+		{name: "image policy deny", container: "test-image-policy", image: "docker.io/test:test", allowed: false},
+		{
+			// image host is not replaced in ignored pod
+			name:      "denied registry is not rewritten",
+			container: "test-image-policy-rewrite",
+			image:     "docker.io/test:test",
+			allowed:   false,
+		},
+		{
+			name:      "allowed registry",
+			container: "test-image-policy-rewrite",
+			image:     "registry.example.com/test:test",
+			allowed:   true,
+		},
+	}
+
+	for _, tc := range tests {
+		podJSON, err := json.Marshal(corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{types.AnnotationIgnore: "true"},
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: tc.container, Image: tc.image}},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		input := api.MutateInput{
+			Namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test"}},
+			AdmissionReview: &admissionv1.AdmissionReview{
+				Request: &admissionv1.AdmissionRequest{
+					Namespace: "test",
+					Resource: metav1.GroupVersionResource{
+						Resource: "pods",
+						Version:  "v1",
+					},
+					Object: runtime.RawExtension{
+						Raw: podJSON,
+					},
+				},
+			},
+		}
+
+		response := api.NewMutation().Mutate(t.Context(), &input)
+
+		if response.Allowed != tc.allowed {
+			t.Fatalf("%s: want allowed %t, got %+v", tc.name, tc.allowed, response.Result)
+		}
+
+		if len(response.Patch) > 0 {
+			t.Fatalf("%s: ignored pod must not be patched", tc.name)
+		}
+	}
+}
+
+func TestMutationEphemeralContainers(t *testing.T) { //nolint:funlen
+	if err := flag.Set("config", "testdata/config-test.yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := config.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	oldPod := corev1.Pod{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name:  "test",
+					Image: "test/test:test",
+				},
+			},
+			EphemeralContainers: []corev1.EphemeralContainer{
+				{
+					EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+						Name:  "debugger",
+						Image: "busybox",
+					},
+				},
+			},
+		},
+	}
+
+	oldPodJSON, err := json.Marshal(oldPod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := oldPod.DeepCopy()
+	pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name:  "test-ephemeral",
+			Image: "busybox",
+		},
+	})
+
+	podJSON, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := api.MutateInput{
+		Namespace: &corev1.Namespace{},
+		AdmissionReview: &admissionv1.AdmissionReview{
+			Request: &admissionv1.AdmissionRequest{
+				Namespace:   "test",
+				SubResource: "ephemeralcontainers",
+				Resource: metav1.GroupVersionResource{
+					Resource: "pods",
+					Version:  "v1",
+				},
+				Object: runtime.RawExtension{
+					Raw: podJSON,
+				},
+				OldObject: runtime.RawExtension{
+					Raw: oldPodJSON,
+				},
+			},
+		},
+	}
+
+	response := api.NewMutation().Mutate(t.Context(), &input)
+
+	if response.Result.Status != "Success" {
+		t.Fatalf("status must be Success, got %s, %s", response.Result.Status, response.Result.Message)
+	}
+
+	// only new ephemeral container must be mutated
+	required := `[{"op":"replace","path":"/spec/ephemeralContainers/1/image","value":"mirror.example.com/library/busybox"}]`
+
+	if string(response.Patch) != required {
+		t.Fatalf("must be equal\n\nin=(%s)\n\nout=(%s)", required, string(response.Patch))
+	}
+}
+
 func TestGetImageInfo(t *testing.T) {
 	t.Parallel()
 
 	tests := make(map[string]*types.ContainerImage)
 
 	tests["10.10.10.10:5000/product/main/backend:release-20220516-1"] = &types.ContainerImage{
-		Domain: "10.10.10.10:5000",
-		Path:   "product/main/backend",
-		Name:   "10.10.10.10:5000/product/main/backend:release-20220516-1",
-		Slug:   "product-main-backend",
-		Tag:    "release-20220516-1",
+		Domain:       "10.10.10.10:5000",
+		Path:         "product/main/backend",
+		Name:         "10.10.10.10:5000/product/main/backend:release-20220516-1",
+		Slug:         "product-main-backend",
+		Tag:          "release-20220516-1",
+		Repository:   "10.10.10.10:5000/product/main/backend",
+		ShortName:    "backend",
+		Organization: "product/main",
 	}
 	tests["10.10.10.10:5000/product/main/front:release-20220516-1"] = &types.ContainerImage{
-		Domain: "10.10.10.10:5000",
-		Path:   "product/main/front",
-		Name:   "10.10.10.10:5000/product/main/front:release-20220516-1",
-		Slug:   "product-main-front",
-		Tag:    "release-20220516-1",
+		Domain:       "10.10.10.10:5000",
+		Path:         "product/main/front",
+		Name:         "10.10.10.10:5000/product/main/front:release-20220516-1",
+		Slug:         "product-main-front",
+		Tag:          "release-20220516-1",
+		Repository:   "10.10.10.10:5000/product/main/front",
+		ShortName:    "front",
+		Organization: "product/main",
 	}
 	tests["domain.com/hipages/php-fpm_exporter:1"] = &types.ContainerImage{
-		Domain: "domain.com",
-		Path:   "hipages/php-fpm_exporter",
-		Name:   "domain.com/hipages/php-fpm_exporter:1",
-		Slug:   "hipages-php-fpm-exporter",
-		Tag:    "1",
+		Domain:       "domain.com",
+		Path:         "hipages/php-fpm_exporter",
+		Name:         "domain.com/hipages/php-fpm_exporter:1",
+		Slug:         "hipages-php-fpm-exporter",
+		Tag:          "1",
+		Repository:   "domain.com/hipages/php-fpm_exporter",
+		ShortName:    "php-fpm_exporter",
+		Organization: "hipages",
 	}
 	tests["domain.com/paskalmaksim/envoy-docker-image:v0.3.8"] = &types.ContainerImage{
-		Domain: "domain.com",
-		Path:   "paskalmaksim/envoy-docker-image",
-		Name:   "domain.com/paskalmaksim/envoy-docker-image:v0.3.8",
-		Slug:   "paskalmaksim-envoy-docker-image",
-		Tag:    "v0.3.8",
+		Domain:       "domain.com",
+		Path:         "paskalmaksim/envoy-docker-image",
+		Name:         "domain.com/paskalmaksim/envoy-docker-image:v0.3.8",
+		Slug:         "paskalmaksim-envoy-docker-image",
+		Tag:          "v0.3.8",
+		Repository:   "domain.com/paskalmaksim/envoy-docker-image",
+		ShortName:    "envoy-docker-image",
+		Organization: "paskalmaksim",
+		Semver:       types.ImageSemver{Valid: true, Major: 0, Minor: 3, Patch: 8},
 	}
 	tests["paskalmaksim/envoy-docker-image:v0.3.8"] = &types.ContainerImage{
-		Domain: "docker.io",
-		Path:   "paskalmaksim/envoy-docker-image",
-		Name:   "paskalmaksim/envoy-docker-image:v0.3.8",
-		Slug:   "paskalmaksim-envoy-docker-image",
-		Tag:    "v0.3.8",
+		Domain:       "docker.io",
+		Path:         "paskalmaksim/envoy-docker-image",
+		Name:         "paskalmaksim/envoy-docker-image:v0.3.8",
+		Slug:         "paskalmaksim-envoy-docker-image",
+		Tag:          "v0.3.8",
+		Repository:   "paskalmaksim/envoy-docker-image",
+		ShortName:    "envoy-docker-image",
+		Organization: "paskalmaksim",
+		Semver:       types.ImageSemver{Valid: true, Major: 0, Minor: 3, Patch: 8},
 	}
 	tests["paskalmaksim/envoy-docker-image"] = &types.ContainerImage{
-		Domain: "docker.io",
-		Path:   "paskalmaksim/envoy-docker-image",
-		Name:   "paskalmaksim/envoy-docker-image",
-		Slug:   "paskalmaksim-envoy-docker-image",
-		Tag:    "latest",
+		Domain:       "docker.io",
+		Path:         "paskalmaksim/envoy-docker-image",
+		Name:         "paskalmaksim/envoy-docker-image",
+		Slug:         "paskalmaksim-envoy-docker-image",
+		Tag:          "latest",
+		Repository:   "paskalmaksim/envoy-docker-image",
+		ShortName:    "envoy-docker-image",
+		Organization: "paskalmaksim",
+		IsLatest:     true,
+	}
+	tests["nginx@sha256:8fd21d59428507671ce0fb47f818b1d859c92d2ad07bb7c947268d433030ba98"] = &types.ContainerImage{
+		Domain:       "docker.io",
+		Path:         "library/nginx",
+		Name:         "nginx@sha256:8fd21d59428507671ce0fb47f818b1d859c92d2ad07bb7c947268d433030ba98",
+		Slug:         "library-nginx",
+		Digest:       "sha256:8fd21d59428507671ce0fb47f818b1d859c92d2ad07bb7c947268d433030ba98",
+		Repository:   "nginx",
+		ShortName:    "nginx",
+		Organization: "library",
+	}
+	tests["registry.com/app:1.2.3-rc.1@sha256:8fd21d59428507671ce0fb47f818b1d859c92d2ad07bb7c947268d433030ba98"] = &types.ContainerImage{ //nolint:lll
+		Domain:     "registry.com",
+		Path:       "app",
+		Name:       "registry.com/app:1.2.3-rc.1@sha256:8fd21d59428507671ce0fb47f818b1d859c92d2ad07bb7c947268d433030ba98",
+		Slug:       "app",
+		Tag:        "1.2.3-rc.1",
+		Digest:     "sha256:8fd21d59428507671ce0fb47f818b1d859c92d2ad07bb7c947268d433030ba98",
+		Repository: "registry.com/app",
+		ShortName:  "app",
+		Semver:     types.ImageSemver{Valid: true, Major: 1, Minor: 2, Patch: 3, Prerelease: "rc.1"},
 	}
 
 	for test, requre := range tests {
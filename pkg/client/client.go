@@ -16,6 +16,7 @@ import (
 	"github.com/maksim-paskal/pod-admission-controller/pkg/config"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -23,8 +24,9 @@ import (
 )
 
 var (
-	clientset  *kubernetes.Clientset
-	restconfig *rest.Config
+	clientset     kubernetes.Interface
+	dynamicClient dynamic.Interface
+	restconfig    *rest.Config
 )
 
 // get kubernetes client.
@@ -55,9 +57,24 @@ func Init() error {
 		log.WithError(err).Fatal()
 	}
 
+	dynamicClient, err = dynamic.NewForConfig(restconfig)
+	if err != nil {
+		return errors.Wrap(err, "error in dynamic.NewForConfig")
+	}
+
 	return nil
 }
 
-func KubeClient() *kubernetes.Clientset {
+func KubeClient() kubernetes.Interface {
 	return clientset
 }
+
+// set kubernetes client, used in tests.
+func SetKubeClient(kubeClient kubernetes.Interface) {
+	clientset = kubeClient
+}
+
+// set kubernetes dynamic client, used in tests.
+func SetDynamicClient(client dynamic.Interface) {
+	dynamicClient = client
+}
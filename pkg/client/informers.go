@@ -0,0 +1,189 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package client
+
+import (
+	"context"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/config"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+)
+
+// custom resources are watched only if some rule uses them.
+var VerticalPodAutoscalerResource = schema.GroupVersionResource{
+	Group:    "autoscaling.k8s.io",
+	Version:  "v1",
+	Resource: "verticalpodautoscalers",
+}
+
+var (
+	informerFactory        informers.SharedInformerFactory
+	dynamicInformerFactory dynamicinformer.DynamicSharedInformerFactory
+)
+
+// start informers and wait for caches to sync.
+func StartInformers(ctx context.Context) error {
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+
+	// informers must be registered before factory starts
+	factory.Core().V1().LimitRanges().Informer()
+
+	// pods are watched only if some rule uses them
+	if usesPodInformer() {
+		factory.Core().V1().Pods().Informer()
+	}
+
+	if usesVolumeInformers() {
+		factory.Core().V1().PersistentVolumeClaims().Informer()
+		factory.Core().V1().PersistentVolumes().Informer()
+	}
+
+	if usesClassInformers() {
+		factory.Scheduling().V1().PriorityClasses().Informer()
+		factory.Node().V1().RuntimeClasses().Informer()
+	}
+
+	if usesIngressInformer() {
+		factory.Networking().V1().Ingresses().Informer()
+	}
+
+	factory.Start(ctx.Done())
+
+	log.Info("Waiting for informers to sync...")
+
+	for informerType, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return errors.Errorf("informer %s is not synced", informerType)
+		}
+	}
+
+	informerFactory = factory
+
+	if dynamicClient == nil {
+		return nil
+	}
+
+	dynamicFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, 0)
+
+	for _, resource := range getDynamicResources() {
+		// informer for not installed custom resource will never sync
+		if !isResourceServed(resource) {
+			log.Warnf("resource %s is not served by apiserver, skipping informer", resource.String())
+
+			continue
+		}
+
+		dynamicFactory.ForResource(resource).Informer()
+	}
+
+	dynamicFactory.Start(ctx.Done())
+
+	for resource, synced := range dynamicFactory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return errors.Errorf("informer %s is not synced", resource.String())
+		}
+	}
+
+	dynamicInformerFactory = dynamicFactory
+
+	return nil
+}
+
+// return custom resources that are used in rules.
+func getDynamicResources() []schema.GroupVersionResource {
+	result := make([]schema.GroupVersionResource, 0)
+
+	for _, rule := range config.Get().Rules {
+		if rule.AddDefaultResources.FromVPA.Enabled {
+			result = append(result, VerticalPodAutoscalerResource)
+
+			break
+		}
+	}
+
+	return result
+}
+
+// scheduling profiles count pods of the same owner.
+func usesPodInformer() bool {
+	for _, rule := range config.Get().Rules {
+		if rule.SchedulingProfiles.Enabled {
+			return true
+		}
+	}
+
+	return false
+}
+
+// volume zone affinity resolves zones of pod volumes.
+func usesVolumeInformers() bool {
+	for _, rule := range config.Get().Rules {
+		if rule.VolumeZoneAffinity.Enabled {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pod classes are checked for existence.
+func usesClassInformers() bool {
+	for _, rule := range config.Get().Rules {
+		if rule.PodClasses.Enabled {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ingress hosts are checked with ingresses in other namespaces.
+func usesIngressInformer() bool {
+	return len(config.Get().GetIngressHostPolicy()) > 0
+}
+
+func isResourceServed(resource schema.GroupVersionResource) bool {
+	resources, err := clientset.Discovery().ServerResourcesForGroupVersion(resource.GroupVersion().String())
+	if err != nil {
+		log.WithError(err).Warnf("error getting resources for %s", resource.GroupVersion().String())
+
+		return false
+	}
+
+	for _, apiResource := range resources.APIResources {
+		if apiResource.Name == resource.Resource {
+			return true
+		}
+	}
+
+	return false
+}
+
+// return shared informers, nil if informers are not started.
+func Informers() informers.SharedInformerFactory {
+	return informerFactory
+}
+
+// return shared informers for custom resources, nil if informers are not started.
+func DynamicInformers() dynamicinformer.DynamicSharedInformerFactory {
+	return dynamicInformerFactory
+}
+
+// set shared informers, used in tests.
+func SetInformers(factory informers.SharedInformerFactory) {
+	informerFactory = factory
+}
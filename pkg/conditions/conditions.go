@@ -22,8 +22,8 @@ import (
 	"github.com/pkg/errors"
 )
 
-func ParseConditionKey(containerInfo *types.ContainerInfo, condition types.Condition) (string, error) {
-	key, err := template.Get(containerInfo, fmt.Sprintf("{{ %s }}", condition.Key))
+func ParseConditionKey(data any, condition types.Condition) (string, error) {
+	key, err := template.Get(data, fmt.Sprintf("{{ %s }}", condition.Key))
 	if err != nil {
 		return "", errors.Wrapf(err, "error getting key %s", condition.Key)
 	}
@@ -31,7 +31,7 @@ func ParseConditionKey(containerInfo *types.ContainerInfo, condition types.Condi
 	return key, nil
 }
 
-func Check(containerInfo *types.ContainerInfo, conditions []types.Condition) (bool, error) { //nolint:cyclop,funlen,gocognit,lll
+func Check(data any, conditions []types.Condition) (bool, error) { //nolint:cyclop,funlen,gocognit,lll
 	if len(conditions) == 0 {
 		return true, nil
 	}
@@ -43,7 +43,7 @@ func Check(containerInfo *types.ContainerInfo, conditions []types.Condition) (bo
 			return false, errors.Errorf("empty key")
 		}
 
-		key, err := ParseConditionKey(containerInfo, condition)
+		key, err := ParseConditionKey(data, condition)
 		if err != nil {
 			return false, errors.Wrap(err, "error matching key")
 		}
@@ -488,3 +488,43 @@ func TestCheckConditions(t *testing.T) { //nolint:funlen,maintidx
 		})
 	}
 }
+
+func TestImageConditions(t *testing.T) {
+	t.Parallel()
+
+	image, err := types.NewContainerImage("registry.com/team/app:v2.1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	containerInfo := &types.ContainerInfo{
+		Image: image,
+	}
+
+	tests := map[string]string{
+		".Image.ShortName":    "app",
+		".Image.Organization": "team",
+		".Image.Repository":   "registry.com/team/app",
+		".Image.Semver.Major": "2",
+		".Image.Semver.Valid": "true",
+		".Image.IsLatest":     "false",
+		`printf "%d.%d" .Image.Semver.Major .Image.Semver.Minor`: "2.1",
+	}
+
+	for key, value := range tests {
+		match, err := conditions.Check(containerInfo, []types.Condition{
+			{
+				Key:      key,
+				Operator: types.OperatorEqual,
+				Value:    value,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !match {
+			t.Fatalf("key %s must be %s", key, value)
+		}
+	}
+}
@@ -101,6 +101,9 @@ type Params struct {
 	SentryDSN          *string
 	CreateSecrets      []*types.CreateSecret
 	IngressSuffix      *string
+	IngressRules       []*types.IngressRule
+	// warn or deny ingress hosts that are used in other namespaces
+	IngressHostPolicy *string
 }
 
 var param = Params{
@@ -115,12 +118,21 @@ var param = Params{
 	KeyFile:            flag.String("key", "server.key", "key file"),
 	SentryDSN:          flag.String("sentry.dsn", os.Getenv("SENTRY_DSN"), "sentry DSN for error reporting"),
 	IngressSuffix:      flag.String("ingress.suffix", os.Getenv("INGRESS_SUFFIX"), "default ingress suffix"),
+	IngressHostPolicy:  flag.String("ingress.duplicateHostPolicy", os.Getenv("INGRESS_DUPLICATE_HOST_POLICY"), "warn or deny ingress hosts that are used in other namespaces"), //nolint:lll
 }
 
 func (p *Params) GetGracePeriod() time.Duration {
 	return time.Duration(*p.GracePeriodSeconds) * time.Second
 }
 
+func (p *Params) GetIngressHostPolicy() types.DuplicateHostPolicy {
+	if p.IngressHostPolicy == nil {
+		return ""
+	}
+
+	return types.DuplicateHostPolicy(*p.IngressHostPolicy)
+}
+
 func Get() *Params {
 	return &param
 }
@@ -151,11 +163,77 @@ func Load() error {
 		}
 	}
 
+	for ruleID, rule := range param.IngressRules {
+		for conditionID, condition := range rule.Conditions {
+			param.IngressRules[ruleID].Conditions[conditionID].Operator = condition.Operator.Value()
+		}
+	}
+
 	return nil
 }
 
 func Validate() error {
 	for _, rule := range param.Rules {
+		if err := rule.AddDefaultResources.Validate(); err != nil {
+			return errors.Wrap(err, "error in validating addDefaultResources")
+		}
+
+		if err := rule.RuntimeEnv.Validate(); err != nil {
+			return errors.Wrap(err, "error in validating runtimeEnv")
+		}
+
+		if err := rule.ReplaceContainerImageHost.Validate(); err != nil {
+			return errors.Wrap(err, "error in validating replaceContainerImageHost")
+		}
+
+		if err := rule.PinImageDigest.Validate(); err != nil {
+			return errors.Wrap(err, "error in validating pinImageDigest")
+		}
+
+		if err := rule.NodeArchAffinity.RegistryOptions.Validate(); err != nil {
+			return errors.Wrap(err, "error in validating nodeArchAffinity")
+		}
+
+		if err := rule.ImagePolicy.Validate(); err != nil {
+			return errors.Wrap(err, "error in validating imagePolicy")
+		}
+
+		if err := rule.Affinity.Validate(); err != nil {
+			return errors.Wrap(err, "error in validating affinity")
+		}
+
+		if err := rule.SchedulingProfiles.Validate(); err != nil {
+			return errors.Wrap(err, "error in validating schedulingProfiles")
+		}
+
+		if err := rule.VolumeZoneAffinity.Validate(); err != nil {
+			return errors.Wrap(err, "error in validating volumeZoneAffinity")
+		}
+
+		if err := rule.PodClasses.Validate(); err != nil {
+			return errors.Wrap(err, "error in validating podClasses")
+		}
+
+		if err := rule.DefaultProbes.Validate(); err != nil {
+			return errors.Wrap(err, "error in validating defaultProbes")
+		}
+
+		if err := rule.MetadataPolicy.Validate(); err != nil {
+			return errors.Wrap(err, "error in validating metadataPolicy")
+		}
+
+		if err := rule.PropagateNamespaceMetadata.Validate(); err != nil {
+			return errors.Wrap(err, "error in validating propagateNamespaceMetadata")
+		}
+
+		if err := rule.DNSConfig.Validate(); err != nil {
+			return errors.Wrap(err, "error in validating dnsConfig")
+		}
+
+		if err := rule.TrustBundle.Validate(); err != nil {
+			return errors.Wrap(err, "error in validating trustBundle")
+		}
+
 		for _, condition := range rule.Conditions {
 			if err := condition.Validate(); err != nil {
 				return errors.Wrap(err, "error in validating condition")
@@ -167,6 +245,16 @@ func Validate() error {
 		}
 	}
 
+	if err := param.GetIngressHostPolicy().Validate(); err != nil {
+		return errors.Wrap(err, "error in validating ingress duplicate host policy")
+	}
+
+	for _, rule := range param.IngressRules {
+		if err := rule.Validate(); err != nil {
+			return errors.Wrapf(err, "error in validating ingress rule %s", rule.Name)
+		}
+	}
+
 	return nil
 }
 
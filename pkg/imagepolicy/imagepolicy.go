@@ -0,0 +1,210 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package imagepolicy
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/metrics"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/imagedigest"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/imagehost"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// check container image with image policy of selected rules,
+// returns DenyError if image is denied. Patches are not applied to ignored pod,
+// so image of ignored pod is checked as is, without rewrite and pinned digest.
+func Check(ctx context.Context, containerInfo *types.ContainerInfo, ignored bool) error {
+	for _, selectedRule := range containerInfo.SelectedRules {
+		policy := selectedRule.ImagePolicy
+
+		if !policy.Enabled {
+			continue
+		}
+
+		selectedRule.Logf("CheckImagePolicy: %+v", policy)
+
+		image := containerInfo.Image
+		if !ignored {
+			image = getPinnedImage(ctx, containerInfo, policy)
+		}
+
+		violation, err := getViolation(policy, image)
+		if err != nil {
+			return errors.Wrap(err, "error checking image policy")
+		}
+
+		// registry violation can be fixed by replacing image host
+		if len(violation) > 0 && policy.Action == types.ImagePolicyActionRewrite && !ignored {
+			violation, err = getRewriteViolation(containerInfo, policy, image)
+			if err != nil {
+				return errors.Wrap(err, "error checking rewritten image")
+			}
+		}
+
+		if len(violation) == 0 {
+			continue
+		}
+
+		message := fmt.Sprintf("container %s image %s is not allowed by image policy: %s",
+			containerInfo.ContainerName,
+			containerInfo.Image.Name,
+			violation,
+		)
+
+		selectedRule.Logf("CheckImagePolicy: %s", message)
+
+		if policy.Action == types.ImagePolicyActionWarn {
+			metrics.ImagePolicyViolations.WithLabelValues(containerInfo.Namespace, types.ImagePolicyActionWarn).Inc()
+
+			containerInfo.AddWarning("%s", message)
+
+			continue
+		}
+
+		metrics.ImagePolicyViolations.WithLabelValues(containerInfo.Namespace, types.ImagePolicyActionDeny).Inc()
+
+		return types.NewDenyError("%s", message)
+	}
+
+	return nil
+}
+
+// returns image with digest that will be added by imagedigest patch,
+// so image that is pinned by patch is not denied by requireDigest.
+func getPinnedImage(ctx context.Context, containerInfo *types.ContainerInfo, policy types.ImagePolicy) *types.ContainerImage { //nolint:lll
+	if !policy.RequireDigest || len(containerInfo.Image.Digest) > 0 {
+		return containerInfo.Image
+	}
+
+	digest, err := (&imagedigest.Patch{}).GetFinalDigest(ctx, containerInfo)
+	if err != nil {
+		log.WithError(err).Warnf("digest of image %s is not resolved", containerInfo.Image.Name)
+
+		return containerInfo.Image
+	}
+
+	if len(digest) == 0 {
+		return containerInfo.Image
+	}
+
+	image := *containerInfo.Image
+	image.Digest = digest
+
+	return &image
+}
+
+// check image that will be after imagehost patch.
+func getRewriteViolation(containerInfo *types.ContainerInfo, policy types.ImagePolicy, pinnedImage *types.ContainerImage) (string, error) { //nolint:lll
+	// tags can not be fixed by rewrite
+	violation, err := getTagViolation(policy, pinnedImage)
+	if err != nil || len(violation) > 0 {
+		return violation, err
+	}
+
+	finalImage, err := (&imagehost.Patch{}).GetFinalImage(containerInfo)
+	if err != nil {
+		return "", errors.Wrap(err, "error getting final image")
+	}
+
+	image, err := types.NewContainerImage(finalImage)
+	if err != nil {
+		return "", errors.Wrap(err, "error parsing final image")
+	}
+
+	// digest is the same for mirrors
+	if len(image.Digest) == 0 {
+		image.Digest = pinnedImage.Digest
+	}
+
+	violation, err = getViolation(policy, image)
+	if err != nil {
+		return "", err
+	}
+
+	if len(violation) > 0 && finalImage != containerInfo.Image.Name {
+		return fmt.Sprintf("%s after rewrite to %s", violation, finalImage), nil
+	}
+
+	return violation, nil
+}
+
+// returns reason why image is not allowed, empty if image is allowed.
+func getViolation(policy types.ImagePolicy, image *types.ContainerImage) (string, error) {
+	denied, err := matchAny(policy.DeniedRegistries, image.Domain)
+	if err != nil {
+		return "", err
+	}
+
+	if denied {
+		return fmt.Sprintf("registry %s is denied", image.Domain), nil
+	}
+
+	if len(policy.AllowedRegistries) > 0 {
+		allowed, err := matchAny(policy.AllowedRegistries, image.Domain)
+		if err != nil {
+			return "", err
+		}
+
+		if !allowed {
+			return fmt.Sprintf("registry %s is not in allowed registries", image.Domain), nil
+		}
+	}
+
+	return getTagViolation(policy, image)
+}
+
+func getTagViolation(policy types.ImagePolicy, image *types.ContainerImage) (string, error) {
+	if policy.RequireDigest && len(image.Digest) == 0 {
+		return "image must have digest", nil
+	}
+
+	if policy.DenyLatest && image.IsLatest {
+		return "latest tag is denied", nil
+	}
+
+	// images with digest only have no tag
+	if len(image.Tag) == 0 {
+		return "", nil
+	}
+
+	denied, err := matchAny(policy.DeniedTags, image.Tag)
+	if err != nil {
+		return "", err
+	}
+
+	if denied {
+		return fmt.Sprintf("tag %s is denied", image.Tag), nil
+	}
+
+	return "", nil
+}
+
+func matchAny(patterns []string, value string) (bool, error) {
+	for _, pattern := range patterns {
+		match, err := regexp.MatchString(pattern, value)
+		if err != nil {
+			return false, errors.Wrapf(err, "error matching regexp %s", pattern)
+		}
+
+		if match {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,256 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package imagepolicy_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/imagepolicy"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestImagePolicy(t *testing.T) { //nolint:funlen
+	t.Parallel()
+
+	allowedRegistries := types.ImagePolicy{
+		Enabled:           true,
+		AllowedRegistries: []string{`^registry\.example\.com$`},
+		DenyLatest:        true,
+		DeniedTags:        []string{"^(main|master)$"},
+	}
+
+	type testCase struct {
+		Image            string
+		Policy           types.ImagePolicy
+		ReplaceImageHost types.ReplaceContainerImageHost
+		Deny             string
+		Warning          bool
+	}
+
+	tests := []testCase{
+		{
+			Image:  "registry.example.com/app:1.0",
+			Policy: allowedRegistries,
+		},
+		{
+			Image:  "alpine:3.12",
+			Policy: allowedRegistries,
+			Deny:   "registry docker.io is not in allowed registries",
+		},
+		{
+			Image:  "registry.example.com/app",
+			Policy: allowedRegistries,
+			Deny:   "latest tag is denied",
+		},
+		{
+			Image:  "registry.example.com/app:main",
+			Policy: allowedRegistries,
+			Deny:   "tag main is denied",
+		},
+		{
+			Image: "quay.io/app:1.0",
+			Policy: types.ImagePolicy{
+				Enabled:          true,
+				DeniedRegistries: []string{`^quay\.io$`},
+			},
+			Deny: "registry quay.io is denied",
+		},
+		{
+			Image: "registry.example.com/app:1.0",
+			Policy: types.ImagePolicy{
+				Enabled:       true,
+				RequireDigest: true,
+			},
+			Deny: "image must have digest",
+		},
+		{
+			Image: "alpine:3.12",
+			Policy: types.ImagePolicy{
+				Enabled:           true,
+				AllowedRegistries: allowedRegistries.AllowedRegistries,
+				Action:            types.ImagePolicyActionWarn,
+			},
+			Warning: true,
+		},
+		{
+			Image: "alpine:3.12",
+			Policy: types.ImagePolicy{
+				Enabled:           true,
+				AllowedRegistries: allowedRegistries.AllowedRegistries,
+				Action:            types.ImagePolicyActionRewrite,
+			},
+			ReplaceImageHost: types.ReplaceContainerImageHost{
+				Enabled: true,
+				Mirrors: []types.ImageMirror{{Registry: "docker.io", Mirror: "registry.example.com/dockerhub"}},
+			},
+		},
+		{
+			Image: "alpine:3.12",
+			Policy: types.ImagePolicy{
+				Enabled:           true,
+				AllowedRegistries: allowedRegistries.AllowedRegistries,
+				Action:            types.ImagePolicyActionRewrite,
+			},
+			Deny: "registry docker.io is not in allowed registries",
+		},
+		{
+			Image: "alpine:master",
+			Policy: types.ImagePolicy{
+				Enabled:           true,
+				AllowedRegistries: allowedRegistries.AllowedRegistries,
+				DeniedTags:        allowedRegistries.DeniedTags,
+				Action:            types.ImagePolicyActionRewrite,
+			},
+			ReplaceImageHost: types.ReplaceContainerImageHost{
+				Enabled: true,
+				Mirrors: []types.ImageMirror{{Registry: "docker.io", Mirror: "registry.example.com/dockerhub"}},
+			},
+			Deny: "tag master is denied",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s/%+v", test.Image, test.Policy), func(t *testing.T) {
+			t.Parallel()
+
+			image, err := types.NewContainerImage(test.Image)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			containerInfo := &types.ContainerInfo{
+				ContainerName: "test",
+				Namespace:     "test",
+				Image:         image,
+				PodContainer: &types.PodContainer{
+					Type:      types.PodContainerTypeContainer,
+					Container: &corev1.Container{Name: "test", Image: test.Image},
+				},
+				SelectedRules: []*types.Rule{
+					{
+						ImagePolicy:               test.Policy,
+						ReplaceContainerImageHost: test.ReplaceImageHost,
+					},
+				},
+			}
+
+			err = imagepolicy.Check(t.Context(), containerInfo, false)
+
+			if len(test.Deny) > 0 {
+				var denyError *types.DenyError
+				if !errors.As(err, &denyError) {
+					t.Fatalf("image must be denied, got %v", err)
+				}
+
+				if !strings.Contains(err.Error(), "container test") || !strings.Contains(err.Error(), test.Deny) {
+					t.Fatalf("not corrected message %s", err.Error())
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.Warning != (len(containerInfo.Warnings) > 0) {
+				t.Fatalf("not corrected warnings %v", containerInfo.Warnings)
+			}
+		})
+	}
+}
+
+func TestImagePolicyPinImageDigest(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/app/manifests/1.0" {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		w.Header().Set("Docker-Content-Digest", "sha256:8fd21d59428507671ce0fb47f818b1d859c92d2ad07bb7c947268d433030ba98")
+		_, _ = w.Write([]byte(`{"schemaVersion":2}`))
+	}))
+	t.Cleanup(server.Close)
+
+	domain := strings.TrimPrefix(server.URL, "http://")
+
+	tests := map[string]bool{
+		// image is pinned by imagedigest patch
+		domain + "/app:1.0": false,
+		// digest is not resolved, pod will have image without digest
+		domain + "/app:unknown": true,
+	}
+
+	for imageName, deny := range tests {
+		image, err := types.NewContainerImage(imageName)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		containerInfo := &types.ContainerInfo{
+			ContainerName: "test",
+			Namespace:     "test",
+			Image:         image,
+			PodContainer: &types.PodContainer{
+				Type:      types.PodContainerTypeContainer,
+				Container: &corev1.Container{Name: "test", Image: imageName},
+			},
+			SelectedRules: []*types.Rule{
+				{
+					ImagePolicy: types.ImagePolicy{Enabled: true, RequireDigest: true},
+					PinImageDigest: types.PinImageDigest{
+						Enabled: true,
+						RegistryOptions: types.RegistryOptions{
+							InsecureRegistries: []string{domain},
+						},
+					},
+				},
+			},
+		}
+
+		var denyError *types.DenyError
+		if err := imagepolicy.Check(t.Context(), containerInfo, false); errors.As(err, &denyError) != deny {
+			t.Fatalf("image %s: want deny %t, got %v", imageName, deny, err)
+		}
+
+		// imagedigest patch is not applied to ignored pod
+		if err := imagepolicy.Check(t.Context(), containerInfo, true); !errors.As(err, &denyError) {
+			t.Fatalf("image %s of ignored pod must be denied, got %v", imageName, err)
+		}
+	}
+}
+
+func TestImagePolicyValidate(t *testing.T) {
+	t.Parallel()
+
+	policies := []types.ImagePolicy{
+		{Action: "unknown"},
+		{DeniedTags: []string{"["}},
+		{AllowedRegistries: []string{"("}},
+	}
+
+	for _, policy := range policies {
+		if err := policy.Validate(); err == nil {
+			t.Fatalf("policy %+v must be not valid", policy)
+		}
+	}
+}
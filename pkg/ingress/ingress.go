@@ -0,0 +1,404 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ingress
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/client"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/conditions"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/template"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	"github.com/pkg/errors"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// deprecated annotation, ingressClassName can not be set with it.
+const annotationIngressClass = "kubernetes.io/ingress.class"
+
+func NewIngressInfo(ingress *networkingv1.Ingress) *types.IngressInfo {
+	ingressInfo := &types.IngressInfo{
+		Name:          ingress.Name,
+		Namespace:     ingress.Namespace,
+		Labels:        ingress.Labels,
+		Annotations:   ingress.Annotations,
+		SelectedRules: []*types.IngressRule{},
+	}
+
+	if ingress.Spec.IngressClassName != nil {
+		ingressInfo.IngressClassName = *ingress.Spec.IngressClassName
+	}
+
+	return ingressInfo
+}
+
+// select rules that match ingress.
+func SelectRules(ingressInfo *types.IngressInfo, rules []*types.IngressRule) error {
+	for _, rule := range rules {
+		match, err := conditions.Check(ingressInfo, rule.Conditions)
+		if err != nil {
+			return errors.Wrap(err, "error checking conditions")
+		}
+
+		if match {
+			ingressInfo.SelectedRules = append(ingressInfo.SelectedRules, rule)
+		}
+	}
+
+	return nil
+}
+
+// returns patch for ingress, defaultSuffix is added to hosts that ends with dot.
+func NewPatch(ingressInfo *types.IngressInfo, ingress *networkingv1.Ingress, defaultSuffix string) ([]types.PatchOperation, error) { //nolint:lll
+	patchOps := make([]types.PatchOperation, 0)
+
+	patchOps = append(patchOps, createIngressClassName(ingressInfo, ingress)...)
+
+	hostsOps, err := createHosts(ingressInfo, ingress, defaultSuffix)
+	if err != nil {
+		return nil, errors.Wrap(err, "error in hosts")
+	}
+
+	patchOps = append(patchOps, hostsOps...)
+
+	tlsOps, err := createDefaultTLS(ingressInfo, ingress, defaultSuffix)
+	if err != nil {
+		return nil, errors.Wrap(err, "error in default tls")
+	}
+
+	patchOps = append(patchOps, tlsOps...)
+
+	annotations, err := getAnnotations(ingressInfo, ingress.Annotations)
+	if err != nil {
+		return nil, errors.Wrap(err, "error in annotations")
+	}
+
+	patchOps = append(patchOps, types.NewMetadataPatch("/metadata/annotations", ingress.Annotations, annotations)...)
+
+	return patchOps, nil
+}
+
+// returns host after mutation, annotation suffix is used first, then rule host template and then default suffix.
+func GetHost(ingressInfo *types.IngressInfo, defaultSuffix, host string) (string, bool, error) {
+	if !strings.HasSuffix(host, ".") {
+		return host, false, nil
+	}
+
+	if _, ok := ingressInfo.Annotations[types.AnnotationDefaultIngressSuffix]; ok {
+		newHost, ok := GetSuffixHost(ingressInfo.Annotations, defaultSuffix, host)
+
+		return newHost, ok, nil
+	}
+
+	for _, selectedRule := range ingressInfo.SelectedRules {
+		if len(selectedRule.Host) == 0 {
+			continue
+		}
+
+		hostInfo := *ingressInfo
+		hostInfo.Host = strings.TrimSuffix(host, ".")
+
+		newHost, err := template.Get(&hostInfo, selectedRule.Host)
+		if err != nil {
+			return "", false, errors.Wrapf(err, "error format host %s", host)
+		}
+
+		selectedRule.Logf("CreateIngressHost: %s=%s", host, newHost)
+
+		return newHost, true, nil
+	}
+
+	newHost, ok := GetSuffixHost(ingressInfo.Annotations, defaultSuffix, host)
+
+	return newHost, ok, nil
+}
+
+// returns hosts of ingress rules.
+func GetRuleHosts(ingress *networkingv1.Ingress) []string {
+	hosts := make([]string, 0)
+
+	for _, rule := range ingress.Spec.Rules {
+		if len(rule.Host) > 0 && !slices.Contains(hosts, rule.Host) {
+			hosts = append(hosts, rule.Host)
+		}
+	}
+
+	return hosts
+}
+
+// returns hosts of ingress rules after mutation.
+func GetHosts(ingressInfo *types.IngressInfo, ingress *networkingv1.Ingress, defaultSuffix string) ([]string, error) {
+	hosts := make([]string, 0)
+
+	for _, host := range GetRuleHosts(ingress) {
+		newHost, _, err := GetHost(ingressInfo, defaultSuffix, host)
+		if err != nil {
+			return nil, err
+		}
+
+		hosts = append(hosts, newHost)
+	}
+
+	return hosts, nil
+}
+
+// check that hosts are not used by ingresses in other namespaces,
+// returns DenyError for deny policy and warnings for warn policy,
+// oldHosts that ingress already has are not denied, so ingress owner can update ingress.
+func CheckDuplicateHosts(namespace string, hosts, oldHosts []string, policy types.DuplicateHostPolicy) ([]string, error) { //nolint:lll
+	if len(policy) == 0 || len(hosts) == 0 || client.Informers() == nil {
+		return nil, nil
+	}
+
+	ingresses, err := client.Informers().Networking().V1().Ingresses().Lister().List(labels.Everything())
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing ingresses")
+	}
+
+	warnings := make([]string, 0)
+
+	for _, host := range hosts {
+		for _, other := range ingresses {
+			// ingress hosts in the same namespace are managed by namespace owner
+			if other.Namespace == namespace || other.DeletionTimestamp != nil {
+				continue
+			}
+
+			if !slices.Contains(GetRuleHosts(other), host) {
+				continue
+			}
+
+			if policy == types.DuplicateHostPolicyDeny && !slices.Contains(oldHosts, host) {
+				return nil, types.NewDenyError("ingress host %s is already used by ingress %s/%s", host, other.Namespace, other.Name) //nolint:lll
+			}
+
+			warnings = append(warnings, fmt.Sprintf("ingress host %s is already used by ingress %s/%s", host, other.Namespace, other.Name)) //nolint:lll
+
+			break
+		}
+	}
+
+	return warnings, nil
+}
+
+// returns host with suffix from annotation or default suffix for hosts that ends with dot.
+func GetSuffixHost(annotations map[string]string, defaultSuffix, host string) (string, bool) {
+	suffix := defaultSuffix
+
+	if annotationSuffix, ok := annotations[types.AnnotationDefaultIngressSuffix]; ok {
+		suffix = annotationSuffix
+	}
+
+	if len(suffix) == 0 {
+		return host, false
+	}
+
+	if strings.HasSuffix(host, ".") {
+		return host + suffix, true
+	}
+
+	return host, false
+}
+
+// returns patch for gateway api route hostnames.
+func NewRoutePatch(route *types.GatewayRoute, defaultSuffix string) []types.PatchOperation {
+	patchOps := make([]types.PatchOperation, 0)
+
+	for hostID, host := range route.Spec.Hostnames {
+		if newHost, ok := GetSuffixHost(route.Annotations, defaultSuffix, host); ok {
+			patchOps = append(patchOps, types.PatchOperation{
+				Op:    "replace",
+				Path:  fmt.Sprintf("/spec/hostnames/%d", hostID),
+				Value: newHost,
+			})
+		}
+	}
+
+	return patchOps
+}
+
+func createIngressClassName(ingressInfo *types.IngressInfo, ingress *networkingv1.Ingress) []types.PatchOperation {
+	if ingress.Spec.IngressClassName != nil {
+		return nil
+	}
+
+	if _, ok := ingress.Annotations[annotationIngressClass]; ok {
+		return nil
+	}
+
+	for _, selectedRule := range ingressInfo.SelectedRules {
+		if len(selectedRule.IngressClassName) == 0 {
+			continue
+		}
+
+		selectedRule.Logf("CreateIngressClassName: %s", selectedRule.IngressClassName)
+
+		return []types.PatchOperation{{
+			Op:    "add",
+			Path:  "/spec/ingressClassName",
+			Value: selectedRule.IngressClassName,
+		}}
+	}
+
+	return nil
+}
+
+func createHosts(ingressInfo *types.IngressInfo, ingress *networkingv1.Ingress, defaultSuffix string) ([]types.PatchOperation, error) { //nolint:lll
+	patchOps := make([]types.PatchOperation, 0)
+
+	for ruleID, rule := range ingress.Spec.Rules {
+		host, ok, err := GetHost(ingressInfo, defaultSuffix, rule.Host)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			patchOps = append(patchOps, types.PatchOperation{
+				Op:    "replace",
+				Path:  fmt.Sprintf("/spec/rules/%d/host", ruleID),
+				Value: host,
+			})
+		}
+	}
+
+	for tlsID, tls := range ingress.Spec.TLS {
+		for hostID, host := range tls.Hosts {
+			newHost, ok, err := GetHost(ingressInfo, defaultSuffix, host)
+			if err != nil {
+				return nil, err
+			}
+
+			if ok {
+				patchOps = append(patchOps, types.PatchOperation{
+					Op:    "replace",
+					Path:  fmt.Sprintf("/spec/tls/%d/hosts/%d", tlsID, hostID),
+					Value: newHost,
+				})
+			}
+		}
+	}
+
+	return patchOps, nil
+}
+
+// add tls with wildcard certificate secret for hosts that are not in ingress tls.
+func createDefaultTLS(ingressInfo *types.IngressInfo, ingress *networkingv1.Ingress, defaultSuffix string) ([]types.PatchOperation, error) { //nolint:cyclop,lll
+	tlsHosts := make([]string, 0)
+
+	for _, tls := range ingress.Spec.TLS {
+		for _, host := range tls.Hosts {
+			newHost, _, err := GetHost(ingressInfo, defaultSuffix, host)
+			if err != nil {
+				return nil, err
+			}
+
+			tlsHosts = append(tlsHosts, newHost)
+		}
+	}
+
+	newTLS := make([]networkingv1.IngressTLS, 0)
+
+	for _, rule := range ingress.Spec.Rules {
+		host, _, err := GetHost(ingressInfo, defaultSuffix, rule.Host)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(host) == 0 || slices.Contains(tlsHosts, host) {
+			continue
+		}
+
+		for _, selectedRule := range ingressInfo.SelectedRules {
+			defaultTLS := selectedRule.DefaultTLS
+
+			if !defaultTLS.Enabled || !defaultTLS.MatchHost(host) {
+				continue
+			}
+
+			secretName, err := template.Get(ingressInfo, defaultTLS.SecretName)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error format secretName %s", defaultTLS.SecretName)
+			}
+
+			selectedRule.Logf("CreateDefaultTLS: %s=%s", host, secretName)
+
+			tlsHosts = append(tlsHosts, host)
+
+			// hosts with the same secret are in one tls
+			tlsID := slices.IndexFunc(newTLS, func(tls networkingv1.IngressTLS) bool {
+				return tls.SecretName == secretName
+			})
+
+			if tlsID >= 0 {
+				newTLS[tlsID].Hosts = append(newTLS[tlsID].Hosts, host)
+			} else {
+				newTLS = append(newTLS, networkingv1.IngressTLS{Hosts: []string{host}, SecretName: secretName})
+			}
+
+			break
+		}
+	}
+
+	if len(newTLS) == 0 {
+		return nil, nil
+	}
+
+	if len(ingress.Spec.TLS) == 0 {
+		return []types.PatchOperation{{Op: "add", Path: "/spec/tls", Value: newTLS}}, nil
+	}
+
+	patchOps := make([]types.PatchOperation, 0, len(newTLS))
+
+	for _, tls := range newTLS {
+		patchOps = append(patchOps, types.PatchOperation{Op: "add", Path: "/spec/tls/-", Value: tls})
+	}
+
+	return patchOps, nil
+}
+
+// returns templated annotations that must be added to ingress.
+func getAnnotations(ingressInfo *types.IngressInfo, ingressAnnotations map[string]string) (map[string]string, error) {
+	result := make(map[string]string)
+
+	for _, selectedRule := range ingressInfo.SelectedRules {
+		for key, value := range selectedRule.Annotations {
+			// value from first rule is used
+			if _, ok := result[key]; ok {
+				continue
+			}
+
+			ingressValue, exists := ingressAnnotations[key]
+			if exists && selectedRule.MetadataPolicy != types.MetadataPolicyOverwrite {
+				continue
+			}
+
+			formatted, err := template.Get(ingressInfo, value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error format %s", key)
+			}
+
+			if exists && formatted == ingressValue {
+				continue
+			}
+
+			selectedRule.Logf("CreateIngressAnnotation: %s=%s", key, formatted)
+
+			result[key] = formatted
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,342 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ingress_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/client"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/ingress"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/utils"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNewPatch(t *testing.T) { //nolint:funlen,maintidx
+	t.Parallel()
+
+	rules := []*types.IngressRule{
+		{
+			Conditions: []types.Condition{
+				{Key: ".Namespace", Operator: types.OperatorEqual, Value: "skip"},
+			},
+			IngressClassName: "skip",
+		},
+		{
+			IngressClassName: "nginx",
+			Annotations: map[string]string{
+				"cert-manager.io/cluster-issuer":        "letsencrypt",
+				"nginx.ingress.kubernetes.io/limit-rps": "{{ index .NamespaceLabels `rps` }}",
+			},
+			Host: "{{ .Host }}.{{ index .NamespaceLabels `team` }}.example.com",
+			DefaultTLS: types.DefaultIngressTLS{
+				Enabled:    true,
+				Domains:    []string{"*.team.example.com"},
+				SecretName: "wildcard-team",
+			},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		ingress       networkingv1.Ingress
+		defaultSuffix string
+		rules         []*types.IngressRule
+		want          []types.PatchOperation
+	}{
+		{
+			name: "suffix without rules",
+			ingress: networkingv1.Ingress{
+				Spec: networkingv1.IngressSpec{
+					Rules: []networkingv1.IngressRule{{Host: "test."}, {Host: "test.com"}},
+					TLS:   []networkingv1.IngressTLS{{Hosts: []string{"test."}}},
+				},
+			},
+			defaultSuffix: "example.com",
+			want: []types.PatchOperation{
+				{Op: "replace", Path: "/spec/rules/0/host", Value: "test.example.com"},
+				{Op: "replace", Path: "/spec/tls/0/hosts/0", Value: "test.example.com"},
+			},
+		},
+		{
+			name: "annotation suffix is used before rules",
+			ingress: networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						types.AnnotationDefaultIngressSuffix: "annotation.com",
+						"cert-manager.io/cluster-issuer":     "custom",
+					},
+				},
+				Spec: networkingv1.IngressSpec{
+					IngressClassName: utils.Pnt("custom"),
+					Rules:            []networkingv1.IngressRule{{Host: "test."}},
+				},
+			},
+			defaultSuffix: "example.com",
+			rules:         rules,
+			want: []types.PatchOperation{
+				{Op: "replace", Path: "/spec/rules/0/host", Value: "test.annotation.com"},
+				{Op: "add", Path: "/metadata/annotations/nginx.ingress.kubernetes.io~1limit-rps", Value: "10"},
+			},
+		},
+		{
+			name: "rules",
+			ingress: networkingv1.Ingress{
+				Spec: networkingv1.IngressSpec{
+					Rules: []networkingv1.IngressRule{{Host: "test."}, {Host: "other.team.example.com"}, {Host: "a.b.team.example.com"}},
+				},
+			},
+			defaultSuffix: "example.com",
+			rules:         rules,
+			want: []types.PatchOperation{
+				{Op: "add", Path: "/spec/ingressClassName", Value: "nginx"},
+				{Op: "replace", Path: "/spec/rules/0/host", Value: "test.team.example.com"},
+				{Op: "add", Path: "/spec/tls", Value: []networkingv1.IngressTLS{
+					{Hosts: []string{"test.team.example.com", "other.team.example.com"}, SecretName: "wildcard-team"},
+				}},
+				{Op: "add", Path: "/metadata/annotations", Value: map[string]string{}},
+				{Op: "add", Path: "/metadata/annotations/cert-manager.io~1cluster-issuer", Value: "letsencrypt"},
+				{Op: "add", Path: "/metadata/annotations/nginx.ingress.kubernetes.io~1limit-rps", Value: "10"},
+			},
+		},
+		{
+			name: "ingress tls is kept",
+			ingress: networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						"kubernetes.io/ingress.class":           "custom",
+						"cert-manager.io/cluster-issuer":        "letsencrypt",
+						"nginx.ingress.kubernetes.io/limit-rps": "1",
+					},
+				},
+				Spec: networkingv1.IngressSpec{
+					Rules: []networkingv1.IngressRule{{Host: "test."}, {Host: "other.team.example.com"}},
+					TLS:   []networkingv1.IngressTLS{{Hosts: []string{"test."}, SecretName: "test"}},
+				},
+			},
+			rules: rules,
+			want: []types.PatchOperation{
+				{Op: "replace", Path: "/spec/rules/0/host", Value: "test.team.example.com"},
+				{Op: "replace", Path: "/spec/tls/0/hosts/0", Value: "test.team.example.com"},
+				{Op: "add", Path: "/spec/tls/-", Value: networkingv1.IngressTLS{
+					Hosts:      []string{"other.team.example.com"},
+					SecretName: "wildcard-team",
+				}},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ingressInfo := ingress.NewIngressInfo(&tc.ingress)
+			ingressInfo.Namespace = "test"
+			ingressInfo.NamespaceLabels = map[string]string{"team": "team", "rps": "10"}
+
+			if err := ingress.SelectRules(ingressInfo, tc.rules); err != nil {
+				t.Fatal(err)
+			}
+
+			patchOps, err := ingress.NewPatch(ingressInfo, &tc.ingress, tc.defaultSuffix)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(patchOps, tc.want) {
+				t.Fatalf("want %+v, got %+v", tc.want, patchOps)
+			}
+		})
+	}
+}
+
+func TestDefaultIngressTLS(t *testing.T) {
+	t.Parallel()
+
+	defaultTLS := types.DefaultIngressTLS{Enabled: true, Domains: []string{"*.example.com"}, SecretName: "wildcard"}
+
+	if err := defaultTLS.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]bool{
+		"test.example.com":   true,
+		"a.test.example.com": false,
+		"example.com":        false,
+		".example.com":       false,
+		"test.example.org":   false,
+	}
+
+	for host, want := range tests {
+		if got := defaultTLS.MatchHost(host); got != want {
+			t.Fatalf("host %s want %t, got %t", host, want, got)
+		}
+	}
+
+	if err := (&types.DefaultIngressTLS{Enabled: true, Domains: []string{"example.com"}, SecretName: "test"}).Validate(); err == nil { //nolint:lll
+		t.Fatal("domain must be wildcard")
+	}
+}
+
+func TestNewRoutePatch(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        []types.PatchOperation
+	}{
+		{
+			name: "default suffix",
+			want: []types.PatchOperation{
+				{Op: "replace", Path: "/spec/hostnames/0", Value: "test.example.com"},
+			},
+		},
+		{
+			name:        "annotation suffix",
+			annotations: map[string]string{types.AnnotationDefaultIngressSuffix: "annotation.com"},
+			want: []types.PatchOperation{
+				{Op: "replace", Path: "/spec/hostnames/0", Value: "test.annotation.com"},
+			},
+		},
+		{
+			name:        "empty annotation suffix",
+			annotations: map[string]string{types.AnnotationDefaultIngressSuffix: ""},
+			want:        []types.PatchOperation{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			route := &types.GatewayRoute{
+				ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations},
+				Spec:       types.GatewayRouteSpec{Hostnames: []string{"test.", "test.com"}},
+			}
+
+			patchOps := ingress.NewRoutePatch(route, "example.com")
+			if !reflect.DeepEqual(patchOps, tc.want) {
+				t.Fatalf("want %+v, got %+v", tc.want, patchOps)
+			}
+		})
+	}
+}
+
+// test uses global informers.
+func TestCheckDuplicateHosts(t *testing.T) { //nolint:funlen,paralleltest
+	newIngress := func(namespace, name string, hosts ...string) *networkingv1.Ingress {
+		ingress := &networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		}
+
+		for _, host := range hosts {
+			ingress.Spec.Rules = append(ingress.Spec.Rules, networkingv1.IngressRule{Host: host})
+		}
+
+		return ingress
+	}
+
+	clientset := fake.NewClientset(
+		newIngress("team-a", "app", "app.example.com"),
+		newIngress("team-b", "api", "api.example.com", "app.example.com"),
+	)
+
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	factory.Networking().V1().Ingresses().Informer()
+	factory.Start(t.Context().Done())
+	factory.WaitForCacheSync(t.Context().Done())
+
+	client.SetInformers(factory)
+	t.Cleanup(func() { client.SetInformers(nil) })
+
+	tests := []struct {
+		name         string
+		namespace    string
+		hosts        []string
+		oldHosts     []string
+		policy       types.DuplicateHostPolicy
+		wantWarnings int
+		wantDeny     bool
+	}{
+		{
+			name:      "new host",
+			namespace: "team-c",
+			hosts:     []string{"new.example.com"},
+			policy:    types.DuplicateHostPolicyDeny,
+		},
+		{
+			name:      "host in the same namespace",
+			namespace: "team-b",
+			hosts:     []string{"api.example.com"},
+			policy:    types.DuplicateHostPolicyDeny,
+		},
+		{
+			name:      "disabled policy",
+			namespace: "team-c",
+			hosts:     []string{"app.example.com"},
+		},
+		{
+			name:         "warn policy",
+			namespace:    "team-c",
+			hosts:        []string{"app.example.com", "api.example.com", "new.example.com"},
+			policy:       types.DuplicateHostPolicyWarn,
+			wantWarnings: 2,
+		},
+		{
+			name:      "deny policy",
+			namespace: "team-a",
+			hosts:     []string{"app.example.com"},
+			policy:    types.DuplicateHostPolicyDeny,
+			wantDeny:  true,
+		},
+		{
+			name:         "deny policy allows hosts of updated ingress",
+			namespace:    "team-a",
+			hosts:        []string{"app.example.com"},
+			oldHosts:     []string{"app.example.com"},
+			policy:       types.DuplicateHostPolicyDeny,
+			wantWarnings: 1,
+		},
+		{
+			name:      "deny policy denies new hosts of updated ingress",
+			namespace: "team-a",
+			hosts:     []string{"app.example.com", "api.example.com"},
+			oldHosts:  []string{"app.example.com"},
+			policy:    types.DuplicateHostPolicyDeny,
+			wantDeny:  true,
+		},
+	}
+
+	for _, tc := range tests {
+		warnings, err := ingress.CheckDuplicateHosts(tc.namespace, tc.hosts, tc.oldHosts, tc.policy)
+
+		var denyError *types.DenyError
+		if isDeny := errors.As(err, &denyError); isDeny != tc.wantDeny {
+			t.Fatalf("%s: want deny %t, got %v", tc.name, tc.wantDeny, err)
+		}
+
+		if !tc.wantDeny && err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+
+		if len(warnings) != tc.wantWarnings {
+			t.Fatalf("%s: want %d warnings, got %v", tc.name, tc.wantWarnings, warnings)
+		}
+	}
+}
@@ -40,6 +40,42 @@ var MutationsError = promauto.NewCounterVec(prometheus.CounterOpts{
 	Help:      "The total number of errored pod mutations",
 }, []string{"namespace"})
 
+var MutationsDenied = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "mutations_denied_total",
+	Help:      "The total number of denied pod mutations",
+}, []string{"namespace"})
+
+var ImageHostRewrites = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "image_host_rewrites_total",
+	Help:      "The total number of container images rewrites to mirror",
+}, []string{"registry", "mirror"})
+
+var ImageHostFallbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "image_host_fallbacks_total",
+	Help:      "The total number of container images that kept original host because mirror is unhealthy",
+}, []string{"registry", "mirror"})
+
+var MirrorHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "mirror_healthy",
+	Help:      "Mirror health check status, 1 - healthy, 0 - unhealthy",
+}, []string{"mirror"})
+
+var ImageDigestErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "image_digest_errors_total",
+	Help:      "The total number of container images that digest was not resolved",
+}, []string{"registry"})
+
+var ImagePolicyViolations = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "image_policy_violations_total",
+	Help:      "The total number of container images that violate image policy",
+}, []string{"namespace", "action"})
+
 var KubernetesAPIRequest = promauto.NewCounterVec(prometheus.CounterOpts{
 	Namespace: namespace,
 	Name:      "apiserver_request_total",
@@ -0,0 +1,108 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package namespacepolicy
+
+import (
+	"slices"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/nodeselector"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/schedulingprofile"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/tolerations"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// tolerations that are added by apiserver to all pods.
+var defaultTolerationKeys = []string{corev1.TaintNodeNotReady, corev1.TaintNodeUnreachable}
+
+// check pod tolerations with namespace tolerations whitelist and pod node selector
+// with namespace node selector, returns DenyError if pod is not allowed in namespace.
+func Check(containerInfo *types.ContainerInfo) error {
+	if containerInfo.PodContainer == nil || containerInfo.PodContainer.Pod == nil || !containerInfo.NamespaceSchedulingEnabled() { //nolint:lll
+		return nil
+	}
+
+	if err := checkTolerations(containerInfo); err != nil {
+		return err
+	}
+
+	return checkNodeSelector(containerInfo)
+}
+
+func checkTolerations(containerInfo *types.ContainerInfo) error {
+	value, ok := containerInfo.NamespaceAnnotations[types.AnnotationTolerationsWhitelist]
+	if !ok {
+		return nil
+	}
+
+	whitelist, err := tolerations.ParseTolerations(value)
+	if err != nil {
+		return errors.Wrap(err, "error parsing tolerations whitelist")
+	}
+
+	// tolerations that pod will have after all patches
+	podTolerations, err := (&schedulingprofile.Patch{}).GetFinalTolerations(containerInfo)
+	if err != nil {
+		return errors.Wrap(err, "error getting pod tolerations")
+	}
+
+	for _, toleration := range podTolerations {
+		if isDefaultToleration(toleration) {
+			continue
+		}
+
+		if !slices.ContainsFunc(whitelist, func(allowed corev1.Toleration) bool {
+			return allowed.MatchToleration(&toleration)
+		}) {
+			return types.NewDenyError("pod toleration %s is not in namespace tolerations whitelist", formatToleration(toleration)) //nolint:lll
+		}
+	}
+
+	return nil
+}
+
+func checkNodeSelector(containerInfo *types.ContainerInfo) error {
+	namespaceSelector, err := nodeselector.GetNamespaceNodeSelector(containerInfo)
+	if err != nil {
+		return errors.Wrap(err, "error getting namespace node selector")
+	}
+
+	for key, value := range containerInfo.PodContainer.Pod.Spec.NodeSelector {
+		if namespaceValue, ok := namespaceSelector[key]; ok && namespaceValue != value {
+			return types.NewDenyError("pod node selector %s=%s conflicts with namespace node selector %s=%s", key, value, key, namespaceValue) //nolint:lll
+		}
+	}
+
+	return nil
+}
+
+func isDefaultToleration(toleration corev1.Toleration) bool {
+	return slices.Contains(defaultTolerationKeys, toleration.Key) &&
+		toleration.Operator == corev1.TolerationOpExists &&
+		toleration.Effect == corev1.TaintEffectNoExecute
+}
+
+func formatToleration(toleration corev1.Toleration) string {
+	result := toleration.Key
+
+	if len(toleration.Value) > 0 {
+		result += "=" + toleration.Value
+	}
+
+	if len(toleration.Effect) > 0 {
+		result += ":" + string(toleration.Effect)
+	}
+
+	return result
+}
@@ -0,0 +1,92 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package namespacepolicy_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/namespacepolicy"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestNamespacePolicy(t *testing.T) { //nolint:funlen
+	t.Parallel()
+
+	namespaceAnnotations := map[string]string{
+		types.AnnotationTolerationsWhitelist: `[{"key":"gpu","operator":"Exists","effect":"NoSchedule"}]`,
+		types.AnnotationDefaultTolerations:   `[{"key":"gpu","operator":"Exists","effect":"NoSchedule"}]`,
+		types.AnnotationNodeSelector:         "pool=team-a",
+	}
+
+	notReady := corev1.Toleration{Key: corev1.TaintNodeNotReady, Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute} //nolint:lll
+
+	tests := []struct {
+		name     string
+		podSpec  corev1.PodSpec
+		rule     types.Rule
+		wantDeny bool
+	}{
+		{
+			name:    "allowed tolerations",
+			podSpec: corev1.PodSpec{Tolerations: []corev1.Toleration{notReady}},
+		},
+		{
+			name: "not allowed pod toleration",
+			podSpec: corev1.PodSpec{Tolerations: []corev1.Toleration{
+				{Key: "spot", Operator: corev1.TolerationOpExists},
+			}},
+			wantDeny: true,
+		},
+		{
+			name: "not allowed rule toleration",
+			rule: types.Rule{Tolerations: []corev1.Toleration{
+				{Key: "spot", Operator: corev1.TolerationOpExists},
+			}},
+			wantDeny: true,
+		},
+		{
+			name:    "same node selector",
+			podSpec: corev1.PodSpec{NodeSelector: map[string]string{"pool": "team-a", "disk": "ssd"}},
+		},
+		{
+			name:     "conflicting node selector",
+			podSpec:  corev1.PodSpec{NodeSelector: map[string]string{"pool": "team-b"}},
+			wantDeny: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			tc.rule.NamespaceScheduling.Enabled = true
+
+			containerInfo := &types.ContainerInfo{
+				NamespaceAnnotations: namespaceAnnotations,
+				PodContainer: &types.PodContainer{
+					Pod: &corev1.Pod{Spec: tc.podSpec},
+				},
+				SelectedRules: []*types.Rule{&tc.rule},
+			}
+
+			err := namespacepolicy.Check(containerInfo)
+
+			var denyError *types.DenyError
+			if isDeny := errors.As(err, &denyError); isDeny != tc.wantDeny {
+				t.Fatalf("want deny %t, got %v", tc.wantDeny, err)
+			}
+		})
+	}
+}
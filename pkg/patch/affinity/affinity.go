@@ -0,0 +1,235 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package affinity
+
+import (
+	"context"
+	"reflect"
+	"slices"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const patchName = "affinity"
+
+type Patch struct{}
+
+// add pod anti affinity and node affinity from rules, affinity is merged with pod affinity.
+func (p *Patch) Create(_ context.Context, containerInfo *types.ContainerInfo) ([]types.PatchOperation, error) {
+	if containerInfo.PodContainer == nil || containerInfo.PodContainer.Pod == nil {
+		return []types.PatchOperation{}, nil
+	}
+
+	pod := containerInfo.PodContainer.Pod
+
+	affinity := p.merge(containerInfo, true)
+
+	if reflect.DeepEqual(affinity, pod.Spec.Affinity) {
+		return []types.PatchOperation{}, nil
+	}
+
+	return []types.PatchOperation{
+		{
+			Op:    "add",
+			Path:  "/spec/affinity",
+			Value: affinity,
+		},
+	}, nil
+}
+
+// returns pod affinity that will be after patch, used by patches that also change affinity.
+func (p *Patch) GetFinalAffinity(containerInfo *types.ContainerInfo) *corev1.Affinity {
+	if containerInfo.PodContainer == nil || containerInfo.PodContainer.Pod == nil {
+		return nil
+	}
+
+	pod := containerInfo.PodContainer.Pod
+
+	if containerInfo.IgnorePatch(patchName) {
+		return pod.Spec.Affinity
+	}
+
+	return p.merge(containerInfo, false)
+}
+
+func (p *Patch) merge(containerInfo *types.ContainerInfo, withLogs bool) *corev1.Affinity {
+	affinity := containerInfo.PodContainer.Pod.Spec.Affinity
+
+	for _, selectedRule := range containerInfo.SelectedRules {
+		if !selectedRule.Affinity.Enabled {
+			continue
+		}
+
+		if withLogs {
+			selectedRule.Logf("CreateAffinity: %+v", selectedRule.Affinity)
+		}
+
+		affinity = MergePodAntiAffinity(affinity, selectedRule.Affinity.PodAntiAffinity, containerInfo)
+		affinity = MergePreferredNodeAffinity(affinity, selectedRule.Affinity.PreferredNodeAffinity)
+		affinity = MergeRequiredNodeAffinity(affinity, selectedRule.Affinity.RequiredNodeAffinity)
+	}
+
+	return affinity
+}
+
+// add anti affinity to pods with the same workload labels,
+// term is not added if pod already has anti affinity with the same topologyKey.
+func MergePodAntiAffinity(affinity *corev1.Affinity, rule types.PodAntiAffinity, containerInfo *types.ContainerInfo) *corev1.Affinity { //nolint:lll
+	if !rule.Enabled {
+		return affinity
+	}
+
+	podLabels := containerInfo.GetWorkloadLabels(rule.LabelKeys)
+
+	// anti affinity without label selector matches all pods
+	if len(podLabels) == 0 {
+		return affinity
+	}
+
+	topologyKey := rule.TopologyKey
+	if len(topologyKey) == 0 {
+		topologyKey = corev1.LabelHostname
+	}
+
+	if hasPodAntiAffinity(affinity, topologyKey) {
+		return affinity
+	}
+
+	result := copyAffinity(affinity)
+
+	if result.PodAntiAffinity == nil {
+		result.PodAntiAffinity = &corev1.PodAntiAffinity{}
+	}
+
+	term := corev1.PodAffinityTerm{
+		TopologyKey: topologyKey,
+		LabelSelector: &metav1.LabelSelector{
+			MatchLabels: podLabels,
+		},
+	}
+
+	if rule.Type == types.AffinityTypeRequired {
+		result.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution = append(result.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution, term) //nolint:lll
+
+		return result
+	}
+
+	weight := rule.Weight
+	if weight == 0 {
+		weight = 100
+	}
+
+	result.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(result.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution, corev1.WeightedPodAffinityTerm{ //nolint:lll
+		Weight:          weight,
+		PodAffinityTerm: term,
+	})
+
+	return result
+}
+
+// add weighted node affinity terms that pod does not have.
+func MergePreferredNodeAffinity(affinity *corev1.Affinity, terms []corev1.PreferredSchedulingTerm) *corev1.Affinity {
+	if len(terms) == 0 {
+		return affinity
+	}
+
+	result := copyAffinity(affinity)
+
+	if result.NodeAffinity == nil {
+		result.NodeAffinity = &corev1.NodeAffinity{}
+	}
+
+	for _, term := range terms {
+		exists := slices.ContainsFunc(result.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution, func(podTerm corev1.PreferredSchedulingTerm) bool { //nolint:lll
+			return reflect.DeepEqual(podTerm, term)
+		})
+
+		if !exists {
+			result.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(result.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution, *term.DeepCopy()) //nolint:lll
+		}
+	}
+
+	return result
+}
+
+// add requirements to every required node selector term, requirement is not added
+// to term that already has requirement with the same key.
+func MergeRequiredNodeAffinity(affinity *corev1.Affinity, requirements []corev1.NodeSelectorRequirement) *corev1.Affinity {
+	if len(requirements) == 0 {
+		return affinity
+	}
+
+	result := copyAffinity(affinity)
+
+	if result.NodeAffinity == nil {
+		result.NodeAffinity = &corev1.NodeAffinity{}
+	}
+
+	if result.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		result.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{}
+	}
+
+	required := result.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+
+	if len(required.NodeSelectorTerms) == 0 {
+		required.NodeSelectorTerms = []corev1.NodeSelectorTerm{{}}
+	}
+
+	// terms are ORed, so requirement must be in every term
+	for i := range required.NodeSelectorTerms {
+		term := &required.NodeSelectorTerms[i]
+
+		for _, requirement := range requirements {
+			exists := slices.ContainsFunc(term.MatchExpressions, func(expression corev1.NodeSelectorRequirement) bool {
+				return expression.Key == requirement.Key
+			})
+
+			if !exists {
+				term.MatchExpressions = append(term.MatchExpressions, *requirement.DeepCopy())
+			}
+		}
+	}
+
+	return result
+}
+
+func hasPodAntiAffinity(affinity *corev1.Affinity, topologyKey string) bool {
+	if affinity == nil || affinity.PodAntiAffinity == nil {
+		return false
+	}
+
+	for _, term := range affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution {
+		if term.TopologyKey == topologyKey {
+			return true
+		}
+	}
+
+	for _, term := range affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+		if term.PodAffinityTerm.TopologyKey == topologyKey {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pod spec must not be changed, patches are created from it.
+func copyAffinity(affinity *corev1.Affinity) *corev1.Affinity {
+	if affinity == nil {
+		return &corev1.Affinity{}
+	}
+
+	return affinity.DeepCopy()
+}
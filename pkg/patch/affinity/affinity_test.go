@@ -0,0 +1,177 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package affinity_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/affinity"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAffinity(t *testing.T) { //nolint:funlen
+	t.Parallel()
+
+	spotTerm := corev1.PreferredSchedulingTerm{
+		Weight: 80,
+		Preference: corev1.NodeSelectorTerm{
+			MatchExpressions: []corev1.NodeSelectorRequirement{
+				{Key: "node.kubernetes.io/lifecycle", Operator: corev1.NodeSelectorOpIn, Values: []string{"spot"}},
+			},
+		},
+	}
+
+	zoneRequirement := corev1.NodeSelectorRequirement{
+		Key:      corev1.LabelTopologyZone,
+		Operator: corev1.NodeSelectorOpIn,
+		Values:   []string{"zone-a", "zone-b"},
+	}
+
+	podAffinity := &corev1.Affinity{
+		NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{
+					{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "disk", Operator: corev1.NodeSelectorOpExists}}},
+					{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: corev1.LabelTopologyZone, Operator: corev1.NodeSelectorOpIn, Values: []string{"zone-c"}}}}, //nolint:lll
+				},
+			},
+			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.PreferredSchedulingTerm{spotTerm},
+		},
+	}
+
+	containerInfo := &types.ContainerInfo{
+		PodLabels: map[string]string{
+			"app":               "test",
+			"pod-template-hash": "123",
+		},
+		PodContainer: &types.PodContainer{
+			Type:      "container",
+			Container: &corev1.Container{},
+			Pod: &corev1.Pod{
+				Spec: corev1.PodSpec{Affinity: podAffinity.DeepCopy()},
+			},
+		},
+		SelectedRules: []*types.Rule{
+			{
+				Affinity: types.Affinity{
+					Enabled: true,
+					PodAntiAffinity: types.PodAntiAffinity{
+						Enabled: true,
+					},
+					PreferredNodeAffinity: []corev1.PreferredSchedulingTerm{spotTerm},
+					RequiredNodeAffinity:  []corev1.NodeSelectorRequirement{zoneRequirement},
+				},
+			},
+		},
+	}
+
+	patch := affinity.Patch{}
+
+	patchOps, err := patch.Create(t.Context(), containerInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(patchOps) != 1 || patchOps[0].Op != "add" || patchOps[0].Path != "/spec/affinity" {
+		t.Fatalf("not corrected patch %+v", patchOps)
+	}
+
+	result, ok := patchOps[0].Value.(*corev1.Affinity)
+	if !ok {
+		t.Fatalf("not corrected patch value type %T", patchOps[0].Value)
+	}
+
+	if !reflect.DeepEqual(containerInfo.PodContainer.Pod.Spec.Affinity, podAffinity) {
+		t.Fatal("pod affinity must not be changed")
+	}
+
+	if len(result.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution) != 1 {
+		t.Fatalf("pod preferred term must not be duplicated, got %+v", result.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution) //nolint:lll
+	}
+
+	terms := result.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+
+	if !reflect.DeepEqual(terms[0].MatchExpressions[1], zoneRequirement) {
+		t.Fatalf("zone requirement must be added to term, got %+v", terms[0])
+	}
+
+	if len(terms[1].MatchExpressions) != 1 || terms[1].MatchExpressions[0].Values[0] != "zone-c" {
+		t.Fatalf("pod zone requirement must be kept, got %+v", terms[1])
+	}
+
+	preferred := result.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution
+
+	if len(preferred) != 1 || preferred[0].Weight != 100 || preferred[0].PodAffinityTerm.TopologyKey != corev1.LabelHostname {
+		t.Fatalf("not corrected pod anti affinity %+v", preferred)
+	}
+
+	if !reflect.DeepEqual(preferred[0].PodAffinityTerm.LabelSelector.MatchLabels, map[string]string{"app": "test"}) {
+		t.Fatalf("not corrected label selector %+v", preferred[0].PodAffinityTerm.LabelSelector)
+	}
+
+	// all terms are in pod
+	containerInfo.PodContainer.Pod.Spec.Affinity = result
+
+	patchOps, err = patch.Create(t.Context(), containerInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(patchOps) != 0 {
+		t.Fatalf("patch must not be created, got %+v", patchOps)
+	}
+}
+
+func TestMergePodAntiAffinity(t *testing.T) {
+	t.Parallel()
+
+	containerInfo := &types.ContainerInfo{
+		PodLabels: map[string]string{
+			"app":     "test",
+			"version": "v1",
+		},
+	}
+
+	rule := types.PodAntiAffinity{
+		Enabled:     true,
+		Type:        types.AffinityTypeRequired,
+		TopologyKey: corev1.LabelTopologyZone,
+		LabelKeys:   []string{"app"},
+	}
+
+	result := affinity.MergePodAntiAffinity(nil, rule, containerInfo)
+
+	required := []corev1.PodAffinityTerm{
+		{
+			TopologyKey:   corev1.LabelTopologyZone,
+			LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "test"}},
+		},
+	}
+
+	if !reflect.DeepEqual(result.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution, required) {
+		t.Fatalf("not corrected pod anti affinity %+v", result.PodAntiAffinity)
+	}
+
+	// pod already has anti affinity with the same topology key
+	if again := affinity.MergePodAntiAffinity(result, rule, containerInfo); again != result {
+		t.Fatalf("pod anti affinity must not be changed, got %+v", again.PodAntiAffinity)
+	}
+
+	// workload without labels
+	if empty := affinity.MergePodAntiAffinity(nil, rule, &types.ContainerInfo{}); empty != nil {
+		t.Fatalf("pod anti affinity must not be added, got %+v", empty)
+	}
+}
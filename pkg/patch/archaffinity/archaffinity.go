@@ -0,0 +1,185 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package archaffinity
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/affinity"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/volumezone"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/registry"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// deprecated label that can be used in old pod specs.
+const labelArchBeta = "beta.kubernetes.io/arch"
+
+type Patch struct{}
+
+// add node affinity to architectures that are supported by all pod images.
+func (p *Patch) Create(ctx context.Context, containerInfo *types.ContainerInfo) ([]types.PatchOperation, error) {
+	for _, selectedRule := range containerInfo.SelectedRules {
+		if !selectedRule.NodeArchAffinity.Enabled {
+			continue
+		}
+
+		selectedRule.Logf("CreateNodeArchAffinity: %+v", selectedRule.NodeArchAffinity)
+
+		pod := containerInfo.PodContainer.Pod
+
+		// pod architecture is selected by user
+		if pod == nil || PodHasArchitecture(pod) {
+			return []types.PatchOperation{}, nil
+		}
+
+		architectures, err := p.GetPodArchitectures(ctx, containerInfo, selectedRule.NodeArchAffinity)
+		if err != nil {
+			log.WithError(err).Warnf("architectures of pod images are not resolved")
+
+			containerInfo.AddWarning("architectures of pod images are not resolved, node affinity is not added")
+
+			return []types.PatchOperation{}, nil
+		}
+
+		selectedRule.Logf("CreateNodeArchAffinity: architectures=%s", architectures)
+
+		if len(architectures) == 0 {
+			containerInfo.AddWarning("pod images have no common architecture with nodes %s", strings.Join(selectedRule.NodeArchAffinity.GetArchitectures(), ",")) //nolint:lll
+
+			return []types.PatchOperation{}, nil
+		}
+
+		// pod can be scheduled on all nodes
+		if len(architectures) == len(selectedRule.NodeArchAffinity.GetArchitectures()) {
+			return []types.PatchOperation{}, nil
+		}
+
+		podAffinity, err := (&volumezone.Patch{}).GetFinalAffinity(containerInfo)
+		if err != nil {
+			return nil, errors.Wrap(err, "error getting pod affinity")
+		}
+
+		return []types.PatchOperation{
+			{
+				Op:    "add",
+				Path:  "/spec/affinity",
+				Value: MergeNodeAffinity(podAffinity, architectures),
+			},
+		}, nil
+	}
+
+	return []types.PatchOperation{}, nil
+}
+
+// returns node architectures that are supported by all pod images.
+func (p *Patch) GetPodArchitectures(ctx context.Context, containerInfo *types.ContainerInfo, nodeArchAffinity types.NodeArchAffinity) ([]string, error) { //nolint:lll
+	opts, err := registry.GetOptions(ctx, containerInfo, nodeArchAffinity.RegistryOptions)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting registry options")
+	}
+
+	pod := containerInfo.PodContainer.Pod
+
+	images := make([]*types.ContainerImage, 0)
+
+	for _, container := range slices.Concat(pod.Spec.InitContainers, pod.Spec.Containers) {
+		image, err := types.NewContainerImage(container.Image)
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing image")
+		}
+
+		images = append(images, image)
+	}
+
+	// images are resolved concurrently, all requests share admission deadline
+	imagesArchitectures := make([][]string, len(images))
+	imagesErrors := make([]error, len(images))
+
+	var wg sync.WaitGroup
+
+	for imageID, image := range images {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			reference := image.Tag
+
+			if len(image.Digest) > 0 {
+				reference = image.Digest
+			}
+
+			imagesArchitectures[imageID], imagesErrors[imageID] = registry.GetArchitectures(ctx, image.Domain, image.Path, reference, opts) //nolint:lll
+		}()
+	}
+
+	wg.Wait()
+
+	for imageID, err := range imagesErrors {
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting architectures of image %s", images[imageID].Name)
+		}
+	}
+
+	result := slices.Clone(nodeArchAffinity.GetArchitectures())
+
+	for _, imageArchitectures := range imagesArchitectures {
+		result = slices.DeleteFunc(result, func(architecture string) bool {
+			return !slices.Contains(imageArchitectures, architecture)
+		})
+	}
+
+	return result, nil
+}
+
+// check that pod selects architecture with nodeSelector or required node affinity.
+func PodHasArchitecture(pod *corev1.Pod) bool {
+	for _, label := range []string{corev1.LabelArchStable, labelArchBeta} {
+		if _, ok := pod.Spec.NodeSelector[label]; ok {
+			return true
+		}
+	}
+
+	affinity := pod.Spec.Affinity
+
+	if affinity == nil || affinity.NodeAffinity == nil || affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil { //nolint:lll
+		return false
+	}
+
+	for _, term := range affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		for _, expression := range term.MatchExpressions {
+			if expression.Key == corev1.LabelArchStable || expression.Key == labelArchBeta {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// add architecture requirement to every required node selector term, other pod affinity is kept.
+func MergeNodeAffinity(podAffinity *corev1.Affinity, architectures []string) *corev1.Affinity {
+	return affinity.MergeRequiredNodeAffinity(podAffinity, []corev1.NodeSelectorRequirement{
+		{
+			Key:      corev1.LabelArchStable,
+			Operator: corev1.NodeSelectorOpIn,
+			Values:   architectures,
+		},
+	})
+}
@@ -0,0 +1,211 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package archaffinity_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/archaffinity"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/registry"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestArchAffinity(t *testing.T) { //nolint:funlen
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/multi/manifests/v1":
+			_, _ = w.Write([]byte(`{"manifests":[{"platform":{"os":"linux","architecture":"amd64"}},{"platform":{"os":"linux","architecture":"arm64"}}]}`)) //nolint:lll
+		case "/v2/amd64/manifests/v1":
+			_, _ = w.Write([]byte(`{"manifests":[{"platform":{"os":"linux","architecture":"amd64"}}]}`))
+		case "/v2/arm64/manifests/v1":
+			_, _ = w.Write([]byte(`{"manifests":[{"platform":{"os":"linux","architecture":"arm64"}}]}`))
+		case "/v2/slow-a/manifests/v1", "/v2/slow-b/manifests/v1":
+			time.Sleep(700 * time.Millisecond)
+
+			_, _ = w.Write([]byte(`{"manifests":[{"platform":{"os":"linux","architecture":"amd64"}}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	// subtests are parallel
+	t.Cleanup(server.Close)
+
+	domain := strings.TrimPrefix(server.URL, "http://")
+
+	type testCase struct {
+		Name     string
+		Images   []string
+		Spec     corev1.PodSpec
+		Expected []string
+		Warning  bool
+		Timeout  int
+	}
+
+	tests := []testCase{
+		{
+			Name:   "multi arch images",
+			Images: []string{"multi:v1", "multi:v1"},
+		},
+		{
+			Name:     "single arch image",
+			Images:   []string{"multi:v1", "amd64:v1"},
+			Expected: []string{"amd64"},
+		},
+		{
+			Name:    "no common architecture",
+			Images:  []string{"arm64:v1", "amd64:v1"},
+			Warning: true,
+		},
+		{
+			// sequential requests exceed admission deadline
+			Name:     "images are resolved concurrently",
+			Images:   []string{"slow-a:v1", "slow-b:v1"},
+			Expected: []string{"amd64"},
+			Timeout:  1,
+		},
+		{
+			Name:    "unknown image",
+			Images:  []string{"unknown:v1"},
+			Warning: true,
+		},
+		{
+			Name:   "pod with architecture",
+			Images: []string{"amd64:v1"},
+			Spec: corev1.PodSpec{
+				NodeSelector: map[string]string{corev1.LabelArchStable: "amd64"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			pod := &corev1.Pod{Spec: test.Spec}
+
+			for _, image := range test.Images {
+				pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Image: domain + "/" + image})
+			}
+
+			containerInfo := &types.ContainerInfo{
+				PodContainer: &types.PodContainer{
+					Pod:       pod,
+					Type:      types.PodContainerTypeContainer,
+					Container: &pod.Spec.Containers[0],
+				},
+				SelectedRules: []*types.Rule{
+					{
+						NodeArchAffinity: types.NodeArchAffinity{
+							Enabled: true,
+							RegistryOptions: types.RegistryOptions{
+								InsecureRegistries: []string{domain},
+								TimeoutSeconds:     test.Timeout,
+							},
+						},
+					},
+				},
+			}
+
+			patchOps, err := (&archaffinity.Patch{}).Create(registry.WithAdmissionStart(t.Context()), containerInfo)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.Warning != (len(containerInfo.Warnings) > 0) {
+				t.Fatalf("not corrected warnings %v", containerInfo.Warnings)
+			}
+
+			if len(test.Expected) == 0 {
+				if len(patchOps) != 0 {
+					t.Fatalf("patch must not be created, got %+v", patchOps)
+				}
+
+				return
+			}
+
+			if len(patchOps) != 1 {
+				t.Fatal("1 patch must be created")
+			}
+
+			affinity, ok := patchOps[0].Value.(*corev1.Affinity)
+			if !ok {
+				t.Fatal("value must be affinity")
+			}
+
+			values := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions[0].Values //nolint:lll
+
+			if !reflect.DeepEqual(values, test.Expected) {
+				t.Fatalf("architectures must be %v, got %v", test.Expected, values)
+			}
+		})
+	}
+}
+
+func TestMergeNodeAffinity(t *testing.T) {
+	t.Parallel()
+
+	zoneRequirement := corev1.NodeSelectorRequirement{
+		Key:      corev1.LabelTopologyZone,
+		Operator: corev1.NodeSelectorOpIn,
+		Values:   []string{"a"},
+	}
+
+	affinity := &corev1.Affinity{
+		NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{
+					{MatchExpressions: []corev1.NodeSelectorRequirement{zoneRequirement}},
+					{MatchFields: []corev1.NodeSelectorRequirement{{Key: "metadata.name", Operator: corev1.NodeSelectorOpIn, Values: []string{"node"}}}}, //nolint:lll
+				},
+			},
+		},
+		PodAntiAffinity: &corev1.PodAntiAffinity{},
+	}
+
+	result := archaffinity.MergeNodeAffinity(affinity, []string{"arm64"})
+
+	if result.PodAntiAffinity == nil {
+		t.Fatal("pod anti affinity must be kept")
+	}
+
+	terms := result.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+
+	if len(terms) != 2 {
+		t.Fatal("terms must be kept")
+	}
+
+	for _, term := range terms {
+		last := term.MatchExpressions[len(term.MatchExpressions)-1]
+
+		if last.Key != corev1.LabelArchStable {
+			t.Fatal("architecture must be added to every term")
+		}
+	}
+
+	if len(affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions) != 1 {
+		t.Fatal("pod affinity must not be changed")
+	}
+
+	if !archaffinity.PodHasArchitecture(&corev1.Pod{Spec: corev1.PodSpec{Affinity: result}}) {
+		t.Fatal("pod must have architecture")
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dnsconfig
+
+import (
+	"context"
+	"reflect"
+	"slices"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// limits of pod dnsConfig validation.
+const (
+	maxNameservers = 3
+	maxSearches    = 32
+)
+
+type Patch struct{}
+
+// merge rule dns config with pod dnsConfig.
+func (p *Patch) Create(_ context.Context, containerInfo *types.ContainerInfo) ([]types.PatchOperation, error) {
+	if containerInfo.PodContainer == nil || containerInfo.PodContainer.Pod == nil {
+		return []types.PatchOperation{}, nil
+	}
+
+	pod := containerInfo.PodContainer.Pod
+
+	for _, selectedRule := range containerInfo.SelectedRules {
+		dnsConfig := selectedRule.DNSConfig
+
+		if !dnsConfig.Enabled {
+			continue
+		}
+
+		selectedRule.Logf("CreateDNSConfig: %+v", dnsConfig)
+
+		// pod manages dns itself
+		if (pod.Spec.HostNetwork || pod.Spec.DNSPolicy == corev1.DNSNone) && !dnsConfig.Force {
+			return []types.PatchOperation{}, nil
+		}
+
+		result := MergeDNSConfig(pod.Spec.DNSConfig, dnsConfig)
+
+		if len(result.Nameservers) > maxNameservers {
+			containerInfo.AddWarning("pod can not have more than %d nameservers, nameservers are not added", maxNameservers)
+
+			result.Nameservers = getPodDNSConfig(pod).Nameservers
+		}
+
+		if len(result.Searches) > maxSearches {
+			containerInfo.AddWarning("pod can not have more than %d search domains, search domains are not added", maxSearches)
+
+			result.Searches = getPodDNSConfig(pod).Searches
+		}
+
+		if reflect.DeepEqual(result, getPodDNSConfig(pod)) {
+			return []types.PatchOperation{}, nil
+		}
+
+		return []types.PatchOperation{
+			{
+				Op:    "add",
+				Path:  "/spec/dnsConfig",
+				Value: result,
+			},
+		}, nil
+	}
+
+	return []types.PatchOperation{}, nil
+}
+
+// add nameservers, searches and options that pod does not have, pod options with the same name are kept.
+func MergeDNSConfig(podDNSConfig *corev1.PodDNSConfig, dnsConfig types.DNSConfig) *corev1.PodDNSConfig {
+	result := &corev1.PodDNSConfig{}
+
+	if podDNSConfig != nil {
+		result = podDNSConfig.DeepCopy()
+	}
+
+	for _, nameserver := range dnsConfig.Nameservers {
+		if !slices.Contains(result.Nameservers, nameserver) {
+			result.Nameservers = append(result.Nameservers, nameserver)
+		}
+	}
+
+	for _, search := range dnsConfig.Searches {
+		if !slices.Contains(result.Searches, search) {
+			result.Searches = append(result.Searches, search)
+		}
+	}
+
+	for _, option := range dnsConfig.Options {
+		if !slices.ContainsFunc(result.Options, func(podOption corev1.PodDNSConfigOption) bool {
+			return podOption.Name == option.Name
+		}) {
+			result.Options = append(result.Options, *option.DeepCopy())
+		}
+	}
+
+	return result
+}
+
+func getPodDNSConfig(pod *corev1.Pod) *corev1.PodDNSConfig {
+	if pod.Spec.DNSConfig == nil {
+		return &corev1.PodDNSConfig{}
+	}
+
+	return pod.Spec.DNSConfig
+}
@@ -0,0 +1,120 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dnsconfig_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/dnsconfig"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestDNSConfig(t *testing.T) { //nolint:funlen
+	t.Parallel()
+
+	ruleDNSConfig := types.DNSConfig{
+		Enabled:  true,
+		Searches: []string{"svc.cluster.local"},
+		Options: []corev1.PodDNSConfigOption{
+			{Name: "ndots", Value: utils.Pnt("2")},
+			{Name: "single-request-reopen"},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		podSpec corev1.PodSpec
+		force   bool
+		want    *corev1.PodDNSConfig
+	}{
+		{
+			name: "pod without dns config",
+			want: &corev1.PodDNSConfig{
+				Searches: []string{"svc.cluster.local"},
+				Options: []corev1.PodDNSConfigOption{
+					{Name: "ndots", Value: utils.Pnt("2")},
+					{Name: "single-request-reopen"},
+				},
+			},
+		},
+		{
+			name: "pod options are kept",
+			podSpec: corev1.PodSpec{
+				DNSConfig: &corev1.PodDNSConfig{
+					Searches: []string{"example.com"},
+					Options:  []corev1.PodDNSConfigOption{{Name: "ndots", Value: utils.Pnt("1")}},
+				},
+			},
+			want: &corev1.PodDNSConfig{
+				Searches: []string{"example.com", "svc.cluster.local"},
+				Options: []corev1.PodDNSConfigOption{
+					{Name: "ndots", Value: utils.Pnt("1")},
+					{Name: "single-request-reopen"},
+				},
+			},
+		},
+		{
+			name:    "host network pod",
+			podSpec: corev1.PodSpec{HostNetwork: true},
+		},
+		{
+			name:    "dns policy none with force",
+			podSpec: corev1.PodSpec{DNSPolicy: corev1.DNSNone, DNSConfig: &corev1.PodDNSConfig{Nameservers: []string{"1.1.1.1"}}},
+			force:   true,
+			want: &corev1.PodDNSConfig{
+				Nameservers: []string{"1.1.1.1"},
+				Searches:    []string{"svc.cluster.local"},
+				Options: []corev1.PodDNSConfigOption{
+					{Name: "ndots", Value: utils.Pnt("2")},
+					{Name: "single-request-reopen"},
+				},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			rule := ruleDNSConfig
+			rule.Force = tc.force
+
+			containerInfo := &types.ContainerInfo{
+				PodContainer: &types.PodContainer{
+					Pod: &corev1.Pod{Spec: tc.podSpec},
+				},
+				SelectedRules: []*types.Rule{{DNSConfig: rule}},
+			}
+
+			patchOps, err := (&dnsconfig.Patch{}).Create(t.Context(), containerInfo)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tc.want == nil {
+				if len(patchOps) != 0 {
+					t.Fatalf("patch must not be created, got %+v", patchOps)
+				}
+
+				return
+			}
+
+			if len(patchOps) != 1 || !reflect.DeepEqual(patchOps[0].Value, tc.want) {
+				t.Fatalf("want %+v, got %+v", tc.want, patchOps)
+			}
+		})
+	}
+}
@@ -14,6 +14,7 @@ package env
 
 import (
 	"context"
+	"slices"
 	"strings"
 
 	"github.com/maksim-paskal/pod-admission-controller/pkg/template"
@@ -22,8 +23,26 @@ import (
 	corev1 "k8s.io/api/core/v1"
 )
 
+const patchName = "env"
+
 type Patch struct{}
 
+// returns container env that will be after patch.
+func (p *Patch) GetFinalEnv(ctx context.Context, containerInfo *types.ContainerInfo) ([]corev1.EnvVar, error) {
+	containerEnv := containerInfo.PodContainer.Container.Env
+
+	if containerInfo.IgnorePatch(patchName) {
+		return containerEnv, nil
+	}
+
+	patchOps, err := p.Create(ctx, containerInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	return ApplyEnvPatch(containerEnv, patchOps), nil
+}
+
 func (p *Patch) Create(_ context.Context, containerInfo *types.ContainerInfo) ([]types.PatchOperation, error) {
 	// some containers don't need env
 	// pod-admission-controller/ignoreEnv=container1,container2
@@ -91,3 +110,58 @@ func (p *Patch) FormatEnv(containerInfo *types.ContainerInfo, containersEnv []co
 
 	return formattedEnv, nil
 }
+
+// returns container env with env from patch operations.
+func ApplyEnvPatch(containerEnv []corev1.EnvVar, patchOps []types.PatchOperation) []corev1.EnvVar {
+	result := slices.Clone(containerEnv)
+
+	for _, patchOp := range patchOps {
+		switch value := patchOp.Value.(type) {
+		case corev1.EnvVar:
+			result = append(result, value)
+		case []corev1.EnvVar:
+			result = append(result, value...)
+		}
+	}
+
+	return result
+}
+
+// create patch for env that are not in container env that will be after previous patches.
+func CreateEnvPatch(containerInfo *types.ContainerInfo, finalEnv, newEnv []corev1.EnvVar) []types.PatchOperation {
+	envPath := containerInfo.PodContainer.ContainerPath() + "/env"
+
+	addEnv := make([]corev1.EnvVar, 0)
+
+	for _, envVar := range newEnv {
+		if !slices.ContainsFunc(finalEnv, func(containerEnv corev1.EnvVar) bool {
+			return containerEnv.Name == envVar.Name
+		}) {
+			addEnv = append(addEnv, envVar)
+		}
+	}
+
+	if len(addEnv) == 0 {
+		return []types.PatchOperation{}
+	}
+
+	if len(finalEnv) == 0 {
+		return []types.PatchOperation{{
+			Op:    "add",
+			Path:  envPath,
+			Value: addEnv,
+		}}
+	}
+
+	patch := make([]types.PatchOperation, 0)
+
+	for _, envVar := range addEnv {
+		patch = append(patch, types.PatchOperation{
+			Op:    "add",
+			Path:  envPath + "/-",
+			Value: envVar,
+		})
+	}
+
+	return patch
+}
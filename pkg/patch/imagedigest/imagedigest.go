@@ -0,0 +1,132 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package imagedigest
+
+import (
+	"context"
+
+	"github.com/distribution/reference"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/metrics"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/imagehost"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/registry"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const patchName = "imagedigest"
+
+type Patch struct{}
+
+// returns digest that image will have after patch, empty if digest is not added.
+func (p *Patch) GetFinalDigest(ctx context.Context, containerInfo *types.ContainerInfo) (string, error) {
+	if len(containerInfo.Image.Digest) > 0 {
+		return containerInfo.Image.Digest, nil
+	}
+
+	if containerInfo.IgnorePatch(patchName) {
+		return "", nil
+	}
+
+	for _, selectedRule := range containerInfo.SelectedRules {
+		if selectedRule.PinImageDigest.Enabled {
+			return p.GetDigest(ctx, containerInfo, selectedRule.PinImageDigest)
+		}
+	}
+
+	return "", nil
+}
+
+// replace image tag with digest from registry.
+func (p *Patch) Create(ctx context.Context, containerInfo *types.ContainerInfo) ([]types.PatchOperation, error) {
+	for _, selectedRule := range containerInfo.SelectedRules {
+		if !selectedRule.PinImageDigest.Enabled {
+			continue
+		}
+
+		selectedRule.Logf("CreatePinImageDigest: %+v", selectedRule.PinImageDigest)
+
+		// image is already pinned
+		if len(containerInfo.Image.Digest) > 0 {
+			return []types.PatchOperation{}, nil
+		}
+
+		digest, err := p.GetDigest(ctx, containerInfo, selectedRule.PinImageDigest)
+		if err != nil {
+			metrics.ImageDigestErrors.WithLabelValues(containerInfo.Image.Domain).Inc()
+
+			if selectedRule.PinImageDigest.FailPolicy == types.FailPolicyClosed {
+				return nil, types.NewDenyError("digest of image %s is not resolved: %s", containerInfo.Image.Name, err.Error())
+			}
+
+			log.WithError(err).Warnf("digest of image %s is not resolved", containerInfo.Image.Name)
+
+			containerInfo.AddWarning("container %s uses image %s without digest", containerInfo.ContainerName, containerInfo.Image.Name) //nolint:lll
+
+			return []types.PatchOperation{}, nil
+		}
+
+		// digest is the same for mirrors, so pin image after host replacement
+		image, err := (&imagehost.Patch{}).GetFinalImage(containerInfo)
+		if err != nil {
+			return nil, errors.Wrap(err, "error getting container image")
+		}
+
+		pinnedImage, err := PinImage(image, digest)
+		if err != nil {
+			return nil, errors.Wrap(err, "error pinning image")
+		}
+
+		selectedRule.Logf("CreatePinImageDigest: image=%s", pinnedImage)
+
+		return []types.PatchOperation{
+			{
+				Op:    "replace",
+				Path:  containerInfo.PodContainer.ContainerPath() + "/image",
+				Value: pinnedImage,
+			},
+		}, nil
+	}
+
+	return []types.PatchOperation{}, nil
+}
+
+// resolve image digest from original registry.
+func (p *Patch) GetDigest(ctx context.Context, containerInfo *types.ContainerInfo, pinImageDigest types.PinImageDigest) (string, error) { //nolint:lll
+	opts, err := registry.GetOptions(ctx, containerInfo, pinImageDigest.RegistryOptions)
+	if err != nil {
+		return "", errors.Wrap(err, "error getting registry options")
+	}
+
+	digest, err := registry.GetDigest(ctx,
+		containerInfo.Image.Domain,
+		containerInfo.Image.Path,
+		containerInfo.Image.Tag,
+		opts,
+	)
+	if err != nil {
+		return "", errors.Wrap(err, "error getting image digest")
+	}
+
+	return digest, nil
+}
+
+// replace image tag with digest, for example alpine:3.12 -> alpine@sha256:...
+func PinImage(image, digest string) (string, error) {
+	refName, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", errors.Wrapf(err, "error parsing image name %s", image)
+	}
+
+	return reference.FamiliarName(refName) + "@" + digest, nil
+}
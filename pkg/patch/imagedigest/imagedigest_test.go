@@ -0,0 +1,210 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package imagedigest_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/api"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/client"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/imagedigest"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testDigest = "sha256:8fd21d59428507671ce0fb47f818b1d859c92d2ad07bb7c947268d433030ba98"
+
+func TestPinImageDigest(t *testing.T) { //nolint:funlen,maintidx
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/public/app/manifests/v1":
+			w.Header().Set("Docker-Content-Digest", testDigest)
+		case "/v2/private/app/manifests/v1":
+			if username, password, ok := r.BasicAuth(); !ok || username != "user" || password != "password" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+				w.WriteHeader(http.StatusUnauthorized)
+
+				return
+			}
+
+			w.Header().Set("Docker-Content-Digest", testDigest)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	domain := strings.TrimPrefix(server.URL, "http://")
+
+	client.SetKubeClient(fake.NewClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "registry",
+			Namespace: "test",
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths":{"` + domain + `":{"username":"user","password":"password"}}}`),
+		},
+	}))
+	defer client.SetKubeClient(nil)
+
+	type testCase struct {
+		Image            string
+		PullSecrets      []corev1.LocalObjectReference
+		FailPolicy       string
+		ReplaceImageHost types.ReplaceContainerImageHost
+		Expected         string
+		Warning          bool
+		Deny             bool
+	}
+
+	// digests are cached, so private image without credentials must be checked first
+	tests := []testCase{
+		{
+			Image:   domain + "/private/app:v1",
+			Warning: true,
+		},
+		{
+			Image:    domain + "/public/app:v1",
+			Expected: domain + "/public/app@" + testDigest,
+		},
+		{
+			Image:       domain + "/private/app:v1",
+			PullSecrets: []corev1.LocalObjectReference{{Name: "registry"}},
+			Expected:    domain + "/private/app@" + testDigest,
+		},
+		{
+			Image: domain + "/public/app:v1",
+			ReplaceImageHost: types.ReplaceContainerImageHost{
+				Enabled: true,
+				Mirrors: []types.ImageMirror{
+					{
+						Registry: domain,
+						Mirror:   "mirror.example.com",
+					},
+				},
+			},
+			Expected: "mirror.example.com/public/app@" + testDigest,
+		},
+		{
+			Image:      domain + "/public/app:unknown",
+			FailPolicy: types.FailPolicyClosed,
+			Deny:       true,
+		},
+		{
+			Image: domain + "/public/app@" + testDigest,
+		},
+	}
+
+	for _, test := range tests {
+		imageInfo, err := api.GetImageInfo(test.Image)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		containerInfo := &types.ContainerInfo{
+			ContainerName: "test",
+			Namespace:     "test",
+			PodContainer: &types.PodContainer{
+				Type:      types.PodContainerTypeContainer,
+				Container: &corev1.Container{},
+				Pod: &corev1.Pod{
+					Spec: corev1.PodSpec{
+						ImagePullSecrets: test.PullSecrets,
+					},
+				},
+			},
+			Image: imageInfo,
+			SelectedRules: []*types.Rule{
+				{
+					ReplaceContainerImageHost: test.ReplaceImageHost,
+					PinImageDigest: types.PinImageDigest{
+						Enabled:    true,
+						FailPolicy: test.FailPolicy,
+						RegistryOptions: types.RegistryOptions{
+							InsecureRegistries: []string{domain},
+						},
+					},
+				},
+			},
+		}
+
+		patchOps, err := (&imagedigest.Patch{}).Create(t.Context(), containerInfo)
+
+		var denyError *types.DenyError
+
+		if test.Deny {
+			if !errors.As(err, &denyError) {
+				t.Fatalf("image %s must be denied, got %v", test.Image, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if test.Warning != (len(containerInfo.Warnings) > 0) {
+			t.Fatalf("image %s warnings %v", test.Image, containerInfo.Warnings)
+		}
+
+		if len(test.Expected) == 0 {
+			if len(patchOps) != 0 {
+				t.Fatalf("image %s must not be pinned, got %+v", test.Image, patchOps)
+			}
+
+			continue
+		}
+
+		if len(patchOps) != 1 {
+			t.Fatal("1 patch must be created")
+		}
+
+		if patchOps[0].Path != "/spec/containers/0/image" {
+			t.Fatalf("not corrected path %s", patchOps[0].Path)
+		}
+
+		if patchOps[0].Value != test.Expected {
+			t.Fatalf("not corrected value got=%s, required=%s", patchOps[0].Value, test.Expected)
+		}
+	}
+}
+
+func TestPinImage(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"alpine:3.12":                       "alpine@" + testDigest,
+		"docker.io/library/alpine":          "alpine@" + testDigest,
+		"registry.example.com/test/app:1.0": "registry.example.com/test/app@" + testDigest,
+		"localhost:5000/app:1.0":            "localhost:5000/app@" + testDigest,
+	}
+
+	for image, required := range tests {
+		pinnedImage, err := imagedigest.PinImage(image, testDigest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if pinnedImage != required {
+			t.Fatalf("image %s must be pinned to %s, got %s", image, required, pinnedImage)
+		}
+	}
+}
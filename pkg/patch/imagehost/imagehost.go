@@ -17,16 +17,118 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/distribution/reference"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/metrics"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/registry"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/template"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
 	"github.com/pkg/errors"
 )
 
+const patchName = "imagehost"
+
 type Patch struct{}
 
 func (p *Patch) Create(_ context.Context, containerInfo *types.ContainerInfo) ([]types.PatchOperation, error) { //nolint:lll
+	return p.create(containerInfo, true)
+}
+
+// return container image as it will be after this patch,
+// used by other patches that depend on container image.
+func (p *Patch) GetFinalImage(containerInfo *types.ContainerInfo) (string, error) {
+	if containerInfo.IgnorePatch(patchName) {
+		return containerInfo.Image.Name, nil
+	}
+
+	// warnings are returned by Create
+	info := *containerInfo
+	info.Warnings = nil
+
+	patchOps, err := p.create(&info, false)
+	if err != nil {
+		return "", err
+	}
+
+	for _, patchOp := range patchOps {
+		if image, ok := patchOp.Value.(string); ok {
+			return image, nil
+		}
+	}
+
+	return containerInfo.Image.Name, nil
+}
+
+func (p *Patch) create(containerInfo *types.ContainerInfo, withMetrics bool) ([]types.PatchOperation, error) {
 	patch := make([]types.PatchOperation, 0)
 
+	for _, selectedRule := range containerInfo.SelectedRules {
+		if !selectedRule.ReplaceContainerImageHost.Enabled {
+			continue
+		}
+
+		selectedRule.Logf("CreateReplaceContainerImageHost: %+v", selectedRule)
+
+		var (
+			result string
+			err    error
+		)
+
+		if len(selectedRule.ReplaceContainerImageHost.Mirrors) > 0 {
+			var found bool
+
+			result, found, err = p.ReplaceWithMirror(containerInfo, selectedRule)
+			if err != nil {
+				return nil, errors.Wrap(err, "error replacing image with mirror")
+			}
+
+			// image registry is not in mirrors table, try next rule
+			if !found {
+				continue
+			}
+		} else {
+			result, err = p.ReplaceHost(containerInfo, selectedRule)
+			if err != nil {
+				return nil, errors.Wrap(err, "error replacing image host")
+			}
+		}
+
+		selectedRule.Logf("CreateReplaceContainerImageHost: result=%s", result)
+
+		if result != containerInfo.Image.Name {
+			healthCheck := selectedRule.ReplaceContainerImageHost.HealthCheck
+			mirrorHost := registry.MirrorHost(result)
+
+			// keep original image while mirror is down
+			if healthCheck.Enabled && !registry.MirrorHealth().IsHealthy(mirrorHost, healthCheck) {
+				if withMetrics {
+					metrics.ImageHostFallbacks.WithLabelValues(containerInfo.Image.Domain, mirrorHost).Inc()
+				}
+
+				containerInfo.AddWarning("mirror %s is unhealthy, container %s uses original image %s", mirrorHost, containerInfo.ContainerName, containerInfo.Image.Name) //nolint:lll
+
+				break
+			}
+
+			if withMetrics {
+				metrics.ImageHostRewrites.WithLabelValues(containerInfo.Image.Domain, getImageDomain(result)).Inc()
+			}
+		}
+
+		patch = append(patch, types.PatchOperation{
+			Op:    "replace",
+			Path:  containerInfo.PodContainer.ContainerPath() + "/image",
+			Value: result,
+		})
+
+		// process only first rule
+		break
+	}
+
+	return patch, nil
+}
+
+// replace image with From regexp and To template.
+func (p *Patch) ReplaceHost(containerInfo *types.ContainerInfo, selectedRule *types.Rule) (string, error) {
 	image := containerInfo.Image.Name
 
 	if !strings.HasPrefix(image, containerInfo.Image.Domain) {
@@ -39,48 +141,83 @@ func (p *Patch) Create(_ context.Context, containerInfo *types.ContainerInfo) ([
 		image = containerInfo.Image.Domain + "/" + image
 	}
 
-	for _, selectedRule := range containerInfo.SelectedRules {
-		if !selectedRule.ReplaceContainerImageHost.Enabled {
+	// use image domain if from is empty
+	fromPattern := selectedRule.ReplaceContainerImageHost.From
+	if len(fromPattern) == 0 {
+		fromPattern = containerInfo.Image.Domain
+	}
+
+	selectedRule.Logf("CreateReplaceContainerImageHost: image=%s", image)
+	selectedRule.Logf("CreateReplaceContainerImageHost: to=%s", selectedRule.ReplaceContainerImageHost.To)
+	selectedRule.Logf("CreateReplaceContainerImageHost: from=%s", fromPattern)
+
+	fromRegexp, err := regexp.Compile(fromPattern)
+	if err != nil {
+		return "", errors.Wrap(err, "regexp.Compile")
+	}
+
+	value, err := template.Get(containerInfo, selectedRule.ReplaceContainerImageHost.To)
+	if err != nil {
+		return "", errors.Wrap(err, "template.Get")
+	}
+
+	selectedRule.Logf("CreateReplaceContainerImageHost: value=%s", value)
+
+	return string(fromRegexp.ReplaceAll([]byte(image), []byte(value))), nil
+}
+
+// replace image registry with mirror from table, returns false if registry is not in table.
+func (p *Patch) ReplaceWithMirror(containerInfo *types.ContainerInfo, selectedRule *types.Rule) (string, bool, error) {
+	for _, mirror := range selectedRule.ReplaceContainerImageHost.Mirrors {
+		if mirror.Registry != containerInfo.Image.Domain {
 			continue
 		}
 
-		selectedRule.Logf("CreateReplaceContainerImageHost: %+v", selectedRule)
-
-		// use image domain if from is empty
-		fromPattern := selectedRule.ReplaceContainerImageHost.From
-		if len(fromPattern) == 0 {
-			fromPattern = containerInfo.Image.Domain
+		refName, err := reference.ParseNormalizedNamed(containerInfo.Image.Name)
+		if err != nil {
+			return "", false, errors.Wrapf(err, "error parsing image name %s", containerInfo.Image.Name)
 		}
 
-		selectedRule.Logf("CreateReplaceContainerImageHost: image=%s", image)
-		selectedRule.Logf("CreateReplaceContainerImageHost: to=%s", selectedRule.ReplaceContainerImageHost.To)
-		selectedRule.Logf("CreateReplaceContainerImageHost: from=%s", fromPattern)
+		imagePath := reference.Path(refName)
 
-		fromRegexp, err := regexp.Compile(fromPattern)
-		if err != nil {
-			return nil, errors.Wrap(err, "regexp.Compile")
+		if len(mirror.PathFrom) > 0 {
+			pathRegexp, err := regexp.Compile(mirror.PathFrom)
+			if err != nil {
+				return "", false, errors.Wrap(err, "regexp.Compile")
+			}
+
+			imagePath = pathRegexp.ReplaceAllString(imagePath, mirror.PathTo)
 		}
 
-		value, err := template.Get(containerInfo, selectedRule.ReplaceContainerImageHost.To)
+		mirrorHost, err := template.Get(containerInfo, mirror.Mirror)
 		if err != nil {
-			return nil, errors.Wrap(err, "template.Get")
+			return "", false, errors.Wrap(err, "template.Get")
 		}
 
-		selectedRule.Logf("CreateReplaceContainerImageHost: value=%s", value)
+		selectedRule.Logf("CreateReplaceContainerImageHost: registry=%s, mirror=%s, path=%s", mirror.Registry, mirrorHost, imagePath) //nolint:lll
 
-		result := fromRegexp.ReplaceAll([]byte(image), []byte(value))
+		result := strings.TrimSuffix(mirrorHost, "/") + "/" + imagePath
 
-		selectedRule.Logf("CreateReplaceContainerImageHost: result=%s", result)
+		if tagged, ok := refName.(reference.Tagged); ok {
+			result += ":" + tagged.Tag()
+		}
 
-		patch = append(patch, types.PatchOperation{
-			Op:    "replace",
-			Path:  containerInfo.PodContainer.ContainerPath() + "/image",
-			Value: string(result),
-		})
+		if digested, ok := refName.(reference.Digested); ok {
+			result += "@" + digested.Digest().String()
+		}
 
-		// process only first rule
-		break
+		return result, true, nil
 	}
 
-	return patch, nil
+	return "", false, nil
+}
+
+// return registry of image, used in metrics.
+func getImageDomain(image string) string {
+	refName, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "unknown"
+	}
+
+	return reference.Domain(refName)
 }
@@ -13,11 +13,15 @@ limitations under the License.
 package imagehost_test
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/maksim-paskal/pod-admission-controller/pkg/api"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/imagehost"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/registry"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
 	corev1 "k8s.io/api/core/v1"
 )
@@ -234,3 +238,147 @@ func TestReplaceImageTag(t *testing.T) {
 		t.Fatalf("not corrected value got=%s, required=%s", patchOps[0].Value, requiredValue)
 	}
 }
+
+func TestReplaceImageWithMirror(t *testing.T) { //nolint:funlen
+	t.Parallel()
+
+	patch := imagehost.Patch{}
+
+	replaceContainerImageHost := types.ReplaceContainerImageHost{
+		Enabled: true,
+		Mirrors: []types.ImageMirror{
+			{
+				Registry: "docker.io",
+				Mirror:   "mirror.example.com/dockerhub",
+			},
+			{
+				Registry: "ghcr.io",
+				Mirror:   "ghcr-mirror.example.com/",
+			},
+			{
+				Registry: "registry.k8s.io",
+				Mirror:   "mirror.example.com",
+				PathFrom: "^(.+)$",
+				PathTo:   "k8s/$1",
+			},
+		},
+	}
+
+	tests := map[string]string{
+		"alpine:3.12":                     "mirror.example.com/dockerhub/library/alpine:3.12",
+		"test/alpine":                     "mirror.example.com/dockerhub/test/alpine",
+		"ghcr.io/test/app:v1":             "ghcr-mirror.example.com/test/app:v1",
+		"registry.k8s.io/pause:3.9":       "mirror.example.com/k8s/pause:3.9",
+		"quay.io/jetstack/cert-manager:1": "",
+		"alpine@sha256:8fd21d59428507671ce0fb47f818b1d859c92d2ad07bb7c947268d433030ba98": "mirror.example.com/dockerhub/library/alpine@sha256:8fd21d59428507671ce0fb47f818b1d859c92d2ad07bb7c947268d433030ba98", //nolint:lll
+	}
+
+	for image, required := range tests {
+		imageInfo, err := api.GetImageInfo(image)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		containerInfo := &types.ContainerInfo{
+			PodContainer: &types.PodContainer{
+				Type:      types.PodContainerTypeEphemeralContainer,
+				Container: &corev1.Container{},
+			},
+			Image: imageInfo,
+			SelectedRules: []*types.Rule{
+				{
+					ReplaceContainerImageHost: replaceContainerImageHost,
+				},
+			},
+		}
+
+		patchOps, err := patch.Create(t.Context(), containerInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// registry is not in mirrors table
+		if len(required) == 0 {
+			if len(patchOps) != 0 {
+				t.Fatalf("no patch must be created for %s", image)
+			}
+
+			continue
+		}
+
+		if len(patchOps) != 1 {
+			t.Fatal("1 patch must be created")
+		}
+
+		if patchOps[0].Path != "/spec/ephemeralContainers/0/image" {
+			t.Fatalf("not corrected path %s", patchOps[0].Path)
+		}
+
+		if patchOps[0].Value != required {
+			t.Fatalf("not corrected value got=%s, required=%s", patchOps[0].Value, required)
+		}
+	}
+}
+
+func TestReplaceImageUnhealthyMirror(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	mirrorHost := strings.TrimPrefix(server.URL, "http://")
+
+	healthCheck := types.MirrorHealthCheck{
+		Enabled:  true,
+		Insecure: true,
+	}
+
+	// register mirror and check it
+	registry.MirrorHealth().IsHealthy(mirrorHost, healthCheck)
+	registry.MirrorHealth().Check(t.Context(), mirrorHost)
+
+	imageInfo, err := api.GetImageInfo("alpine:3.12")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	containerInfo := &types.ContainerInfo{
+		ContainerName: "test",
+		PodContainer: &types.PodContainer{
+			Type:      types.PodContainerTypeContainer,
+			Container: &corev1.Container{},
+		},
+		Image: imageInfo,
+		SelectedRules: []*types.Rule{
+			{
+				ReplaceContainerImageHost: types.ReplaceContainerImageHost{
+					Enabled: true,
+					Mirrors: []types.ImageMirror{
+						{
+							Registry: "docker.io",
+							Mirror:   mirrorHost + "/dockerhub",
+						},
+					},
+					HealthCheck: healthCheck,
+				},
+			},
+		},
+	}
+
+	patch := imagehost.Patch{}
+
+	patchOps, err := patch.Create(t.Context(), containerInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(patchOps) != 0 {
+		t.Fatalf("image must not be rewritten to unhealthy mirror, got %+v", patchOps)
+	}
+
+	if len(containerInfo.Warnings) != 1 {
+		t.Fatal("warning about unhealthy mirror must be added")
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metadata
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/template"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+type Patch struct{}
+
+// add templated labels and annotations to pod, keys are added one by one to keep other pod metadata.
+func (p *Patch) Create(_ context.Context, containerInfo *types.ContainerInfo) ([]types.PatchOperation, error) {
+	if containerInfo.PodContainer == nil || containerInfo.PodContainer.Pod == nil {
+		return []types.PatchOperation{}, nil
+	}
+
+	pod := containerInfo.PodContainer.Pod
+
+	podLabels, err := p.getValues(containerInfo, pod.Labels, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "error in labels")
+	}
+
+	p.addNamespaceLabels(containerInfo, pod.Labels, podLabels)
+
+	podAnnotations, err := p.getValues(containerInfo, pod.Annotations, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "error in annotations")
+	}
+
+	patchOps := types.NewMetadataPatch("/metadata/labels", pod.Labels, podLabels)
+	patchOps = append(patchOps, types.NewMetadataPatch("/metadata/annotations", pod.Annotations, podAnnotations)...)
+
+	return patchOps, nil
+}
+
+// returns templated values that must be added to pod metadata.
+func (p *Patch) getValues(containerInfo *types.ContainerInfo, podValues map[string]string, isLabels bool) (map[string]string, error) { //nolint:lll
+	result := make(map[string]string)
+	// keys of previous rules, including keys that are not added to result
+	handled := make(map[string]bool)
+
+	for _, selectedRule := range containerInfo.SelectedRules {
+		values := selectedRule.Annotations
+		if isLabels {
+			values = selectedRule.Labels
+		}
+
+		for key, value := range values {
+			// value from first rule is used
+			if handled[key] {
+				continue
+			}
+
+			handled[key] = true
+
+			if isLabels {
+				if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+					containerInfo.AddWarning("label key %s is not valid: %s", key, strings.Join(errs, ", "))
+
+					continue
+				}
+			}
+
+			podValue, exists := podValues[key]
+			if exists && selectedRule.MetadataPolicy != types.MetadataPolicyOverwrite {
+				continue
+			}
+
+			formatted, err := template.Get(containerInfo, value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error format %s", key)
+			}
+
+			if exists && formatted == podValue {
+				continue
+			}
+
+			if isLabels {
+				if errs := validation.IsValidLabelValue(formatted); len(errs) > 0 {
+					containerInfo.AddWarning("label %s value %q is not valid: %s", key, formatted, strings.Join(errs, ", "))
+
+					continue
+				}
+			}
+
+			selectedRule.Logf("CreateMetadata: %s=%s", key, formatted)
+
+			result[key] = formatted
+		}
+	}
+
+	return result, nil
+}
+
+// copy namespace labels and annotations to pod labels, pod labels are not overwritten.
+func (p *Patch) addNamespaceLabels(containerInfo *types.ContainerInfo, podLabels, result map[string]string) {
+	for _, selectedRule := range containerInfo.SelectedRules {
+		propagate := selectedRule.PropagateNamespaceMetadata
+
+		if !propagate.Enabled {
+			continue
+		}
+
+		sources := []struct {
+			keys   []types.PropagationKey
+			values map[string]string
+		}{
+			{keys: propagate.Labels, values: containerInfo.NamespaceLabels},
+			{keys: propagate.Annotations, values: containerInfo.NamespaceAnnotations},
+		}
+
+		for _, source := range sources {
+			for _, namespaceKey := range slices.Sorted(maps.Keys(source.values)) {
+				for _, propagationKey := range source.keys {
+					target := propagationKey.GetTarget(namespaceKey)
+					if len(target) == 0 {
+						continue
+					}
+
+					if _, ok := podLabels[target]; ok {
+						break
+					}
+
+					if _, ok := result[target]; ok {
+						break
+					}
+
+					value := source.values[namespaceKey]
+
+					errs := slices.Concat(validation.IsQualifiedName(target), validation.IsValidLabelValue(value))
+					if len(errs) > 0 {
+						containerInfo.AddWarning("namespace %s can not be label %s=%q: %s", namespaceKey, target, value, strings.Join(errs, ", ")) //nolint:lll
+
+						break
+					}
+
+					selectedRule.Logf("CreateMetadata: namespace %s to label %s=%s", namespaceKey, target, value)
+
+					result[target] = value
+
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,204 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metadata_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/metadata"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMetadata(t *testing.T) { //nolint:funlen
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		rule     types.Rule
+		nextRule *types.Rule
+		pod      metav1.ObjectMeta
+		want     []types.PatchOperation
+		warnings int
+	}{
+		{
+			name: "pod without metadata",
+			rule: types.Rule{
+				Labels:      map[string]string{"team.example.com/name": "{{ .Namespace }}"},
+				Annotations: map[string]string{"a~b": "value"},
+			},
+			want: []types.PatchOperation{
+				{Op: "add", Path: "/metadata/labels", Value: map[string]string{}},
+				{Op: "add", Path: "/metadata/labels/team.example.com~1name", Value: "test"},
+				{Op: "add", Path: "/metadata/annotations", Value: map[string]string{}},
+				{Op: "add", Path: "/metadata/annotations/a~0b", Value: "value"},
+			},
+		},
+		{
+			name: "keep pod values",
+			rule: types.Rule{
+				Labels: map[string]string{"app": "new", "env": "dev"},
+			},
+			pod: metav1.ObjectMeta{
+				Labels: map[string]string{"app": "test"},
+			},
+			want: []types.PatchOperation{
+				{Op: "add", Path: "/metadata/labels/env", Value: "dev"},
+			},
+		},
+		{
+			name: "overwrite pod values",
+			rule: types.Rule{
+				Labels:         map[string]string{"app": "new", "env": "dev"},
+				MetadataPolicy: types.MetadataPolicyOverwrite,
+			},
+			pod: metav1.ObjectMeta{
+				Labels: map[string]string{"app": "test", "env": "dev"},
+			},
+			want: []types.PatchOperation{
+				{Op: "add", Path: "/metadata/labels/app", Value: "new"},
+			},
+		},
+		{
+			name: "not valid label value",
+			rule: types.Rule{
+				Labels: map[string]string{"app": "not valid"},
+			},
+			pod: metav1.ObjectMeta{
+				Labels: map[string]string{},
+			},
+			want:     []types.PatchOperation{},
+			warnings: 1,
+		},
+		{
+			name: "not valid label key",
+			rule: types.Rule{
+				Labels: map[string]string{"app/name/test": "test"},
+			},
+			pod: metav1.ObjectMeta{
+				Labels: map[string]string{},
+			},
+			want:     []types.PatchOperation{},
+			warnings: 1,
+		},
+		{
+			name: "pod value kept by first rule",
+			rule: types.Rule{
+				Labels: map[string]string{"app": "first"},
+			},
+			nextRule: &types.Rule{
+				Labels:         map[string]string{"app": "next", "env": "dev"},
+				MetadataPolicy: types.MetadataPolicyOverwrite,
+			},
+			pod: metav1.ObjectMeta{
+				Labels: map[string]string{"app": "test"},
+			},
+			want: []types.PatchOperation{
+				{Op: "add", Path: "/metadata/labels/env", Value: "dev"},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			containerInfo := &types.ContainerInfo{
+				Namespace: "test",
+				PodContainer: &types.PodContainer{
+					Type:      types.PodContainerTypeContainer,
+					Container: &corev1.Container{},
+					Pod:       &corev1.Pod{ObjectMeta: tc.pod},
+				},
+				SelectedRules: []*types.Rule{&tc.rule},
+			}
+
+			if tc.nextRule != nil {
+				containerInfo.SelectedRules = append(containerInfo.SelectedRules, tc.nextRule)
+			}
+
+			patchOps, err := (&metadata.Patch{}).Create(t.Context(), containerInfo)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(patchOps, tc.want) {
+				t.Fatalf("want %+v, got %+v", tc.want, patchOps)
+			}
+
+			if len(containerInfo.Warnings) != tc.warnings {
+				t.Fatalf("want %d warnings, got %v", tc.warnings, containerInfo.Warnings)
+			}
+		})
+	}
+}
+
+func TestPropagateNamespaceMetadata(t *testing.T) {
+	t.Parallel()
+
+	containerInfo := &types.ContainerInfo{
+		NamespaceLabels: map[string]string{
+			"team":                  "payments",
+			"env":                   "prod",
+			"kubernetes.io/name":    "payments-prod",
+			"billing.example.com/x": "not used",
+		},
+		NamespaceAnnotations: map[string]string{
+			"finops.example.com/cost-center": "cc-100",
+			"finops.example.com/owner":       "not valid value",
+		},
+		PodContainer: &types.PodContainer{
+			Type:      types.PodContainerTypeContainer,
+			Container: &corev1.Container{},
+			Pod: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{"env": "dev"},
+				},
+			},
+		},
+		SelectedRules: []*types.Rule{
+			{
+				PropagateNamespaceMetadata: types.PropagateNamespaceMetadata{
+					Enabled: true,
+					Labels: []types.PropagationKey{
+						{Key: "team"},
+						{Key: "env"},
+					},
+					Annotations: []types.PropagationKey{
+						{Regexp: `^finops\.example\.com/(.+)$`, Rename: "$1"},
+					},
+				},
+			},
+		},
+	}
+
+	patchOps, err := (&metadata.Patch{}).Create(t.Context(), containerInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []types.PatchOperation{
+		{Op: "add", Path: "/metadata/labels/cost-center", Value: "cc-100"},
+		{Op: "add", Path: "/metadata/labels/team", Value: "payments"},
+	}
+
+	if !reflect.DeepEqual(patchOps, want) {
+		t.Fatalf("want %+v, got %+v", want, patchOps)
+	}
+
+	if len(containerInfo.Warnings) != 1 {
+		t.Fatalf("not valid label value must be skipped with warning, got %v", containerInfo.Warnings)
+	}
+}
@@ -0,0 +1,64 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package nodeselector
+
+import (
+	"context"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+type Patch struct{}
+
+// add namespace node selector to pod node selector, conflicting pod keys are kept.
+func (p *Patch) Create(_ context.Context, containerInfo *types.ContainerInfo) ([]types.PatchOperation, error) {
+	if containerInfo.PodContainer == nil || containerInfo.PodContainer.Pod == nil || !containerInfo.NamespaceSchedulingEnabled() { //nolint:lll
+		return []types.PatchOperation{}, nil
+	}
+
+	namespaceSelector, err := GetNamespaceNodeSelector(containerInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	podSelector := containerInfo.PodContainer.Pod.Spec.NodeSelector
+
+	result := make(map[string]string)
+
+	for key, value := range namespaceSelector {
+		if _, ok := podSelector[key]; !ok {
+			result[key] = value
+		}
+	}
+
+	// keys are added one by one, so other patches of node selector are kept
+	return types.NewMetadataPatch("/spec/nodeSelector", podSelector, result), nil
+}
+
+// returns node selector from namespace annotation, pod annotation is not used
+// because pod can not change namespace restrictions.
+func GetNamespaceNodeSelector(containerInfo *types.ContainerInfo) (map[string]string, error) {
+	value, ok := containerInfo.NamespaceAnnotations[types.AnnotationNodeSelector]
+	if !ok {
+		return nil, nil
+	}
+
+	selector, err := labels.ConvertSelectorToLabelsMap(value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing node selector %s", value)
+	}
+
+	return selector, nil
+}
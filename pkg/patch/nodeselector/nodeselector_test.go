@@ -0,0 +1,75 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package nodeselector_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/nodeselector"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestNodeSelector(t *testing.T) {
+	t.Parallel()
+
+	containerInfo := &types.ContainerInfo{
+		NamespaceAnnotations: map[string]string{
+			types.AnnotationNodeSelector: "pool=team-a,disk=ssd",
+		},
+		PodAnnotations: map[string]string{
+			// pod can not change namespace node selector
+			types.AnnotationNodeSelector: "pool=team-b",
+		},
+		PodContainer: &types.PodContainer{
+			Pod: &corev1.Pod{
+				Spec: corev1.PodSpec{NodeSelector: map[string]string{"disk": "hdd"}},
+			},
+		},
+		SelectedRules: []*types.Rule{
+			{NamespaceScheduling: types.NamespaceScheduling{Enabled: true}},
+		},
+	}
+
+	patchOps, err := (&nodeselector.Patch{}).Create(t.Context(), containerInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []types.PatchOperation{
+		{Op: "add", Path: "/spec/nodeSelector/pool", Value: "team-a"},
+	}
+
+	if !reflect.DeepEqual(patchOps, want) {
+		t.Fatalf("want %+v, got %+v", want, patchOps)
+	}
+
+	// pod without node selector
+	containerInfo.PodContainer.Pod.Spec.NodeSelector = nil
+
+	patchOps, err = (&nodeselector.Patch{}).Create(t.Context(), containerInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want = []types.PatchOperation{
+		{Op: "add", Path: "/spec/nodeSelector", Value: map[string]string{}},
+		{Op: "add", Path: "/spec/nodeSelector/disk", Value: "ssd"},
+		{Op: "add", Path: "/spec/nodeSelector/pool", Value: "team-a"},
+	}
+
+	if !reflect.DeepEqual(patchOps, want) {
+		t.Fatalf("want %+v, got %+v", want, patchOps)
+	}
+}
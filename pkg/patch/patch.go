@@ -15,16 +15,29 @@ package patch
 import (
 	"context"
 	"reflect"
+	"slices"
 	"strings"
 
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/affinity"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/archaffinity"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/custompatch"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/dnsconfig"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/env"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/imagedigest"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/imagehost"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/metadata"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/nodeselector"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/nonroot"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/podclasses"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/probes"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/pullsecrets"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/resources"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/runtimeenv"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/schedulingprofile"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/tolerations"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/topologyspread"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/trustbundle"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/volumezone"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
 	"github.com/pkg/errors"
 )
@@ -38,11 +51,30 @@ var allPatchs = []Patch{
 	&env.Patch{},
 	&nonroot.Patch{},
 	&resources.Patch{},
+	&probes.Patch{},
 	&imagehost.Patch{},
+	&imagedigest.Patch{},
 	&tolerations.Patch{},
+	&nodeselector.Patch{},
+	&dnsconfig.Patch{},
 	&pullsecrets.Patch{},
+	&metadata.Patch{},
+	&podclasses.Patch{},
 	&custompatch.Patch{},
 	&topologyspread.Patch{},
+	&affinity.Patch{},
+	&schedulingprofile.Patch{},
+	&volumezone.Patch{},
+	&archaffinity.Patch{},
+	&runtimeenv.Patch{},
+	&trustbundle.Patch{},
+}
+
+// ephemeral containers do not support resources, probes and ports,
+// only these patches can be applied to them.
+var ephemeralContainerPatchs = []string{
+	"imagehost",
+	"imagedigest",
 }
 
 func NewPatch(ctx context.Context, containerInfo *types.ContainerInfo) ([]types.PatchOperation, error) {
@@ -53,6 +85,10 @@ func NewPatch(ctx context.Context, containerInfo *types.ContainerInfo) ([]types.
 			continue
 		}
 
+		if containerInfo.ContainerType == types.PodContainerTypeEphemeralContainer && !slices.Contains(ephemeralContainerPatchs, getPatchName(patch)) { //nolint:lll
+			continue
+		}
+
 		patchOps, err := patch.Create(ctx, containerInfo)
 		if err != nil {
 			return nil, errors.Wrapf(err, "error in %s", getPatchName(patch))
@@ -73,26 +109,8 @@ func getPatchName(patch Patch) string {
 	return patchName
 }
 
-const annotationIgnorePrefix = "pod-admission-controller/ignore-"
-
 // ignore patch if annotation exists:
 // pod-admission-controller/ignore-<patch-name>=<container-name>[,<container-name>].
 func IgnoreContainerPatch(patch Patch, containerInfo *types.ContainerInfo) bool {
-	ignore, ok := containerInfo.GetPodAnnotation(annotationIgnorePrefix + getPatchName(patch))
-	if !ok {
-		return false
-	}
-
-	if ignore == "*" {
-		return true
-	}
-
-	containersNames := strings.Split(ignore, ",")
-	for _, containerName := range containersNames {
-		if containerName == containerInfo.ContainerName {
-			return true
-		}
-	}
-
-	return false
+	return containerInfo.IgnorePatch(getPatchName(patch))
 }
@@ -0,0 +1,249 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package podclasses
+
+import (
+	"context"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/client"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/template"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	nodev1 "k8s.io/api/node/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+type Patch struct{}
+
+// set priorityClassName, runtimeClassName and schedulerName of pod.
+func (p *Patch) Create(_ context.Context, containerInfo *types.ContainerInfo) ([]types.PatchOperation, error) {
+	patchOps := make([]types.PatchOperation, 0)
+
+	if containerInfo.PodContainer == nil || containerInfo.PodContainer.Pod == nil {
+		return patchOps, nil
+	}
+
+	for _, selectedRule := range containerInfo.SelectedRules {
+		if !selectedRule.PodClasses.Enabled {
+			continue
+		}
+
+		selectedRule.Logf("CreatePodClasses: %+v", selectedRule.PodClasses)
+
+		priorityOps, err := p.getPriorityClassPatch(containerInfo, selectedRule.PodClasses)
+		if err != nil {
+			return nil, errors.Wrap(err, "error in priorityClassName")
+		}
+
+		runtimeOps, err := p.getRuntimeClassPatch(containerInfo, selectedRule.PodClasses)
+		if err != nil {
+			return nil, errors.Wrap(err, "error in runtimeClassName")
+		}
+
+		schedulerOps, err := p.getSchedulerNamePatch(containerInfo, selectedRule.PodClasses)
+		if err != nil {
+			return nil, errors.Wrap(err, "error in schedulerName")
+		}
+
+		patchOps = append(patchOps, priorityOps...)
+		patchOps = append(patchOps, runtimeOps...)
+		patchOps = append(patchOps, schedulerOps...)
+
+		// only one rule can be applied
+		break
+	}
+
+	return patchOps, nil
+}
+
+func (p *Patch) getPriorityClassPatch(containerInfo *types.ContainerInfo, podClasses types.PodClasses) ([]types.PatchOperation, error) { //nolint:lll
+	pod := containerInfo.PodContainer.Pod
+
+	podValue := pod.Spec.PriorityClassName
+
+	// global default class is set by apiserver if pod has no priorityClassName
+	if isGlobalDefaultPriorityClass(podValue) {
+		podValue = ""
+	}
+
+	name, err := getValue(containerInfo, podClasses, podClasses.PriorityClassName, podValue)
+	if err != nil || len(name) == 0 {
+		return nil, err
+	}
+
+	priorityClass, err := getPriorityClass(name)
+	if apierrors.IsNotFound(err) {
+		containerInfo.AddWarning("priority class %s is not found, pod priorityClassName is not changed", name)
+
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	patchOps := []types.PatchOperation{
+		{
+			Op:    "add",
+			Path:  "/spec/priorityClassName",
+			Value: name,
+		},
+	}
+
+	// priority is resolved by apiserver before webhook, it must match new priority class
+	if priorityClass != nil {
+		patchOps = append(patchOps, types.PatchOperation{
+			Op:    "add",
+			Path:  "/spec/priority",
+			Value: priorityClass.Value,
+		})
+
+		switch {
+		case priorityClass.PreemptionPolicy != nil:
+			patchOps = append(patchOps, types.PatchOperation{
+				Op:    "add",
+				Path:  "/spec/preemptionPolicy",
+				Value: *priorityClass.PreemptionPolicy,
+			})
+		// preemption policy of previous class is removed
+		case pod.Spec.PreemptionPolicy != nil:
+			patchOps = append(patchOps, types.PatchOperation{
+				Op:   "remove",
+				Path: "/spec/preemptionPolicy",
+			})
+		}
+	}
+
+	return patchOps, nil
+}
+
+func (p *Patch) getRuntimeClassPatch(containerInfo *types.ContainerInfo, podClasses types.PodClasses) ([]types.PatchOperation, error) { //nolint:lll
+	pod := containerInfo.PodContainer.Pod
+
+	podValue := ""
+	if pod.Spec.RuntimeClassName != nil {
+		podValue = *pod.Spec.RuntimeClassName
+	}
+
+	name, err := getValue(containerInfo, podClasses, podClasses.RuntimeClassName, podValue)
+	if err != nil || len(name) == 0 {
+		return nil, err
+	}
+
+	runtimeClass, err := getRuntimeClass(name)
+	if apierrors.IsNotFound(err) {
+		containerInfo.AddWarning("runtime class %s is not found, pod runtimeClassName is not changed", name)
+
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	patchOps := []types.PatchOperation{
+		{
+			Op:    "add",
+			Path:  "/spec/runtimeClassName",
+			Value: name,
+		},
+	}
+
+	// overhead is set by apiserver before webhook, it must match new runtime class
+	if runtimeClass != nil && runtimeClass.Overhead != nil {
+		patchOps = append(patchOps, types.PatchOperation{
+			Op:    "add",
+			Path:  "/spec/overhead",
+			Value: runtimeClass.Overhead.PodFixed,
+		})
+	}
+
+	return patchOps, nil
+}
+
+func (p *Patch) getSchedulerNamePatch(containerInfo *types.ContainerInfo, podClasses types.PodClasses) ([]types.PatchOperation, error) { //nolint:lll
+	podValue := containerInfo.PodContainer.Pod.Spec.SchedulerName
+
+	// default scheduler is set by apiserver if pod has no schedulerName
+	if podValue == corev1.DefaultSchedulerName {
+		podValue = ""
+	}
+
+	name, err := getValue(containerInfo, podClasses, podClasses.SchedulerName, podValue)
+	if err != nil || len(name) == 0 {
+		return nil, err
+	}
+
+	return []types.PatchOperation{
+		{
+			Op:    "add",
+			Path:  "/spec/schedulerName",
+			Value: name,
+		},
+	}, nil
+}
+
+// returns templated value, empty if pod value is kept.
+func getValue(containerInfo *types.ContainerInfo, podClasses types.PodClasses, value, podValue string) (string, error) {
+	if len(value) == 0 {
+		return "", nil
+	}
+
+	if len(podValue) > 0 && podClasses.Policy != types.PodClassesPolicyOverride {
+		return "", nil
+	}
+
+	formatted, err := template.Get(containerInfo, value)
+	if err != nil {
+		return "", errors.Wrap(err, "template.Get")
+	}
+
+	if formatted == podValue {
+		return "", nil
+	}
+
+	return formatted, nil
+}
+
+func isGlobalDefaultPriorityClass(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+
+	priorityClass, err := getPriorityClass(name)
+	if err != nil || priorityClass == nil {
+		return false
+	}
+
+	return priorityClass.GlobalDefault
+}
+
+// returns nil if informers are not started.
+func getPriorityClass(name string) (*schedulingv1.PriorityClass, error) {
+	if client.Informers() == nil {
+		return nil, nil //nolint:nilnil
+	}
+
+	return client.Informers().Scheduling().V1().PriorityClasses().Lister().Get(name) //nolint:wrapcheck
+}
+
+// returns nil if informers are not started.
+func getRuntimeClass(name string) (*nodev1.RuntimeClass, error) {
+	if client.Informers() == nil {
+		return nil, nil //nolint:nilnil
+	}
+
+	return client.Informers().Node().V1().RuntimeClasses().Lister().Get(name) //nolint:wrapcheck
+}
@@ -0,0 +1,186 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package podclasses_test
+
+import (
+	"testing"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/client"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/podclasses"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	nodev1 "k8s.io/api/node/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// test uses global informers.
+func TestPodClasses(t *testing.T) { //nolint:funlen,paralleltest
+	preemptionPolicy := corev1.PreemptNever
+
+	clientset := fake.NewClientset(
+		&schedulingv1.PriorityClass{
+			ObjectMeta:       metav1.ObjectMeta{Name: "tier-high"},
+			Value:            1000,
+			PreemptionPolicy: &preemptionPolicy,
+		},
+		&schedulingv1.PriorityClass{
+			ObjectMeta:    metav1.ObjectMeta{Name: "default"},
+			Value:         100,
+			GlobalDefault: true,
+		},
+		&nodev1.RuntimeClass{
+			ObjectMeta: metav1.ObjectMeta{Name: "gvisor"},
+			Handler:    "runsc",
+			Overhead: &nodev1.Overhead{
+				PodFixed: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")},
+			},
+		},
+	)
+
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	factory.Scheduling().V1().PriorityClasses().Informer()
+	factory.Node().V1().RuntimeClasses().Informer()
+	factory.Start(t.Context().Done())
+	factory.WaitForCacheSync(t.Context().Done())
+
+	client.SetInformers(factory)
+	t.Cleanup(func() { client.SetInformers(nil) })
+
+	tests := []struct {
+		name       string
+		podClasses types.PodClasses
+		podSpec    corev1.PodSpec
+		wantPaths  []string
+		wantOps    []string
+		warnings   int
+	}{
+		{
+			name: "set all values",
+			podClasses: types.PodClasses{
+				Enabled:           true,
+				PriorityClassName: "tier-{{ index .NamespaceLabels `tier` }}",
+				RuntimeClassName:  "gvisor",
+				SchedulerName:     "custom-scheduler",
+			},
+			podSpec: corev1.PodSpec{SchedulerName: corev1.DefaultSchedulerName},
+			wantPaths: []string{
+				"/spec/priorityClassName",
+				"/spec/priority",
+				"/spec/preemptionPolicy",
+				"/spec/runtimeClassName",
+				"/spec/overhead",
+				"/spec/schedulerName",
+			},
+		},
+		{
+			name: "keep pod values",
+			podClasses: types.PodClasses{
+				Enabled:           true,
+				PriorityClassName: "tier-high",
+				RuntimeClassName:  "gvisor",
+			},
+			podSpec: corev1.PodSpec{
+				PriorityClassName: "system-node-critical",
+				RuntimeClassName:  utils.Pnt("kata"),
+			},
+		},
+		{
+			name: "override pod values",
+			podClasses: types.PodClasses{
+				Enabled:           true,
+				PriorityClassName: "tier-high",
+				Policy:            types.PodClassesPolicyOverride,
+			},
+			podSpec: corev1.PodSpec{
+				PriorityClassName: "system-node-critical",
+			},
+			wantPaths: []string{"/spec/priorityClassName", "/spec/priority", "/spec/preemptionPolicy"},
+		},
+		{
+			name: "global default class is replaced",
+			podClasses: types.PodClasses{
+				Enabled:           true,
+				PriorityClassName: "tier-high",
+			},
+			podSpec: corev1.PodSpec{
+				PriorityClassName: "default",
+			},
+			wantPaths: []string{"/spec/priorityClassName", "/spec/priority", "/spec/preemptionPolicy"},
+		},
+		{
+			name: "preemption policy of previous class is removed",
+			podClasses: types.PodClasses{
+				Enabled:           true,
+				PriorityClassName: "default",
+				Policy:            types.PodClassesPolicyOverride,
+			},
+			podSpec: corev1.PodSpec{
+				PriorityClassName: "tier-high",
+				PreemptionPolicy:  &preemptionPolicy,
+			},
+			wantPaths: []string{"/spec/priorityClassName", "/spec/priority", "/spec/preemptionPolicy"},
+			wantOps:   []string{"add", "add", "remove"},
+		},
+		{
+			name: "not found classes",
+			podClasses: types.PodClasses{
+				Enabled:           true,
+				PriorityClassName: "unknown",
+				RuntimeClassName:  "unknown",
+			},
+			warnings: 2,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			containerInfo := &types.ContainerInfo{
+				NamespaceLabels: map[string]string{"tier": "high"},
+				PodContainer: &types.PodContainer{
+					Type:      "container",
+					Container: &corev1.Container{},
+					Pod:       &corev1.Pod{Spec: tc.podSpec},
+				},
+				SelectedRules: []*types.Rule{{PodClasses: tc.podClasses}},
+			}
+
+			patchOps, err := (&podclasses.Patch{}).Create(t.Context(), containerInfo)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(patchOps) != len(tc.wantPaths) {
+				t.Fatalf("want %v, got %+v", tc.wantPaths, patchOps)
+			}
+
+			for i, patchOp := range patchOps {
+				if patchOp.Path != tc.wantPaths[i] {
+					t.Fatalf("want %s, got %s", tc.wantPaths[i], patchOp.Path)
+				}
+
+				if len(tc.wantOps) > 0 && patchOp.Op != tc.wantOps[i] {
+					t.Fatalf("want %s %s, got %s", tc.wantOps[i], tc.wantPaths[i], patchOp.Op)
+				}
+			}
+
+			if len(containerInfo.Warnings) != tc.warnings {
+				t.Fatalf("want %d warnings, got %v", tc.warnings, containerInfo.Warnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package probes
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/template"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// default pod terminationGracePeriodSeconds.
+const defaultTerminationGracePeriod = 30
+
+const terminationGracePeriodPath = "/spec/terminationGracePeriodSeconds"
+
+var sleepRegexp = regexp.MustCompile(`\bsleep\s+(\d+)`)
+
+type Patch struct{}
+
+// add default probes and preStop hook to containers that do not have them.
+func (p *Patch) Create(_ context.Context, containerInfo *types.ContainerInfo) ([]types.PatchOperation, error) {
+	patchOps := make([]types.PatchOperation, 0)
+
+	// init containers and ephemeral containers do not support probes
+	if containerInfo.ContainerType != types.PodContainerTypeContainer {
+		return patchOps, nil
+	}
+
+	for _, selectedRule := range containerInfo.SelectedRules {
+		if !selectedRule.DefaultProbes.Enabled {
+			continue
+		}
+
+		selectedRule.Logf("CreateDefaultProbes: %+v", selectedRule.DefaultProbes)
+
+		defaultProbes, err := p.FormatDefaultProbes(containerInfo, selectedRule.DefaultProbes)
+		if err != nil {
+			return nil, errors.Wrap(err, "error format default probes")
+		}
+
+		container := containerInfo.PodContainer.Container
+		containerPath := containerInfo.PodContainer.ContainerPath()
+
+		probes := []struct {
+			name         string
+			podProbe     *corev1.Probe
+			defaultProbe *corev1.Probe
+		}{
+			{name: "readinessProbe", podProbe: container.ReadinessProbe, defaultProbe: defaultProbes.ReadinessProbe},
+			{name: "livenessProbe", podProbe: container.LivenessProbe, defaultProbe: defaultProbes.LivenessProbe},
+			{name: "startupProbe", podProbe: container.StartupProbe, defaultProbe: defaultProbes.StartupProbe},
+		}
+
+		for _, probe := range probes {
+			if probe.podProbe != nil || !isValidProbe(container, probe.defaultProbe) {
+				continue
+			}
+
+			patchOps = append(patchOps, types.PatchOperation{
+				Op:    "add",
+				Path:  containerPath + "/" + probe.name,
+				Value: probe.defaultProbe,
+			})
+		}
+
+		preStop := getPreStop(container)
+
+		if preStop == nil && defaultProbes.PreStop != nil {
+			preStop = defaultProbes.PreStop
+
+			if container.Lifecycle == nil {
+				patchOps = append(patchOps, types.PatchOperation{
+					Op:    "add",
+					Path:  containerPath + "/lifecycle",
+					Value: corev1.Lifecycle{PreStop: preStop},
+				})
+			} else {
+				patchOps = append(patchOps, types.PatchOperation{
+					Op:    "add",
+					Path:  containerPath + "/lifecycle/preStop",
+					Value: preStop,
+				})
+			}
+		}
+
+		if selectedRule.DefaultProbes.AdjustTerminationGracePeriod {
+			if gracePeriod, ok := p.getTerminationGracePeriod(containerInfo.PodContainer.Pod, preStop, selectedRule.DefaultProbes); ok { //nolint:lll
+				patchOps = append(patchOps, types.PatchOperation{
+					Op:    "add",
+					Path:  terminationGracePeriodPath,
+					Value: gracePeriod,
+				})
+			}
+		}
+
+		// only one rule can be applied
+		break
+	}
+
+	return patchOps, nil
+}
+
+// grace period is created for every container with preStop of container rules,
+// returns patch with one operation that has maximum grace period of all containers.
+func MergeTerminationGracePeriod(patchOps []types.PatchOperation) []types.PatchOperation {
+	result := make([]types.PatchOperation, 0, len(patchOps))
+	gracePeriodID := -1
+
+	for _, patchOp := range patchOps {
+		gracePeriod, ok := patchOp.Value.(int64)
+		if patchOp.Path != terminationGracePeriodPath || !ok {
+			result = append(result, patchOp)
+
+			continue
+		}
+
+		if gracePeriodID < 0 {
+			gracePeriodID = len(result)
+			result = append(result, patchOp)
+
+			continue
+		}
+
+		if value, _ := result[gracePeriodID].Value.(int64); gracePeriod > value {
+			result[gracePeriodID].Value = gracePeriod
+		}
+	}
+
+	return result
+}
+
+// returns templated probes and preStop hook.
+func (p *Patch) FormatDefaultProbes(containerInfo *types.ContainerInfo, defaultProbes types.DefaultProbes) (*types.DefaultProbes, error) { //nolint:lll
+	defaultProbesJSON, err := json.Marshal(defaultProbes)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshal default probes")
+	}
+
+	defaultProbesFormatted, err := template.Get(containerInfo, string(defaultProbesJSON))
+	if err != nil {
+		return nil, errors.Wrap(err, "template.Get")
+	}
+
+	result := types.DefaultProbes{}
+
+	if err := json.Unmarshal([]byte(defaultProbesFormatted), &result); err != nil {
+		return nil, errors.Wrap(err, "error unmarshal default probes")
+	}
+
+	for _, probe := range []*corev1.Probe{result.ReadinessProbe, result.LivenessProbe, result.StartupProbe} {
+		if probe == nil {
+			continue
+		}
+
+		if probe.HTTPGet != nil {
+			probe.HTTPGet.Port = formatPort(probe.HTTPGet.Port)
+		}
+
+		if probe.TCPSocket != nil {
+			probe.TCPSocket.Port = formatPort(probe.TCPSocket.Port)
+		}
+	}
+
+	return &result, nil
+}
+
+// returns terminationGracePeriodSeconds that is more than preStop duration of all pod containers.
+func (p *Patch) getTerminationGracePeriod(pod *corev1.Pod, preStop *corev1.LifecycleHandler, defaultProbes types.DefaultProbes) (int64, bool) { //nolint:lll
+	if pod == nil {
+		return 0, false
+	}
+
+	duration := GetPreStopDuration(preStop)
+
+	for i := range pod.Spec.Containers {
+		duration = max(duration, GetPreStopDuration(getPreStop(&pod.Spec.Containers[i])))
+	}
+
+	if duration == 0 {
+		return 0, false
+	}
+
+	podGracePeriod := int64(defaultTerminationGracePeriod)
+	if pod.Spec.TerminationGracePeriodSeconds != nil {
+		podGracePeriod = *pod.Spec.TerminationGracePeriodSeconds
+	}
+
+	gracePeriod := duration + defaultProbes.GetTerminationGracePeriodMargin()
+
+	if gracePeriod <= podGracePeriod {
+		return 0, false
+	}
+
+	return gracePeriod, true
+}
+
+// returns seconds of sleep in preStop hook, 0 if hook has no sleep.
+func GetPreStopDuration(preStop *corev1.LifecycleHandler) int64 {
+	if preStop == nil {
+		return 0
+	}
+
+	if preStop.Sleep != nil {
+		return preStop.Sleep.Seconds
+	}
+
+	if preStop.Exec != nil {
+		match := sleepRegexp.FindStringSubmatch(strings.Join(preStop.Exec.Command, " "))
+		if len(match) == 2 { //nolint:mnd
+			seconds, err := strconv.ParseInt(match[1], 10, 64)
+			if err == nil {
+				return seconds
+			}
+		}
+	}
+
+	return 0
+}
+
+func getPreStop(container *corev1.Container) *corev1.LifecycleHandler {
+	if container.Lifecycle == nil {
+		return nil
+	}
+
+	return container.Lifecycle.PreStop
+}
+
+// templated port is string, numeric string must be int, otherwise it is port name.
+func formatPort(port intstr.IntOrString) intstr.IntOrString {
+	if port.Type != intstr.String {
+		return port
+	}
+
+	value, err := strconv.ParseInt(port.StrVal, 10, 32)
+	if err != nil {
+		return port
+	}
+
+	return intstr.FromInt32(int32(value)) //nolint:gosec
+}
+
+// probe with port that container does not have is not valid.
+func isValidProbe(container *corev1.Container, probe *corev1.Probe) bool {
+	if probe == nil {
+		return false
+	}
+
+	port := getProbePort(probe)
+
+	if port == nil {
+		return true
+	}
+
+	if port.Type == intstr.Int {
+		return port.IntVal > 0
+	}
+
+	return slices.ContainsFunc(container.Ports, func(containerPort corev1.ContainerPort) bool {
+		return containerPort.Name == port.StrVal
+	})
+}
+
+func getProbePort(probe *corev1.Probe) *intstr.IntOrString {
+	if probe.HTTPGet != nil {
+		return &probe.HTTPGet.Port
+	}
+
+	if probe.TCPSocket != nil {
+		return &probe.TCPSocket.Port
+	}
+
+	return nil
+}
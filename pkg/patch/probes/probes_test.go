@@ -0,0 +1,187 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package probes_test
+
+import (
+	"testing"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/probes"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+var testDefaultProbes = types.DefaultProbes{
+	Enabled: true,
+	ReadinessProbe: &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{
+				Path: "/ready",
+				Port: intstr.FromString("{{ .PodContainer.ContainerPort `http` }}"),
+			},
+		},
+	},
+	LivenessProbe: &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			TCPSocket: &corev1.TCPSocketAction{
+				Port: intstr.FromString("http"),
+			},
+		},
+	},
+	PreStop: &corev1.LifecycleHandler{
+		Sleep: &corev1.SleepAction{Seconds: 40},
+	},
+	AdjustTerminationGracePeriod: true,
+}
+
+func TestDefaultProbes(t *testing.T) { //nolint:funlen
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		container corev1.Container
+		wantPaths []string
+	}{
+		{
+			name: "container without probes",
+			container: corev1.Container{
+				Ports: []corev1.ContainerPort{{Name: "metrics", ContainerPort: 9090}, {Name: "http", ContainerPort: 8080}},
+			},
+			wantPaths: []string{
+				"/spec/containers/0/readinessProbe",
+				"/spec/containers/0/livenessProbe",
+				"/spec/containers/0/lifecycle",
+				"/spec/terminationGracePeriodSeconds",
+			},
+		},
+		{
+			name: "container without named port",
+			container: corev1.Container{
+				Ports: []corev1.ContainerPort{{Name: "metrics", ContainerPort: 9090}},
+			},
+			wantPaths: []string{
+				"/spec/containers/0/lifecycle",
+				"/spec/terminationGracePeriodSeconds",
+			},
+		},
+		{
+			name: "container without ports",
+			container: corev1.Container{
+				Lifecycle: &corev1.Lifecycle{PostStart: &corev1.LifecycleHandler{}},
+			},
+			wantPaths: []string{
+				"/spec/containers/0/lifecycle/preStop",
+				"/spec/terminationGracePeriodSeconds",
+			},
+		},
+		{
+			name: "container with probes and short preStop",
+			container: corev1.Container{
+				ReadinessProbe: &corev1.Probe{},
+				LivenessProbe:  &corev1.Probe{},
+				Lifecycle: &corev1.Lifecycle{
+					PreStop: &corev1.LifecycleHandler{
+						Exec: &corev1.ExecAction{Command: []string{"sh", "-c", "sleep 5"}},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			containerInfo := &types.ContainerInfo{
+				ContainerType: types.PodContainerTypeContainer,
+				PodContainer: &types.PodContainer{
+					Type:      types.PodContainerTypeContainer,
+					Container: &tc.container,
+					Pod: &corev1.Pod{
+						Spec: corev1.PodSpec{
+							Containers:                    []corev1.Container{tc.container},
+							TerminationGracePeriodSeconds: utils.Pnt(int64(30)),
+						},
+					},
+				},
+				SelectedRules: []*types.Rule{{DefaultProbes: testDefaultProbes}},
+			}
+
+			patchOps, err := (&probes.Patch{}).Create(t.Context(), containerInfo)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(patchOps) != len(tc.wantPaths) {
+				t.Fatalf("want %v, got %+v", tc.wantPaths, patchOps)
+			}
+
+			for i, patchOp := range patchOps {
+				if patchOp.Path != tc.wantPaths[i] {
+					t.Fatalf("want %s, got %s", tc.wantPaths[i], patchOp.Path)
+				}
+
+				if probe, ok := patchOp.Value.(*corev1.Probe); ok && probe.HTTPGet != nil {
+					if probe.HTTPGet.Port != intstr.FromInt32(8080) {
+						t.Fatalf("templated port must be int, got %+v", probe.HTTPGet.Port)
+					}
+				}
+
+				if patchOp.Path == "/spec/terminationGracePeriodSeconds" && patchOp.Value != int64(45) {
+					t.Fatalf("grace period must be more than preStop, got %v", patchOp.Value)
+				}
+			}
+		})
+	}
+}
+
+func TestMergeTerminationGracePeriod(t *testing.T) {
+	t.Parallel()
+
+	patchOps := probes.MergeTerminationGracePeriod([]types.PatchOperation{
+		{Op: "add", Path: "/spec/terminationGracePeriodSeconds", Value: int64(45)},
+		{Op: "add", Path: "/spec/containers/1/lifecycle", Value: corev1.Lifecycle{}},
+		{Op: "add", Path: "/spec/terminationGracePeriodSeconds", Value: int64(60)},
+		{Op: "add", Path: "/spec/terminationGracePeriodSeconds", Value: int64(15)},
+	})
+
+	if len(patchOps) != 2 {
+		t.Fatalf("one grace period must be in patch, got %+v", patchOps)
+	}
+
+	if patchOps[0].Value != int64(60) {
+		t.Fatalf("grace period must be maximum of containers, got %v", patchOps[0].Value)
+	}
+}
+
+func TestGetPreStopDuration(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		preStop *corev1.LifecycleHandler
+		want    int64
+	}{
+		{preStop: nil, want: 0},
+		{preStop: &corev1.LifecycleHandler{Sleep: &corev1.SleepAction{Seconds: 15}}, want: 15},
+		{preStop: &corev1.LifecycleHandler{Exec: &corev1.ExecAction{Command: []string{"/bin/sleep", "20"}}}, want: 20},
+		{preStop: &corev1.LifecycleHandler{Exec: &corev1.ExecAction{Command: []string{"sh", "-c", "sleep 10 && nginx -s quit"}}}, want: 10}, //nolint:lll
+		{preStop: &corev1.LifecycleHandler{Exec: &corev1.ExecAction{Command: []string{"/bin/stop"}}}, want: 0},
+	}
+
+	for _, tc := range tests {
+		if got := probes.GetPreStopDuration(tc.preStop); got != tc.want {
+			t.Fatalf("want %d, got %d", tc.want, got)
+		}
+	}
+}
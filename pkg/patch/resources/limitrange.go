@@ -0,0 +1,134 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package resources
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/client"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const limitRangeSourcePrefix = "limitrange/"
+
+// return LimitRanges in namespace sorted by name.
+func getLimitRanges(namespace string) []*corev1.LimitRange {
+	if len(namespace) == 0 || client.Informers() == nil {
+		return nil
+	}
+
+	limitRanges, err := client.Informers().Core().V1().LimitRanges().Lister().LimitRanges(namespace).List(labels.Everything()) //nolint:lll
+	if err != nil {
+		log.WithError(err).Errorf("error listing LimitRanges in namespace %s", namespace)
+
+		return nil
+	}
+
+	slices.SortFunc(limitRanges, func(a, b *corev1.LimitRange) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return limitRanges
+}
+
+// return LimitRange items for containers.
+func getContainerLimits(limitRange *corev1.LimitRange) []corev1.LimitRangeItem {
+	result := make([]corev1.LimitRangeItem, 0)
+
+	for _, item := range limitRange.Spec.Limits {
+		if item.Type == corev1.LimitTypeContainer {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
+
+// return default limit from first LimitRange in namespace, source is empty if no default limit found.
+func getLimitRangeDefaultLimit(namespace string, resourceName corev1.ResourceName) (resource.Quantity, string) {
+	for _, limitRange := range getLimitRanges(namespace) {
+		for _, item := range getContainerLimits(limitRange) {
+			if limit, ok := item.Default[resourceName]; ok {
+				return limit, limitRangeSourcePrefix + limitRange.Name
+			}
+		}
+	}
+
+	return resource.Quantity{}, ""
+}
+
+// keep generated values in LimitRange min, max and maxLimitRequestRatio,
+// values from container spec are not changed.
+func (p *Patch) LimitRangeBounds(containerInfo *types.ContainerInfo, rule *types.Rule, newResources *corev1.ResourceRequirements) { //nolint:lll,cyclop
+	containerResources := containerInfo.PodContainer.Container.Resources
+
+	bound := func(kind string, values, containerValues corev1.ResourceList, resourceName corev1.ResourceName, bound resource.Quantity, isMin bool, source string) { //nolint:lll
+		value, ok := values[resourceName]
+		if !ok {
+			return
+		}
+
+		if _, fromContainer := containerValues[resourceName]; fromContainer {
+			return
+		}
+
+		if (isMin && value.Cmp(bound) >= 0) || (!isMin && value.Cmp(bound) <= 0) {
+			return
+		}
+
+		// request can not be greater than limit from container spec
+		if limit, ok := containerResources.Limits[resourceName]; ok && kind == "request" && bound.Cmp(limit) > 0 {
+			containerInfo.AddWarning("container %s %s request is not changed to LimitRange %s %s, it is greater than container limit %s", //nolint:lll
+				containerInfo.ContainerName, resourceName, source, bound.String(), limit.String(),
+			)
+
+			return
+		}
+
+		rule.Logf("LimitRangeBounds: %s %s=%s source=%s", resourceName, kind, bound.String(), source)
+
+		values[resourceName] = bound
+	}
+
+	for _, limitRange := range getLimitRanges(containerInfo.Namespace) {
+		source := limitRangeSourcePrefix + limitRange.Name
+
+		for _, item := range getContainerLimits(limitRange) {
+			for resourceName, quantity := range item.Min {
+				bound("request", newResources.Requests, containerResources.Requests, resourceName, quantity, true, source+"/min")
+				bound("limit", newResources.Limits, containerResources.Limits, resourceName, quantity, true, source+"/min")
+			}
+
+			for resourceName, quantity := range item.Max {
+				bound("request", newResources.Requests, containerResources.Requests, resourceName, quantity, false, source+"/max")
+				bound("limit", newResources.Limits, containerResources.Limits, resourceName, quantity, false, source+"/max")
+			}
+
+			for resourceName, ratio := range item.MaxLimitRequestRatio {
+				request, ok := newResources.Requests[resourceName]
+				if !ok || request.IsZero() {
+					continue
+				}
+
+				maxLimit := multiplyQuantity(resourceName, request, ratio.AsApproximateFloat64())
+
+				bound("limit", newResources.Limits, containerResources.Limits, resourceName, maxLimit, false, source+"/maxLimitRequestRatio") //nolint:lll
+			}
+		}
+	}
+}
@@ -15,9 +15,13 @@ package resources
 import (
 	"context"
 	"flag"
+	"fmt"
+	"math"
+	"slices"
 	"strings"
 
 	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -33,109 +37,392 @@ var (
 	containerResourceMemory = flag.String("resources.default.memory", defaultContainerResourceMemory, "Default Memory requests") //nolint:lll
 )
 
+// resources that can be defaulted by rule.
+var defaultResourceNames = []corev1.ResourceName{
+	corev1.ResourceCPU,
+	corev1.ResourceMemory,
+	corev1.ResourceEphemeralStorage,
+}
+
+const patchName = "resources"
+
 type Patch struct{}
 
 // get default resources from pod annotations
 // pod-admission-controller/defaultResourcesCPU=100m
 // pod-admission-controller/defaultResourcesMemory=500Mi.
 func (p *Patch) GetDefaultResources(containerInfo *types.ContainerInfo) (resource.Quantity, resource.Quantity) {
-	defaultRequestCPU := resource.MustParse(*containerResourceCPU)
-	defaultRequestMemory := resource.MustParse(*containerResourceMemory)
+	defaultRequests := p.GetDefaultRequests(containerInfo, &types.Rule{})
 
-	if defaultResource, ok := containerInfo.GetPodAnnotation(types.AnnotationDefaultResourcesCPU); ok {
-		resourceCPU, err := resource.ParseQuantity(defaultResource)
-		if err != nil {
-			log.WithError(err).Errorf("ParseQuantity: %+v", defaultResource)
-		} else {
-			defaultRequestCPU = resourceCPU
+	return defaultRequests[corev1.ResourceCPU], defaultRequests[corev1.ResourceMemory]
+}
+
+// get default requests, values from pod annotations override rule values,
+// rule values override LimitRange values, LimitRange values override values from flags.
+func (p *Patch) GetDefaultRequests(containerInfo *types.ContainerInfo, rule *types.Rule) corev1.ResourceList {
+	defaultRequests := corev1.ResourceList{}
+	sources := make(map[corev1.ResourceName]string)
+
+	setDefault := func(resourceName corev1.ResourceName, quantity resource.Quantity, source string) {
+		defaultRequests[resourceName] = quantity
+		sources[resourceName] = source
+	}
+
+	setDefault(corev1.ResourceCPU, resource.MustParse(*containerResourceCPU), "flag")
+	setDefault(corev1.ResourceMemory, resource.MustParse(*containerResourceMemory), "flag")
+
+	for _, limitRange := range getLimitRanges(containerInfo.Namespace) {
+		for _, item := range getContainerLimits(limitRange) {
+			for resourceName, quantity := range item.DefaultRequest {
+				// first LimitRange wins
+				if strings.HasPrefix(sources[resourceName], limitRangeSourcePrefix) {
+					continue
+				}
+
+				setDefault(resourceName, quantity, limitRangeSourcePrefix+limitRange.Name)
+			}
 		}
 	}
 
-	if defaultResource, ok := containerInfo.GetPodAnnotation(types.AnnotationDefaultResourcesMemory); ok {
-		resourceMemory, err := resource.ParseQuantity(defaultResource)
-		if err != nil {
-			log.WithError(err).Errorf("ParseQuantity: %+v", defaultResource)
-		} else {
-			defaultRequestMemory = resourceMemory
+	for resourceName, quantity := range rule.AddDefaultResources.Requests {
+		setDefault(resourceName, quantity, "rule")
+	}
+
+	annotations := map[corev1.ResourceName]string{
+		corev1.ResourceCPU:    types.AnnotationDefaultResourcesCPU,
+		corev1.ResourceMemory: types.AnnotationDefaultResourcesMemory,
+	}
+
+	for resourceName, annotation := range annotations {
+		if defaultResource, ok := containerInfo.GetPodAnnotation(annotation); ok {
+			quantity, err := resource.ParseQuantity(defaultResource)
+			if err != nil {
+				log.WithError(err).Errorf("ParseQuantity: %+v", defaultResource)
+			} else {
+				setDefault(resourceName, quantity, "annotation/"+annotation)
+			}
 		}
 	}
 
-	return defaultRequestCPU, defaultRequestMemory
+	for _, resourceName := range sortedResourceNames(defaultRequests) {
+		quantity := defaultRequests[resourceName]
+
+		rule.Logf("GetDefaultRequests: %s=%s source=%s", resourceName, quantity.String(), sources[resourceName])
+	}
+
+	return defaultRequests
 }
 
-func (p *Patch) Create(_ context.Context, containerInfo *types.ContainerInfo) ([]types.PatchOperation, error) { //nolint:lll,funlen,cyclop
+// get default limit for resource, returns false if limit should not be set,
+// rule values override LimitRange default.
+func (p *Patch) GetDefaultLimit(containerInfo *types.ContainerInfo, rule *types.Rule, resourceName corev1.ResourceName, request resource.Quantity) (resource.Quantity, bool) { //nolint:lll,cyclop
+	addDefaultResources := rule.AddDefaultResources
+
+	limitRangeLimit, limitRangeSource := getLimitRangeDefaultLimit(containerInfo.Namespace, resourceName)
+	ruleLimit, hasRuleLimit := addDefaultResources.Limits[resourceName]
+
+	var (
+		limit  resource.Quantity
+		source string
+	)
+
+	switch {
+	case hasRuleLimit:
+		limit, source = ruleLimit, "rule"
+	case addDefaultResources.LimitRatio[resourceName] > 0:
+		if request.IsZero() {
+			return resource.Quantity{}, false
+		}
+
+		limit, source = multiplyQuantity(resourceName, request, addDefaultResources.LimitRatio[resourceName]), "rule/limitRatio"
+	case resourceName == corev1.ResourceCPU && addDefaultResources.LimitCPU:
+		limit, source = request, "rule/limitCPU"
+	case len(limitRangeSource) > 0:
+		limit, source = limitRangeLimit, limitRangeSource
+	case resourceName == corev1.ResourceMemory:
+		limit, source = request, "request"
+	default:
+		return resource.Quantity{}, false
+	}
+
+	if limit.IsZero() {
+		return resource.Quantity{}, false
+	}
+
+	// default limit can not be lower than request
+	if limit.Cmp(request) < 0 {
+		limit, source = request, "request"
+	}
+
+	rule.Logf("GetDefaultLimit: %s=%s source=%s", resourceName, limit.String(), source)
+
+	return limit, true
+}
+
+func (p *Patch) Create(_ context.Context, containerInfo *types.ContainerInfo) ([]types.PatchOperation, error) { //nolint:lll
+	selectedRule := p.getSelectedRule(containerInfo)
+	if selectedRule == nil {
+		return []types.PatchOperation{}, nil
+	}
+
+	// remove pod resources from all containers
+	if selectedRule.AddDefaultResources.RemoveResources { //nolint:staticcheck
+		return []types.PatchOperation{{
+			Op:   "remove",
+			Path: containerInfo.PodContainer.ContainerPath() + "/resources",
+		}}, nil
+	}
+
+	selectedRule.Logf("CreateDefaultResourcesPatch: %+v", selectedRule)
+
+	newResources, err := p.GetResources(containerInfo, selectedRule)
+	if err != nil {
+		return nil, err
+	}
+
+	return []types.PatchOperation{{
+		Op:    "add",
+		Path:  containerInfo.PodContainer.ContainerPath() + "/resources",
+		Value: newResources,
+	}}, nil
+}
+
+// return first rule with enabled AddDefaultResources, nil if container does not need default resources.
+func (p *Patch) getSelectedRule(containerInfo *types.ContainerInfo) *types.Rule {
 	// some containers don't need default resources
 	// pod-admission-controller/ignoreAddDefaultResources=container1,container2
 	if ignore, ok := containerInfo.GetPodAnnotation(types.AnnotationIgnoreAddDefaultResources); ok { //nolint:staticcheck
 		containersNames := strings.Split(ignore, ",")
 		for _, containerName := range containersNames {
 			if containerName == containerInfo.ContainerName {
-				return []types.PatchOperation{}, nil
+				return nil
 			}
 		}
 	}
 
-	patch := make([]types.PatchOperation, 0)
-
 	for _, selectedRule := range containerInfo.SelectedRules {
-		if !selectedRule.AddDefaultResources.Enabled {
-			continue
+		if selectedRule.AddDefaultResources.Enabled {
+			return selectedRule
 		}
+	}
 
-		// remove pod resources from all containers
-		if selectedRule.AddDefaultResources.RemoveResources { //nolint:staticcheck
-			return []types.PatchOperation{{
-				Op:   "remove",
-				Path: containerInfo.PodContainer.ContainerPath() + "/resources",
-			}}, nil
-		}
+	return nil
+}
+
+// return container resources as they will be after this patch,
+// used by other patches that depend on container resources.
+func (p *Patch) GetFinalResources(containerInfo *types.ContainerInfo) (corev1.ResourceRequirements, error) {
+	selectedRule := p.getSelectedRule(containerInfo)
+
+	switch {
+	case selectedRule == nil || containerInfo.IgnorePatch(patchName):
+		return containerInfo.PodContainer.Container.Resources, nil
+	case selectedRule.AddDefaultResources.RemoveResources: //nolint:staticcheck
+		return corev1.ResourceRequirements{}, nil
+	}
 
-		selectedRule.Logf("CreateDefaultResourcesPatch: %+v", selectedRule)
+	// warnings are returned by Create
+	info := *containerInfo
+	info.Warnings = nil
 
-		newResources := corev1.ResourceRequirements{}
+	return p.GetResources(&info, selectedRule)
+}
+
+// return container resources with default values.
+func (p *Patch) GetResources(containerInfo *types.ContainerInfo, selectedRule *types.Rule) (corev1.ResourceRequirements, error) { //nolint:lll,cyclop
+	containerResources := containerInfo.PodContainer.Container.Resources
+
+	// keep all container resources, for example nvidia.com/gpu
+	newResources := corev1.ResourceRequirements{
+		Requests: containerResources.Requests.DeepCopy(),
+		Limits:   containerResources.Limits.DeepCopy(),
+	}
 
+	if newResources.Requests == nil {
 		newResources.Requests = corev1.ResourceList{}
+	}
+
+	if newResources.Limits == nil {
 		newResources.Limits = corev1.ResourceList{}
+	}
 
-		defaultRequestCPU, defaultRequestMemory := p.GetDefaultResources(containerInfo)
+	if selectedRule.AddDefaultResources.FromVPA.Enabled {
+		vpaRequests, err := p.GetVPARequests(containerInfo, selectedRule)
+		if err != nil {
+			return newResources, errors.Wrap(err, "error getting VerticalPodAutoscaler recommendations")
+		}
+
+		p.setVPARequests(selectedRule.AddDefaultResources.FromVPA, vpaRequests, &newResources)
+	}
 
-		if containerInfo.PodContainer.Container.Resources.Requests.Cpu().IsZero() {
-			newResources.Requests["cpu"] = defaultRequestCPU
-		} else {
-			newResources.Requests["cpu"] = *containerInfo.PodContainer.Container.Resources.Requests.Cpu()
+	defaultRequests := p.GetDefaultRequests(containerInfo, selectedRule)
+
+	for _, resourceName := range defaultResourceNames {
+		if request, ok := newResources.Requests[resourceName]; ok && !request.IsZero() {
+			continue
+		}
+
+		if request, ok := defaultRequests[resourceName]; ok {
+			newResources.Requests[resourceName] = request
+		}
+	}
+
+	for _, resourceName := range defaultResourceNames {
+		if limit, ok := newResources.Limits[resourceName]; ok && !limit.IsZero() {
+			continue
+		}
+
+		limit, ok := p.GetDefaultLimit(containerInfo, selectedRule, resourceName, newResources.Requests[resourceName])
+		if ok {
+			newResources.Limits[resourceName] = limit
+		}
+	}
+
+	p.LimitRangeBounds(containerInfo, selectedRule, &newResources)
+
+	if selectedRule.AddDefaultResources.Clamp.Enabled {
+		if err := p.ClampResources(containerInfo, selectedRule.AddDefaultResources.Clamp, &newResources); err != nil {
+			return newResources, err
+		}
+	}
+
+	selectedRule.Logf("GetResources: resources=%+v", newResources)
+
+	return newResources, nil
+}
+
+// multiply quantity by ratio, cpu is rounded to millicores, other resources to units.
+func multiplyQuantity(resourceName corev1.ResourceName, quantity resource.Quantity, ratio float64) resource.Quantity {
+	if resourceName == corev1.ResourceCPU {
+		return *resource.NewMilliQuantity(int64(math.Ceil(float64(quantity.MilliValue())*ratio)), quantity.Format)
+	}
+
+	return *resource.NewQuantity(int64(math.Ceil(float64(quantity.Value())*ratio)), quantity.Format)
+}
+
+// keep resources in bounds, values that are set in container spec deny pod if clamp.Deny is enabled,
+// values that are generated by this patch are always changed.
+func (p *Patch) ClampResources(containerInfo *types.ContainerInfo, clamp types.ResourcesClamp, newResources *corev1.ResourceRequirements) error { //nolint:lll
+	containerResources := containerInfo.PodContainer.Container.Resources
+	violations := make([]string, 0)
+
+	clampList := func(kind string, values, containerValues, bounds corev1.ResourceList, isMin bool) {
+		for _, resourceName := range sortedResourceNames(bounds) {
+			value, ok := values[resourceName]
+			if !ok {
+				continue
+			}
+
+			bound := bounds[resourceName]
+
+			if (isMin && value.Cmp(bound) >= 0) || (!isMin && value.Cmp(bound) <= 0) {
+				continue
+			}
+
+			boundName := "maximum"
+			if isMin {
+				boundName = "minimum"
+			}
+
+			if _, fromContainer := containerValues[resourceName]; fromContainer && clamp.Deny {
+				violations = append(violations, fmt.Sprintf("%s %s %s is out of %s %s",
+					resourceName, kind, value.String(), boundName, bound.String(),
+				))
+
+				continue
+			}
+
+			containerInfo.AddWarning("container %s %s %s changed from %s to %s %s",
+				containerInfo.ContainerName, resourceName, kind, value.String(), boundName, bound.String(),
+			)
+
+			values[resourceName] = bound
 		}
+	}
+
+	clampList("request", newResources.Requests, containerResources.Requests, clamp.MinRequests, true)
+	clampList("request", newResources.Requests, containerResources.Requests, clamp.MaxRequests, false)
+	clampList("limit", newResources.Limits, containerResources.Limits, clamp.MinLimits, true)
+	clampList("limit", newResources.Limits, containerResources.Limits, clamp.MaxLimits, false)
+
+	// requests must be less or equal to limits
+	for _, resourceName := range sortedResourceNames(newResources.Requests) {
+		request := newResources.Requests[resourceName]
 
-		if containerInfo.PodContainer.Container.Resources.Requests.Memory().IsZero() {
-			newResources.Requests["memory"] = defaultRequestMemory
-		} else {
-			newResources.Requests["memory"] = *containerInfo.PodContainer.Container.Resources.Requests.Memory()
+		limit, ok := newResources.Limits[resourceName]
+		if !ok || request.Cmp(limit) <= 0 {
+			continue
 		}
 
-		// add resource limits if exists
-		if containerInfo.PodContainer.Container.Resources.Limits.Cpu().IsZero() {
-			if selectedRule.AddDefaultResources.LimitCPU {
-				newResources.Limits["cpu"] = newResources.Requests["cpu"]
+		// request can not be lowered below minimum, limit is raised to request
+		if minRequest, ok := clamp.MinRequests[resourceName]; ok && limit.Cmp(minRequest) < 0 {
+			if violation := p.raiseLimit(containerInfo, clamp, newResources, resourceName); len(violation) > 0 {
+				violations = append(violations, violation)
 			}
-		} else {
-			newResources.Limits["cpu"] = *containerInfo.PodContainer.Container.Resources.Limits.Cpu()
+
+			continue
 		}
 
-		// is no memory limits set, set memory resources
-		if containerInfo.PodContainer.Container.Resources.Limits.Memory().IsZero() {
-			newResources.Limits["memory"] = newResources.Requests["memory"]
-		} else {
-			newResources.Limits["memory"] = *containerInfo.PodContainer.Container.Resources.Limits.Memory()
+		if _, fromContainer := containerResources.Requests[resourceName]; fromContainer && clamp.Deny {
+			violations = append(violations, fmt.Sprintf("%s request %s is greater than limit %s",
+				resourceName, request.String(), limit.String(),
+			))
+
+			continue
 		}
 
-		patch = append(patch, types.PatchOperation{
-			Op:    "add",
-			Path:  containerInfo.PodContainer.ContainerPath() + "/resources",
-			Value: newResources,
-		})
+		containerInfo.AddWarning("container %s %s request changed from %s to limit %s",
+			containerInfo.ContainerName, resourceName, request.String(), limit.String(),
+		)
+
+		newResources.Requests[resourceName] = limit
+	}
 
-		// process only first rule
-		break
+	if len(violations) > 0 {
+		return types.NewDenyError("container %s resources are not allowed: %s",
+			containerInfo.ContainerName, strings.Join(violations, ", "),
+		)
 	}
 
-	return patch, nil
+	return nil
+}
+
+// raise limit to request, returns violation if limit can not be changed.
+func (p *Patch) raiseLimit(containerInfo *types.ContainerInfo, clamp types.ResourcesClamp, newResources *corev1.ResourceRequirements, resourceName corev1.ResourceName) string { //nolint:lll
+	request := newResources.Requests[resourceName]
+	limit := newResources.Limits[resourceName]
+
+	if maxLimit, ok := clamp.MaxLimits[resourceName]; ok && request.Cmp(maxLimit) > 0 {
+		return fmt.Sprintf("%s request %s is greater than maximum limit %s",
+			resourceName, request.String(), maxLimit.String(),
+		)
+	}
+
+	if _, fromContainer := containerInfo.PodContainer.Container.Resources.Limits[resourceName]; fromContainer && clamp.Deny {
+		minRequest := clamp.MinRequests[resourceName]
+
+		return fmt.Sprintf("%s limit %s is lower than minimum request %s",
+			resourceName, limit.String(), minRequest.String(),
+		)
+	}
+
+	containerInfo.AddWarning("container %s %s limit changed from %s to request %s",
+		containerInfo.ContainerName, resourceName, limit.String(), request.String(),
+	)
+
+	newResources.Limits[resourceName] = request
+
+	return ""
+}
+
+func sortedResourceNames(resourceList corev1.ResourceList) []corev1.ResourceName {
+	result := make([]corev1.ResourceName, 0, len(resourceList))
+
+	for resourceName := range resourceList {
+		result = append(result, resourceName)
+	}
+
+	slices.Sort(result)
+
+	return result
 }
@@ -13,13 +13,23 @@ limitations under the License.
 package resources_test
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 
+	"github.com/maksim-paskal/pod-admission-controller/pkg/client"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/config"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/resources"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 const addOperation = "add"
@@ -177,3 +187,598 @@ func TestGetDefaultResources(t *testing.T) { //nolint:funlen
 		})
 	}
 }
+
+func TestRuleDefaultResources(t *testing.T) { //nolint:funlen
+	t.Parallel()
+
+	patch := resources.Patch{}
+
+	type testType struct {
+		Name                string
+		Resources           corev1.ResourceRequirements
+		AddDefaultResources types.AddDefaultResources
+		Expected            corev1.ResourceRequirements
+	}
+
+	tests := []testType{
+		{
+			Name: "rule requests",
+			AddDefaultResources: types.AddDefaultResources{
+				Enabled: true,
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:              resource.MustParse("1"),
+					corev1.ResourceMemory:           resource.MustParse("2Gi"),
+					corev1.ResourceEphemeralStorage: resource.MustParse("1Gi"),
+				},
+			},
+			Expected: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:              resource.MustParse("1"),
+					corev1.ResourceMemory:           resource.MustParse("2Gi"),
+					corev1.ResourceEphemeralStorage: resource.MustParse("1Gi"),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceMemory: resource.MustParse("2Gi"),
+				},
+			},
+		},
+		{
+			Name: "rule limits and ratio",
+			AddDefaultResources: types.AddDefaultResources{
+				Enabled: true,
+				Requests: corev1.ResourceList{
+					corev1.ResourceEphemeralStorage: resource.MustParse("1Gi"),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceEphemeralStorage: resource.MustParse("2Gi"),
+				},
+				LimitRatio: map[corev1.ResourceName]float64{
+					corev1.ResourceCPU:    2,
+					corev1.ResourceMemory: 1.5,
+				},
+			},
+			Expected: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:              resource.MustParse("100m"),
+					corev1.ResourceMemory:           resource.MustParse("500Mi"),
+					corev1.ResourceEphemeralStorage: resource.MustParse("1Gi"),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceCPU:              resource.MustParse("200m"),
+					corev1.ResourceMemory:           resource.MustParse("750Mi"),
+					corev1.ResourceEphemeralStorage: resource.MustParse("2Gi"),
+				},
+			},
+		},
+		{
+			Name: "container values are kept",
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceMemory:           resource.MustParse("1Gi"),
+					corev1.ResourceName("gpu/fake"): resource.MustParse("1"),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceName("gpu/fake"): resource.MustParse("1"),
+				},
+			},
+			AddDefaultResources: types.AddDefaultResources{
+				Enabled: true,
+				Limits: corev1.ResourceList{
+					corev1.ResourceMemory: resource.MustParse("512Mi"),
+				},
+			},
+			Expected: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:              resource.MustParse("100m"),
+					corev1.ResourceMemory:           resource.MustParse("1Gi"),
+					corev1.ResourceName("gpu/fake"): resource.MustParse("1"),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceMemory:           resource.MustParse("1Gi"),
+					corev1.ResourceName("gpu/fake"): resource.MustParse("1"),
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			containerInfo := &types.ContainerInfo{
+				PodContainer: &types.PodContainer{
+					Type: "container",
+					Container: &corev1.Container{
+						Resources: test.Resources,
+					},
+				},
+				SelectedRules: []*types.Rule{
+					{
+						AddDefaultResources: test.AddDefaultResources,
+					},
+				},
+			}
+
+			patchOps, err := patch.Create(t.Context(), containerInfo)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(patchOps) != 1 {
+				t.Fatal("1 patch must be created")
+			}
+
+			got, ok := patchOps[0].Value.(corev1.ResourceRequirements)
+			if !ok {
+				t.Fatalf("not corrected value %s", patchOps[0].String())
+			}
+
+			if !equality.Semantic.DeepEqual(got, test.Expected) {
+				t.Fatalf("not corrected resources %+v, expected %+v", got, test.Expected)
+			}
+		})
+	}
+}
+
+func TestAddDefaultResourcesValidate(t *testing.T) {
+	t.Parallel()
+
+	addDefaultResources := types.AddDefaultResources{
+		LimitRatio: map[corev1.ResourceName]float64{
+			corev1.ResourceMemory: 0.5,
+		},
+	}
+
+	if err := addDefaultResources.Validate(); err == nil {
+		t.Fatal("limit ratio lower than 1 must be not valid")
+	}
+}
+
+func TestClampResources(t *testing.T) { //nolint:funlen
+	t.Parallel()
+
+	patch := resources.Patch{}
+
+	clamp := types.ResourcesClamp{
+		Enabled: true,
+		MinRequests: corev1.ResourceList{
+			corev1.ResourceCPU: resource.MustParse("10m"),
+		},
+		MaxLimits: corev1.ResourceList{
+			corev1.ResourceMemory: resource.MustParse("8Gi"),
+		},
+	}
+
+	type testType struct {
+		Name      string
+		Deny      bool
+		Resources corev1.ResourceRequirements
+		Expected  corev1.ResourceRequirements
+		Warnings  int
+		Error     bool
+	}
+
+	tests := []testType{
+		{
+			Name: "values in bounds",
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("100m"),
+					corev1.ResourceMemory: resource.MustParse("1Gi"),
+				},
+			},
+			Expected: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("100m"),
+					corev1.ResourceMemory: resource.MustParse("1Gi"),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceMemory: resource.MustParse("1Gi"),
+				},
+			},
+		},
+		{
+			Name: "values out of bounds",
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("5m"),
+					corev1.ResourceMemory: resource.MustParse("10Gi"),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceMemory: resource.MustParse("16Gi"),
+				},
+			},
+			Expected: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("10m"),
+					corev1.ResourceMemory: resource.MustParse("8Gi"),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceMemory: resource.MustParse("8Gi"),
+				},
+			},
+			Warnings: 3,
+		},
+		{
+			Name: "deny values out of bounds",
+			Deny: true,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("5m"),
+				},
+			},
+			Error: true,
+		},
+		{
+			Name: "limit lower than minimum request",
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("1m"),
+					corev1.ResourceMemory: resource.MustParse("1Gi"),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("5m"),
+				},
+			},
+			Expected: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("10m"),
+					corev1.ResourceMemory: resource.MustParse("1Gi"),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("10m"),
+					corev1.ResourceMemory: resource.MustParse("1Gi"),
+				},
+			},
+			Warnings: 2,
+		},
+		{
+			Name: "deny limit lower than minimum request",
+			Deny: true,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("10m"),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("5m"),
+				},
+			},
+			Error: true,
+		},
+		{
+			Name: "deny does not affect generated values",
+			Deny: true,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("100m"),
+				},
+			},
+			Expected: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("100m"),
+					corev1.ResourceMemory: resource.MustParse("500Mi"),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceMemory: resource.MustParse("500Mi"),
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			testClamp := clamp
+			testClamp.Deny = test.Deny
+
+			containerInfo := &types.ContainerInfo{
+				ContainerName: "test",
+				PodContainer: &types.PodContainer{
+					Type: "container",
+					Container: &corev1.Container{
+						Resources: test.Resources,
+					},
+				},
+				SelectedRules: []*types.Rule{
+					{
+						AddDefaultResources: types.AddDefaultResources{
+							Enabled: true,
+							Clamp:   testClamp,
+						},
+					},
+				},
+			}
+
+			patchOps, err := patch.Create(t.Context(), containerInfo)
+			if test.Error {
+				var denyError *types.DenyError
+				if !errors.As(err, &denyError) {
+					t.Fatalf("deny error expected, got %v", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(patchOps) != 1 {
+				t.Fatal("1 patch must be created")
+			}
+
+			if got := patchOps[0].Value; !equality.Semantic.DeepEqual(got, test.Expected) {
+				t.Fatalf("not corrected resources %+v, expected %+v", got, test.Expected)
+			}
+
+			if len(containerInfo.Warnings) != test.Warnings {
+				t.Fatalf("expected %d warnings, got %v", test.Warnings, containerInfo.Warnings)
+			}
+		})
+	}
+}
+
+// informers are global, test must not be parallel.
+func TestLimitRange(t *testing.T) { //nolint:funlen
+	client.SetKubeClient(fake.NewClientset(&corev1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "limits",
+			Namespace: "test-limitrange",
+		},
+		Spec: corev1.LimitRangeSpec{
+			Limits: []corev1.LimitRangeItem{
+				{
+					Type: corev1.LimitTypeContainer,
+					DefaultRequest: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse("50m"),
+						corev1.ResourceMemory: resource.MustParse("256Mi"),
+					},
+					Default: corev1.ResourceList{
+						corev1.ResourceMemory: resource.MustParse("1Gi"),
+					},
+					Min: corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("100m"),
+					},
+					Max: corev1.ResourceList{
+						corev1.ResourceMemory: resource.MustParse("768Mi"),
+					},
+				},
+			},
+		},
+	}))
+
+	if err := client.StartInformers(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	patch := resources.Patch{}
+
+	tests := []struct {
+		name      string
+		rule      types.AddDefaultResources
+		container corev1.ResourceRequirements
+		expected  corev1.ResourceRequirements
+		warnings  int
+	}{
+		{
+			name: "LimitRange values",
+			expected: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("100m"),
+					corev1.ResourceMemory: resource.MustParse("256Mi"),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceMemory: resource.MustParse("768Mi"),
+				},
+			},
+		},
+		{
+			name: "rule values override LimitRange values",
+			rule: types.AddDefaultResources{
+				Requests: corev1.ResourceList{
+					corev1.ResourceMemory: resource.MustParse("512Mi"),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceMemory: resource.MustParse("640Mi"),
+				},
+			},
+			expected: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("100m"),
+					corev1.ResourceMemory: resource.MustParse("512Mi"),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceMemory: resource.MustParse("640Mi"),
+				},
+			},
+		},
+		{
+			name: "request is not greater than container limit",
+			container: corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("50m"),
+				},
+			},
+			expected: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("50m"),
+					corev1.ResourceMemory: resource.MustParse("256Mi"),
+				},
+				Limits: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("50m"),
+					corev1.ResourceMemory: resource.MustParse("768Mi"),
+				},
+			},
+			warnings: 1,
+		},
+	}
+
+	for _, test := range tests {
+		test.rule.Enabled = true
+
+		containerInfo := &types.ContainerInfo{
+			Namespace: "test-limitrange",
+			PodContainer: &types.PodContainer{
+				Type:      "container",
+				Container: &corev1.Container{Resources: test.container},
+			},
+			SelectedRules: []*types.Rule{{AddDefaultResources: test.rule}},
+		}
+
+		patchOps, err := patch.Create(t.Context(), containerInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(patchOps) != 1 {
+			t.Fatalf("%s: 1 patch must be created", test.name)
+		}
+
+		if got := patchOps[0].Value; !equality.Semantic.DeepEqual(got, test.expected) {
+			t.Fatalf("%s: not corrected resources %+v, expected %+v", test.name, got, test.expected)
+		}
+
+		if len(containerInfo.Warnings) != test.warnings {
+			t.Fatalf("%s: expected %d warnings, got %v", test.name, test.warnings, containerInfo.Warnings)
+		}
+	}
+}
+
+// informers are global, test must not be parallel.
+func TestVPA(t *testing.T) { //nolint:funlen
+	rule := &types.Rule{
+		AddDefaultResources: types.AddDefaultResources{
+			Enabled: true,
+			FromVPA: types.ResourcesFromVPA{
+				Enabled: true,
+			},
+		},
+	}
+
+	config.Get().Rules = []*types.Rule{rule}
+	defer func() {
+		config.Get().Rules = nil
+	}()
+
+	kubeClient := fake.NewClientset()
+	kubeClient.Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: client.VerticalPodAutoscalerResource.GroupVersion().String(),
+			APIResources: []metav1.APIResource{{Name: client.VerticalPodAutoscalerResource.Resource}},
+		},
+	}
+
+	vpa := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "autoscaling.k8s.io/v1",
+		"kind":       "VerticalPodAutoscaler",
+		"metadata": map[string]interface{}{
+			"name":      "test",
+			"namespace": "test-vpa",
+		},
+		"spec": map[string]interface{}{
+			"targetRef": map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "Deployment",
+				"name":       "test",
+			},
+			"resourcePolicy": map[string]interface{}{
+				"containerPolicies": []interface{}{
+					map[string]interface{}{
+						"containerName": "*",
+						"maxAllowed": map[string]interface{}{
+							"memory": "1Gi",
+						},
+					},
+				},
+			},
+		},
+		"status": map[string]interface{}{
+			"recommendation": map[string]interface{}{
+				"containerRecommendations": []interface{}{
+					map[string]interface{}{
+						"containerName": "app",
+						"target": map[string]interface{}{
+							"cpu":    "250m",
+							"memory": "2Gi",
+						},
+					},
+				},
+			},
+		},
+	}}
+
+	client.SetKubeClient(kubeClient)
+	client.SetDynamicClient(dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			client.VerticalPodAutoscalerResource: "VerticalPodAutoscalerList",
+		},
+		vpa,
+	))
+
+	if err := client.StartInformers(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	patch := resources.Patch{}
+
+	containerInfo := &types.ContainerInfo{
+		Namespace:     "test-vpa",
+		OwnerKind:     "ReplicaSet",
+		OwnerName:     "test-5d4b8c7f9",
+		ContainerName: "app",
+		PodLabels: map[string]string{
+			"pod-template-hash": "5d4b8c7f9",
+		},
+		PodContainer: &types.PodContainer{
+			Type: "container",
+			Container: &corev1.Container{
+				Name: "app",
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceCPU: resource.MustParse("100m"),
+					},
+				},
+			},
+		},
+		SelectedRules: []*types.Rule{rule},
+	}
+
+	patchOps, err := patch.Create(t.Context(), containerInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(patchOps) != 1 {
+		t.Fatal("1 patch must be created")
+	}
+
+	expected := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("100m"),
+			corev1.ResourceMemory: resource.MustParse("1Gi"),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceMemory: resource.MustParse("1Gi"),
+		},
+	}
+
+	if got := patchOps[0].Value; !equality.Semantic.DeepEqual(got, expected) {
+		t.Fatalf("not corrected resources %+v, expected %+v", got, expected)
+	}
+
+	// force recommendations
+	rule.AddDefaultResources.FromVPA.Force = true
+
+	patchOps, err = patch.Create(t.Context(), containerInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected.Requests[corev1.ResourceCPU] = resource.MustParse("250m")
+
+	if got := patchOps[0].Value; !equality.Semantic.DeepEqual(got, expected) {
+		t.Fatalf("not corrected resources %+v, expected %+v", got, expected)
+	}
+}
@@ -0,0 +1,220 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package resources
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/client"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	"github.com/pkg/errors"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	vpaContainerPolicyAll  = "*"
+	vpaContainerScalingOff = "Off"
+)
+
+// fields of autoscaling.k8s.io/v1 VerticalPodAutoscaler that are used in patch.
+type verticalPodAutoscaler struct {
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec struct {
+		TargetRef      *autoscalingv1.CrossVersionObjectReference `json:"targetRef,omitempty"`
+		ResourcePolicy *struct {
+			ContainerPolicies []vpaContainerPolicy `json:"containerPolicies,omitempty"`
+		} `json:"resourcePolicy,omitempty"`
+	} `json:"spec"`
+
+	Status struct {
+		Recommendation *struct {
+			ContainerRecommendations []vpaContainerRecommendation `json:"containerRecommendations,omitempty"`
+		} `json:"recommendation,omitempty"`
+	} `json:"status"`
+}
+
+type vpaContainerPolicy struct {
+	ContainerName       string                `json:"containerName,omitempty"`
+	Mode                string                `json:"mode,omitempty"`
+	MinAllowed          corev1.ResourceList   `json:"minAllowed,omitempty"`
+	MaxAllowed          corev1.ResourceList   `json:"maxAllowed,omitempty"`
+	ControlledResources []corev1.ResourceName `json:"controlledResources,omitempty"`
+}
+
+type vpaContainerRecommendation struct {
+	ContainerName string              `json:"containerName,omitempty"`
+	Target        corev1.ResourceList `json:"target,omitempty"`
+}
+
+// return kind and name of workload that owns pod,
+// pods of Deployment are owned by ReplicaSet with pod-template-hash suffix.
+func getPodWorkload(containerInfo *types.ContainerInfo) (string, string) {
+	if containerInfo.OwnerKind == "ReplicaSet" {
+		if hash, ok := containerInfo.PodLabels["pod-template-hash"]; ok {
+			return "Deployment", strings.TrimSuffix(containerInfo.OwnerName, "-"+hash)
+		}
+	}
+
+	return containerInfo.OwnerKind, containerInfo.OwnerName
+}
+
+// return VerticalPodAutoscaler that targets pod workload.
+func getPodVPA(containerInfo *types.ContainerInfo) (*verticalPodAutoscaler, error) {
+	if len(containerInfo.OwnerKind) == 0 || client.DynamicInformers() == nil {
+		return nil, nil //nolint:nilnil
+	}
+
+	objects, err := client.DynamicInformers().
+		ForResource(client.VerticalPodAutoscalerResource).
+		Lister().
+		ByNamespace(containerInfo.Namespace).
+		List(labels.Everything())
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing VerticalPodAutoscalers")
+	}
+
+	kind, name := getPodWorkload(containerInfo)
+
+	for _, object := range objects {
+		unstructuredObject, ok := object.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+
+		vpa := verticalPodAutoscaler{}
+
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(unstructuredObject.Object, &vpa); err != nil {
+			return nil, errors.Wrapf(err, "error converting VerticalPodAutoscaler %s", unstructuredObject.GetName())
+		}
+
+		targetRef := vpa.Spec.TargetRef
+		if targetRef == nil {
+			continue
+		}
+
+		if targetRef.Kind == kind && targetRef.Name == name {
+			return &vpa, nil
+		}
+
+		// VerticalPodAutoscaler can target ReplicaSet directly
+		if targetRef.Kind == containerInfo.OwnerKind && targetRef.Name == containerInfo.OwnerName {
+			return &vpa, nil
+		}
+	}
+
+	return nil, nil //nolint:nilnil
+}
+
+// return container policy, policy with container name wins over default policy.
+func (v *verticalPodAutoscaler) getContainerPolicy(containerName string) *vpaContainerPolicy {
+	if v.Spec.ResourcePolicy == nil {
+		return nil
+	}
+
+	var result *vpaContainerPolicy
+
+	for i, policy := range v.Spec.ResourcePolicy.ContainerPolicies {
+		if policy.ContainerName == containerName {
+			return &v.Spec.ResourcePolicy.ContainerPolicies[i]
+		}
+
+		if policy.ContainerName == vpaContainerPolicyAll {
+			result = &v.Spec.ResourcePolicy.ContainerPolicies[i]
+		}
+	}
+
+	return result
+}
+
+// return VerticalPodAutoscaler target recommendation for container, kept in policy bounds.
+func (p *Patch) GetVPARequests(containerInfo *types.ContainerInfo, rule *types.Rule) (corev1.ResourceList, error) {
+	vpa, err := getPodVPA(containerInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	if vpa == nil || vpa.Status.Recommendation == nil {
+		return nil, nil
+	}
+
+	policy := vpa.getContainerPolicy(containerInfo.ContainerName)
+	if policy != nil && policy.Mode == vpaContainerScalingOff {
+		rule.Logf("GetVPARequests: container scaling is off in %s", vpa.Name)
+
+		return nil, nil
+	}
+
+	controlledResources := []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory}
+	if policy != nil && len(policy.ControlledResources) > 0 {
+		controlledResources = policy.ControlledResources
+	}
+
+	result := corev1.ResourceList{}
+
+	for _, recommendation := range vpa.Status.Recommendation.ContainerRecommendations {
+		if recommendation.ContainerName != containerInfo.ContainerName {
+			continue
+		}
+
+		for resourceName, target := range recommendation.Target {
+			if !slices.Contains(controlledResources, resourceName) {
+				continue
+			}
+
+			if policy != nil {
+				if minAllowed, ok := policy.MinAllowed[resourceName]; ok && target.Cmp(minAllowed) < 0 {
+					target = minAllowed
+				}
+
+				if maxAllowed, ok := policy.MaxAllowed[resourceName]; ok && target.Cmp(maxAllowed) > 0 {
+					target = maxAllowed
+				}
+			}
+
+			rule.Logf("GetVPARequests: %s=%s source=vpa/%s", resourceName, target.String(), vpa.Name)
+
+			result[resourceName] = target
+		}
+	}
+
+	return result, nil
+}
+
+// set recommendations as requests for resources without requests,
+// with fromVPA.Force container requests are replaced and limits are scaled proportionally.
+func (p *Patch) setVPARequests(fromVPA types.ResourcesFromVPA, vpaRequests corev1.ResourceList, newResources *corev1.ResourceRequirements) { //nolint:lll
+	for _, resourceName := range sortedResourceNames(vpaRequests) {
+		target := vpaRequests[resourceName]
+
+		if request, ok := newResources.Requests[resourceName]; ok && !request.IsZero() {
+			if !fromVPA.Force {
+				continue
+			}
+
+			if limit, ok := newResources.Limits[resourceName]; ok && !limit.IsZero() {
+				ratio := float64(limit.MilliValue()) / float64(request.MilliValue())
+
+				newResources.Limits[resourceName] = multiplyQuantity(resourceName, target, ratio)
+			}
+		}
+
+		newResources.Requests[resourceName] = target
+	}
+}
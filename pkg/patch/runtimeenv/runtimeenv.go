@@ -0,0 +1,135 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package runtimeenv
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/env"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/resources"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	patchName = "runtimeenv"
+	mebibyte  = 1024 * 1024
+)
+
+// default percent of memory limit that runtime can use.
+var defaultMemoryPercent = map[string]int{
+	types.RuntimeGo:   90,
+	types.RuntimeJava: 75,
+	types.RuntimeNode: 75,
+}
+
+type Patch struct{}
+
+// returns container env that will be after patch.
+func (p *Patch) GetFinalEnv(ctx context.Context, containerInfo *types.ContainerInfo) ([]corev1.EnvVar, error) {
+	finalEnv, err := (&env.Patch{}).GetFinalEnv(ctx, containerInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	if containerInfo.IgnorePatch(patchName) {
+		return finalEnv, nil
+	}
+
+	patchOps, err := p.Create(ctx, containerInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	return env.ApplyEnvPatch(finalEnv, patchOps), nil
+}
+
+func (p *Patch) Create(ctx context.Context, containerInfo *types.ContainerInfo) ([]types.PatchOperation, error) {
+	for _, selectedRule := range containerInfo.SelectedRules {
+		if !selectedRule.RuntimeEnv.Enabled {
+			continue
+		}
+
+		selectedRule.Logf("CreateRuntimeEnv: %+v", selectedRule.RuntimeEnv)
+
+		// resources with default values that are added in this admission
+		containerResources, err := (&resources.Patch{}).GetFinalResources(containerInfo)
+		if err != nil {
+			return nil, errors.Wrap(err, "error getting container resources")
+		}
+
+		runtimeEnv := p.GetRuntimeEnv(selectedRule.RuntimeEnv, containerResources)
+
+		selectedRule.Logf("CreateRuntimeEnv: env=%+v", runtimeEnv)
+
+		// env that are added by env patch are not changed
+		finalEnv, err := (&env.Patch{}).GetFinalEnv(ctx, containerInfo)
+		if err != nil {
+			return nil, errors.Wrap(err, "error getting container env")
+		}
+
+		return env.CreateEnvPatch(containerInfo, finalEnv, runtimeEnv), nil
+	}
+
+	return []types.PatchOperation{}, nil
+}
+
+// return runtime env for container resources.
+func (p *Patch) GetRuntimeEnv(runtimeEnv types.RuntimeEnv, containerResources corev1.ResourceRequirements) []corev1.EnvVar { //nolint:lll
+	memoryPercent := runtimeEnv.MemoryPercent
+	if memoryPercent == 0 {
+		memoryPercent = defaultMemoryPercent[runtimeEnv.Runtime]
+	}
+
+	var memoryLimitMiB, cpuLimit int64
+
+	if memoryLimit := containerResources.Limits.Memory(); !memoryLimit.IsZero() {
+		memoryLimitMiB = memoryLimit.Value() * int64(memoryPercent) / 100 / mebibyte
+	}
+
+	// runtime needs at least one processor
+	if cpu := containerResources.Limits.Cpu(); !cpu.IsZero() {
+		cpuLimit = max(1, int64(math.Floor(cpu.AsApproximateFloat64())))
+	}
+
+	result := make([]corev1.EnvVar, 0)
+
+	switch runtimeEnv.Runtime {
+	case types.RuntimeGo:
+		if memoryLimitMiB > 0 {
+			result = append(result, corev1.EnvVar{Name: "GOMEMLIMIT", Value: fmt.Sprintf("%dMiB", memoryLimitMiB)})
+		}
+
+		if cpuLimit > 0 {
+			result = append(result, corev1.EnvVar{Name: "GOMAXPROCS", Value: fmt.Sprintf("%d", cpuLimit)})
+		}
+	case types.RuntimeJava:
+		// jvm reads container memory limit, only percent is needed
+		options := fmt.Sprintf("-XX:MaxRAMPercentage=%d.0", memoryPercent)
+
+		if cpuLimit > 0 {
+			options += fmt.Sprintf(" -XX:ActiveProcessorCount=%d", cpuLimit)
+		}
+
+		result = append(result, corev1.EnvVar{Name: "JAVA_TOOL_OPTIONS", Value: options})
+	case types.RuntimeNode:
+		if memoryLimitMiB > 0 {
+			result = append(result, corev1.EnvVar{Name: "NODE_OPTIONS", Value: fmt.Sprintf("--max-old-space-size=%d", memoryLimitMiB)})
+		}
+	}
+
+	return result
+}
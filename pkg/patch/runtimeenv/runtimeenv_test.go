@@ -0,0 +1,174 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package runtimeenv_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/runtimeenv"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestGetRuntimeEnv(t *testing.T) { //nolint:funlen
+	t.Parallel()
+
+	patch := runtimeenv.Patch{}
+
+	containerResources := corev1.ResourceRequirements{
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("2500m"),
+			corev1.ResourceMemory: resource.MustParse("1Gi"),
+		},
+	}
+
+	type testType struct {
+		RuntimeEnv types.RuntimeEnv
+		Resources  corev1.ResourceRequirements
+		Expected   []corev1.EnvVar
+	}
+
+	tests := []testType{
+		{
+			RuntimeEnv: types.RuntimeEnv{Runtime: types.RuntimeGo},
+			Resources:  containerResources,
+			Expected: []corev1.EnvVar{
+				{Name: "GOMEMLIMIT", Value: "921MiB"},
+				{Name: "GOMAXPROCS", Value: "2"},
+			},
+		},
+		{
+			RuntimeEnv: types.RuntimeEnv{Runtime: types.RuntimeGo},
+			Resources: corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("100m"),
+				},
+			},
+			Expected: []corev1.EnvVar{
+				{Name: "GOMAXPROCS", Value: "1"},
+			},
+		},
+		{
+			RuntimeEnv: types.RuntimeEnv{Runtime: types.RuntimeJava, MemoryPercent: 80},
+			Resources:  containerResources,
+			Expected: []corev1.EnvVar{
+				{Name: "JAVA_TOOL_OPTIONS", Value: "-XX:MaxRAMPercentage=80.0 -XX:ActiveProcessorCount=2"},
+			},
+		},
+		{
+			RuntimeEnv: types.RuntimeEnv{Runtime: types.RuntimeNode},
+			Resources:  containerResources,
+			Expected: []corev1.EnvVar{
+				{Name: "NODE_OPTIONS", Value: "--max-old-space-size=768"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.RuntimeEnv.Runtime, func(t *testing.T) {
+			t.Parallel()
+
+			got := patch.GetRuntimeEnv(test.RuntimeEnv, test.Resources)
+
+			if !reflect.DeepEqual(got, test.Expected) {
+				t.Fatalf("not corrected env %+v, expected %+v", got, test.Expected)
+			}
+		})
+	}
+}
+
+func TestCreate(t *testing.T) { //nolint:funlen
+	t.Parallel()
+
+	patch := runtimeenv.Patch{}
+
+	type testType struct {
+		Name     string
+		Env      []corev1.EnvVar
+		RuleEnv  []corev1.EnvVar
+		Expected []types.PatchOperation
+	}
+
+	tests := []testType{
+		{
+			Name: "container without env",
+			Expected: []types.PatchOperation{
+				{
+					Op:   "add",
+					Path: "/spec/containers/0/env",
+					Value: []corev1.EnvVar{
+						{Name: "GOMEMLIMIT", Value: "450MiB"},
+					},
+				},
+			},
+		},
+		{
+			Name: "env created by env patch",
+			RuleEnv: []corev1.EnvVar{
+				{Name: "TEST", Value: "test"},
+			},
+			Expected: []types.PatchOperation{
+				{
+					Op:    "add",
+					Path:  "/spec/containers/0/env/-",
+					Value: corev1.EnvVar{Name: "GOMEMLIMIT", Value: "450MiB"},
+				},
+			},
+		},
+		{
+			Name: "env exists in container",
+			Env: []corev1.EnvVar{
+				{Name: "GOMEMLIMIT", Value: "1GiB"},
+			},
+			Expected: []types.PatchOperation{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+
+			containerInfo := &types.ContainerInfo{
+				PodContainer: &types.PodContainer{
+					Type: "container",
+					Container: &corev1.Container{
+						Env: test.Env,
+					},
+				},
+				SelectedRules: []*types.Rule{
+					{
+						Env: test.RuleEnv,
+						AddDefaultResources: types.AddDefaultResources{
+							Enabled: true,
+						},
+						RuntimeEnv: types.RuntimeEnv{
+							Enabled: true,
+							Runtime: types.RuntimeGo,
+						},
+					},
+				},
+			}
+
+			patchOps, err := patch.Create(t.Context(), containerInfo)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(patchOps, test.Expected) {
+				t.Fatalf("not corrected patch %+v, expected %+v", patchOps, test.Expected)
+			}
+		})
+	}
+}
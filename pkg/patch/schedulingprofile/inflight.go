@@ -0,0 +1,144 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package schedulingprofile
+
+import (
+	"sync"
+	"time"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// time while assigned pod is counted if pods informer does not have it,
+// pod that is denied after assignment is not counted after this time.
+const inflightTTL = 30 * time.Second
+
+type podAssignment struct {
+	profile string
+	expires time.Time
+}
+
+// assignments of owner that are not yet in pods informer.
+type ownerAssignments struct {
+	// informer count of profile pods when pending assignments were checked
+	base map[string]int
+	// expiration of pending assignments by profile, oldest first
+	pending map[string][]time.Time
+}
+
+// pods of scale up are admitted before pods informer has any of them,
+// assignments are counted until informer catches up, so split converges to weights.
+var inflight = struct {
+	mutex sync.Mutex
+	// key is owner uid
+	owners map[string]*ownerAssignments
+	// key is pod of admission request, pods of owner do not have name before admission
+	pods map[*corev1.Pod]podAssignment
+}{
+	owners: make(map[string]*ownerAssignments),
+	pods:   make(map[*corev1.Pod]podAssignment),
+}
+
+// select profile for pod with assignments that are not in informer counts,
+// profile is selected once for pod, because patches get profile for every container.
+func assignProfile(pod *corev1.Pod, profiles []types.SchedulingProfile, counts map[string]int) *types.SchedulingProfile { //nolint:lll
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return SelectProfile(profiles, counts)
+	}
+
+	inflight.mutex.Lock()
+	defer inflight.mutex.Unlock()
+
+	now := time.Now()
+
+	evictAssignments(now)
+
+	if assignment, ok := inflight.pods[pod]; ok {
+		return getProfileByName(profiles, assignment.profile)
+	}
+
+	assignments, ok := inflight.owners[string(owner.UID)]
+	if !ok {
+		assignments = &ownerAssignments{
+			base:    make(map[string]int),
+			pending: make(map[string][]time.Time),
+		}
+
+		inflight.owners[string(owner.UID)] = assignments
+	}
+
+	profile := SelectProfile(profiles, assignments.getCounts(counts))
+	if profile == nil {
+		return nil
+	}
+
+	assignments.base[profile.Name] = counts[profile.Name]
+	assignments.pending[profile.Name] = append(assignments.pending[profile.Name], now.Add(inflightTTL))
+
+	inflight.pods[pod] = podAssignment{profile: profile.Name, expires: now.Add(inflightTTL)}
+
+	return profile
+}
+
+// returns informer counts with pending assignments, assignments that informer has are not pending.
+func (o *ownerAssignments) getCounts(counts map[string]int) map[string]int {
+	result := make(map[string]int, len(counts))
+
+	for name, count := range counts {
+		result[name] = count
+	}
+
+	for name, pending := range o.pending {
+		count := counts[name]
+
+		// new pods in informer are pods of oldest assignments
+		if added := count - o.base[name]; added > 0 {
+			pending = pending[min(added, len(pending)):]
+		}
+
+		o.base[name] = count
+		o.pending[name] = pending
+
+		result[name] = count + len(pending)
+	}
+
+	return result
+}
+
+func evictAssignments(now time.Time) {
+	for pod, assignment := range inflight.pods {
+		if now.After(assignment.expires) {
+			delete(inflight.pods, pod)
+		}
+	}
+
+	for uid, assignments := range inflight.owners {
+		total := 0
+
+		for name, pending := range assignments.pending {
+			for len(pending) > 0 && now.After(pending[0]) {
+				pending = pending[1:]
+			}
+
+			assignments.pending[name] = pending
+			total += len(pending)
+		}
+
+		if total == 0 {
+			delete(inflight.owners, uid)
+		}
+	}
+}
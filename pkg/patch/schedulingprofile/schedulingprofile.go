@@ -0,0 +1,259 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package schedulingprofile
+
+import (
+	"context"
+	"reflect"
+	"slices"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/client"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/affinity"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/tolerations"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+const patchName = "schedulingprofile"
+
+type Patch struct{}
+
+// assign pod to scheduling profile, pods of the same owner are split between profiles by weights.
+func (p *Patch) Create(_ context.Context, containerInfo *types.ContainerInfo) ([]types.PatchOperation, error) {
+	profile, err := p.GetProfile(containerInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	if profile == nil {
+		return []types.PatchOperation{}, nil
+	}
+
+	pod := containerInfo.PodContainer.Pod
+
+	patchOps := types.NewMetadataPatch("/metadata/labels", pod.Labels, map[string]string{
+		types.LabelSchedulingProfile: profile.Name,
+	})
+
+	if len(profile.Tolerations) > 0 {
+		podTolerations, err := p.mergeTolerations(containerInfo, profile)
+		if err != nil {
+			return nil, err
+		}
+
+		patchOps = append(patchOps, types.PatchOperation{
+			Op:    "add",
+			Path:  "/spec/tolerations",
+			Value: podTolerations,
+		})
+	}
+
+	if len(profile.PreferredNodeAffinity) > 0 || len(profile.RequiredNodeAffinity) > 0 {
+		patchOps = append(patchOps, types.PatchOperation{
+			Op:    "add",
+			Path:  "/spec/affinity",
+			Value: mergeAffinity((&affinity.Patch{}).GetFinalAffinity(containerInfo), profile),
+		})
+	}
+
+	return patchOps, nil
+}
+
+// returns pod tolerations that will be after patch.
+func (p *Patch) GetFinalTolerations(containerInfo *types.ContainerInfo) ([]corev1.Toleration, error) {
+	if containerInfo.IgnorePatch(patchName) {
+		return (&tolerations.Patch{}).GetFinalTolerations(containerInfo)
+	}
+
+	profile, err := p.GetProfile(containerInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	if profile == nil {
+		return (&tolerations.Patch{}).GetFinalTolerations(containerInfo)
+	}
+
+	return p.mergeTolerations(containerInfo, profile)
+}
+
+func (p *Patch) mergeTolerations(containerInfo *types.ContainerInfo, profile *types.SchedulingProfile) ([]corev1.Toleration, error) { //nolint:lll
+	podTolerations, err := (&tolerations.Patch{}).GetFinalTolerations(containerInfo)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting pod tolerations")
+	}
+
+	podTolerations = slices.Clone(podTolerations)
+
+	for _, toleration := range profile.Tolerations {
+		exists := slices.ContainsFunc(podTolerations, func(podToleration corev1.Toleration) bool {
+			return reflect.DeepEqual(podToleration, toleration)
+		})
+
+		if !exists {
+			podTolerations = append(podTolerations, toleration)
+		}
+	}
+
+	return podTolerations, nil
+}
+
+// returns pod affinity that will be after patch, used by patches that also change affinity.
+func (p *Patch) GetFinalAffinity(containerInfo *types.ContainerInfo) (*corev1.Affinity, error) {
+	podAffinity := (&affinity.Patch{}).GetFinalAffinity(containerInfo)
+
+	if containerInfo.IgnorePatch(patchName) {
+		return podAffinity, nil
+	}
+
+	profile, err := p.GetProfile(containerInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	if profile == nil {
+		return podAffinity, nil
+	}
+
+	return mergeAffinity(podAffinity, profile), nil
+}
+
+// returns scheduling profile for pod, nil if rules have no scheduling profiles.
+func (p *Patch) GetProfile(containerInfo *types.ContainerInfo) (*types.SchedulingProfile, error) {
+	if containerInfo.PodContainer == nil || containerInfo.PodContainer.Pod == nil {
+		return nil, nil //nolint:nilnil
+	}
+
+	pod := containerInfo.PodContainer.Pod
+
+	for _, selectedRule := range containerInfo.SelectedRules {
+		if !selectedRule.SchedulingProfiles.Enabled {
+			continue
+		}
+
+		selectedRule.Logf("CreateSchedulingProfile: %+v", selectedRule.SchedulingProfiles)
+
+		profiles := selectedRule.SchedulingProfiles.Profiles
+
+		// pod was already assigned to profile
+		if name, ok := pod.Labels[types.LabelSchedulingProfile]; ok {
+			if profile := getProfileByName(profiles, name); profile != nil {
+				return profile, nil
+			}
+		}
+
+		counts, err := getProfileCounts(containerInfo.Namespace, pod)
+		if err != nil {
+			return nil, errors.Wrap(err, "error counting owner pods")
+		}
+
+		selectedRule.Logf("CreateSchedulingProfile: counts=%v", counts)
+
+		return assignProfile(pod, profiles, counts), nil
+	}
+
+	return nil, nil //nolint:nilnil
+}
+
+func getProfileByName(profiles []types.SchedulingProfile, name string) *types.SchedulingProfile {
+	for i := range profiles {
+		if profiles[i].Name == name {
+			return &profiles[i]
+		}
+	}
+
+	return nil
+}
+
+// select profile that has the biggest deficit of pods to weighted target.
+func SelectProfile(profiles []types.SchedulingProfile, counts map[string]int) *types.SchedulingProfile {
+	totalWeight := 0
+	// new pod is also counted
+	totalPods := 1
+
+	for _, profile := range profiles {
+		totalWeight += profile.Weight
+		totalPods += counts[profile.Name]
+	}
+
+	if totalWeight == 0 {
+		return nil
+	}
+
+	var (
+		result     *types.SchedulingProfile
+		maxDeficit float64
+	)
+
+	for i, profile := range profiles {
+		if profile.Weight == 0 {
+			continue
+		}
+
+		target := float64(totalPods*profile.Weight) / float64(totalWeight)
+		deficit := target - float64(counts[profile.Name])
+
+		if result == nil || deficit > maxDeficit {
+			result = &profiles[i]
+			maxDeficit = deficit
+		}
+	}
+
+	return result
+}
+
+// count running pods of the same owner by profiles.
+func getProfileCounts(namespace string, pod *corev1.Pod) (map[string]int, error) {
+	result := make(map[string]int)
+
+	owner := metav1.GetControllerOf(pod)
+
+	if owner == nil || len(namespace) == 0 || client.Informers() == nil {
+		return result, nil
+	}
+
+	requirement, err := labels.NewRequirement(types.LabelSchedulingProfile, selection.Exists, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating label requirement")
+	}
+
+	ownerPods, err := client.Informers().Core().V1().Pods().Lister().Pods(namespace).List(labels.NewSelector().Add(*requirement)) //nolint:lll
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing pods")
+	}
+
+	for _, ownerPod := range ownerPods {
+		// terminating pods will be replaced
+		if ownerPod.DeletionTimestamp != nil || ownerPod.Status.Phase == corev1.PodSucceeded || ownerPod.Status.Phase == corev1.PodFailed { //nolint:lll
+			continue
+		}
+
+		if podOwner := metav1.GetControllerOf(ownerPod); podOwner == nil || podOwner.UID != owner.UID {
+			continue
+		}
+
+		result[ownerPod.Labels[types.LabelSchedulingProfile]]++
+	}
+
+	return result, nil
+}
+
+func mergeAffinity(podAffinity *corev1.Affinity, profile *types.SchedulingProfile) *corev1.Affinity {
+	result := affinity.MergePreferredNodeAffinity(podAffinity, profile.PreferredNodeAffinity)
+
+	return affinity.MergeRequiredNodeAffinity(result, profile.RequiredNodeAffinity)
+}
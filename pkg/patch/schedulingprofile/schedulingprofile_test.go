@@ -0,0 +1,246 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package schedulingprofile_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/client"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/schedulingprofile"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var testProfiles = []types.SchedulingProfile{
+	{
+		Name:   "spot",
+		Weight: 70,
+		Tolerations: []corev1.Toleration{
+			{Key: "spot", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule},
+		},
+		RequiredNodeAffinity: []corev1.NodeSelectorRequirement{
+			{Key: "node.kubernetes.io/lifecycle", Operator: corev1.NodeSelectorOpIn, Values: []string{"spot"}},
+		},
+	},
+	{
+		Name:   "on-demand",
+		Weight: 30,
+		RequiredNodeAffinity: []corev1.NodeSelectorRequirement{
+			{Key: "node.kubernetes.io/lifecycle", Operator: corev1.NodeSelectorOpIn, Values: []string{"on-demand"}},
+		},
+	},
+}
+
+func TestSelectProfile(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		counts map[string]int
+		want   string
+	}{
+		{counts: map[string]int{}, want: "spot"},
+		{counts: map[string]int{"spot": 1}, want: "on-demand"},
+		{counts: map[string]int{"spot": 1, "on-demand": 1}, want: "spot"},
+		{counts: map[string]int{"spot": 7, "on-demand": 2}, want: "on-demand"},
+		{counts: map[string]int{"spot": 0, "on-demand": 5}, want: "spot"},
+	}
+
+	for _, tc := range tests {
+		if got := schedulingprofile.SelectProfile(testProfiles, tc.counts); got.Name != tc.want {
+			t.Fatalf("counts %v: want %s, got %s", tc.counts, tc.want, got.Name)
+		}
+	}
+
+	// pods converge to weights
+	counts := make(map[string]int)
+
+	for range 10 {
+		counts[schedulingprofile.SelectProfile(testProfiles, counts).Name]++
+	}
+
+	if counts["spot"] != 7 || counts["on-demand"] != 3 {
+		t.Fatalf("pods are not split by weights %v", counts)
+	}
+}
+
+func newPod(name, profile string, owner metav1.OwnerReference) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "test",
+			Labels:          map[string]string{"app": "test"},
+			OwnerReferences: []metav1.OwnerReference{owner},
+		},
+	}
+
+	if len(profile) > 0 {
+		pod.Labels[types.LabelSchedulingProfile] = profile
+	}
+
+	return pod
+}
+
+// test uses global informers.
+func TestSchedulingProfile(t *testing.T) { //nolint:funlen,paralleltest
+	owner := metav1.OwnerReference{
+		APIVersion: "apps/v1",
+		Kind:       "ReplicaSet",
+		Name:       "test",
+		UID:        "test-uid",
+		Controller: utils.Pnt(true),
+	}
+
+	otherOwner := *owner.DeepCopy()
+	otherOwner.UID = "other-uid"
+
+	clientset := fake.NewClientset(
+		newPod("spot-1", "spot", owner),
+		newPod("spot-2", "spot", owner),
+		newPod("on-demand-1", "on-demand", otherOwner),
+	)
+
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	factory.Core().V1().Pods().Informer()
+	factory.Start(t.Context().Done())
+	factory.WaitForCacheSync(t.Context().Done())
+
+	client.SetInformers(factory)
+	t.Cleanup(func() { client.SetInformers(nil) })
+
+	containerInfo := &types.ContainerInfo{
+		Namespace: "test",
+		PodContainer: &types.PodContainer{
+			Type:      "container",
+			Container: &corev1.Container{},
+			Pod:       newPod("", "", owner),
+		},
+		SelectedRules: []*types.Rule{
+			{
+				Tolerations: []corev1.Toleration{
+					{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "test"},
+				},
+				SchedulingProfiles: types.SchedulingProfiles{
+					Enabled:  true,
+					Profiles: testProfiles,
+				},
+			},
+		},
+	}
+
+	patchOps, err := (&schedulingprofile.Patch{}).Create(t.Context(), containerInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(patchOps) != 2 {
+		t.Fatalf("2 patches must be created, got %+v", patchOps)
+	}
+
+	if patchOps[0].Path != "/metadata/labels/pod-admission-controller~1scheduling-profile" || patchOps[0].Value != "on-demand" {
+		t.Fatalf("pod must be assigned to on-demand profile, got %+v", patchOps[0])
+	}
+
+	podAffinity, ok := patchOps[1].Value.(*corev1.Affinity)
+	if !ok {
+		t.Fatalf("not corrected patch value type %T", patchOps[1].Value)
+	}
+
+	expression := podAffinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions[0] //nolint:lll
+	if got := fmt.Sprint(expression.Values); got != "[on-demand]" {
+		t.Fatalf("not corrected node affinity %s", got)
+	}
+
+	// pod that was assigned to profile keeps it
+	containerInfo.PodContainer.Pod = newPod("", "spot", owner)
+
+	patchOps, err = (&schedulingprofile.Patch{}).Create(t.Context(), containerInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(patchOps) != 3 {
+		t.Fatalf("3 patches must be created, got %+v", patchOps)
+	}
+
+	podTolerations, ok := patchOps[1].Value.([]corev1.Toleration)
+	if !ok || len(podTolerations) != 2 || podTolerations[0].Key != "dedicated" || podTolerations[1].Key != "spot" {
+		t.Fatalf("rule tolerations must be merged with profile tolerations, got %+v", patchOps[1])
+	}
+}
+
+// test uses global informers.
+func TestSchedulingProfileScaleUp(t *testing.T) { //nolint:paralleltest
+	owner := metav1.OwnerReference{
+		APIVersion: "apps/v1",
+		Kind:       "ReplicaSet",
+		Name:       "scale-up",
+		UID:        "scale-up-uid",
+		Controller: utils.Pnt(true),
+	}
+
+	// informer does not see new pods
+	factory := informers.NewSharedInformerFactory(fake.NewClientset(), 0)
+	factory.Core().V1().Pods().Informer()
+	factory.Start(t.Context().Done())
+	factory.WaitForCacheSync(t.Context().Done())
+
+	client.SetInformers(factory)
+	t.Cleanup(func() { client.SetInformers(nil) })
+
+	counts := make(map[string]int)
+
+	for range 10 {
+		containerInfo := &types.ContainerInfo{
+			Namespace: "test",
+			PodContainer: &types.PodContainer{
+				Type:      "container",
+				Container: &corev1.Container{},
+				Pod:       newPod("", "", owner),
+			},
+			SelectedRules: []*types.Rule{
+				{
+					SchedulingProfiles: types.SchedulingProfiles{
+						Enabled:  true,
+						Profiles: testProfiles,
+					},
+				},
+			},
+		}
+
+		profile, err := (&schedulingprofile.Patch{}).GetProfile(containerInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// profile is selected once for pod
+		again, err := (&schedulingprofile.Patch{}).GetProfile(containerInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if again.Name != profile.Name {
+			t.Fatalf("pod profile changed from %s to %s", profile.Name, again.Name)
+		}
+
+		counts[profile.Name]++
+	}
+
+	if counts["spot"] != 7 || counts["on-demand"] != 3 {
+		t.Fatalf("pods are not split by weights %v", counts)
+	}
+}
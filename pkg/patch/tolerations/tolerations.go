@@ -14,23 +14,68 @@ package tolerations
 
 import (
 	"context"
+	"encoding/json"
+	"slices"
 
 	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
 )
 
+const patchName = "tolerations"
+
 type Patch struct{}
 
+// returns pod tolerations that will be after patch.
+func (p *Patch) GetFinalTolerations(containerInfo *types.ContainerInfo) ([]corev1.Toleration, error) {
+	podTolerations := getPodTolerations(containerInfo)
+
+	if containerInfo.IgnorePatch(patchName) {
+		return podTolerations, nil
+	}
+
+	patchOps, err := p.Create(context.Background(), containerInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, patchOp := range patchOps {
+		if tolerations, ok := patchOp.Value.([]corev1.Toleration); ok {
+			return tolerations, nil
+		}
+	}
+
+	return podTolerations, nil
+}
+
+// replace pod tolerations with rule tolerations, namespace default tolerations are added to them.
 func (p *Patch) Create(_ context.Context, containerInfo *types.ContainerInfo) ([]types.PatchOperation, error) {
-	podTolerations := make([]corev1.Toleration, 0)
+	ruleTolerations := make([]corev1.Toleration, 0)
 
 	for _, rule := range containerInfo.SelectedRules {
 		if len(rule.Tolerations) > 0 {
-			podTolerations = append(podTolerations, rule.Tolerations...)
+			ruleTolerations = append(ruleTolerations, rule.Tolerations...)
 		}
 	}
 
-	if len(podTolerations) == 0 {
+	result := ruleTolerations
+	if len(ruleTolerations) == 0 {
+		result = slices.Clone(getPodTolerations(containerInfo))
+	}
+
+	tolerationsCount := len(result)
+
+	if containerInfo.NamespaceSchedulingEnabled() {
+		defaultTolerations, err := GetDefaultTolerations(containerInfo)
+		if err != nil {
+			return nil, err
+		}
+
+		result = MergeTolerations(result, defaultTolerations)
+	}
+
+	// pod tolerations are not changed
+	if len(ruleTolerations) == 0 && len(result) == tolerationsCount {
 		return []types.PatchOperation{}, nil
 	}
 
@@ -38,7 +83,51 @@ func (p *Patch) Create(_ context.Context, containerInfo *types.ContainerInfo) ([
 		{
 			Op:    "add",
 			Path:  "/spec/tolerations",
-			Value: podTolerations,
+			Value: result,
 		},
 	}, nil
 }
+
+// returns default tolerations from namespace annotation, pod annotation is not used
+// because pod can not change namespace restrictions.
+func GetDefaultTolerations(containerInfo *types.ContainerInfo) ([]corev1.Toleration, error) {
+	value, ok := containerInfo.NamespaceAnnotations[types.AnnotationDefaultTolerations]
+	if !ok {
+		return nil, nil
+	}
+
+	return ParseTolerations(value)
+}
+
+func ParseTolerations(value string) ([]corev1.Toleration, error) {
+	tolerations := make([]corev1.Toleration, 0)
+
+	if err := json.Unmarshal([]byte(value), &tolerations); err != nil {
+		return nil, errors.Wrapf(err, "error parsing tolerations %s", value)
+	}
+
+	return tolerations, nil
+}
+
+// add default tolerations that do not conflict with pod tolerations with the same key and effect.
+func MergeTolerations(podTolerations, defaultTolerations []corev1.Toleration) []corev1.Toleration {
+	result := slices.Clone(podTolerations)
+
+	for _, toleration := range defaultTolerations {
+		if !slices.ContainsFunc(podTolerations, func(podToleration corev1.Toleration) bool {
+			return podToleration.Key == toleration.Key && podToleration.Effect == toleration.Effect
+		}) {
+			result = append(result, toleration)
+		}
+	}
+
+	return result
+}
+
+func getPodTolerations(containerInfo *types.ContainerInfo) []corev1.Toleration {
+	if containerInfo.PodContainer == nil || containerInfo.PodContainer.Pod == nil {
+		return nil
+	}
+
+	return containerInfo.PodContainer.Pod.Spec.Tolerations
+}
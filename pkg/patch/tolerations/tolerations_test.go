@@ -13,6 +13,7 @@ limitations under the License.
 package tolerations_test
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/tolerations"
@@ -23,16 +24,23 @@ import (
 func TestTolerations(t *testing.T) {
 	t.Parallel()
 
+	ruleToleration := corev1.Toleration{
+		Key:      "key",
+		Operator: "Equal",
+		Value:    "value",
+	}
+
 	containerInfo := &types.ContainerInfo{
+		PodContainer: &types.PodContainer{
+			Pod: &corev1.Pod{
+				Spec: corev1.PodSpec{
+					Tolerations: []corev1.Toleration{{Key: "pod", Operator: corev1.TolerationOpExists}},
+				},
+			},
+		},
 		SelectedRules: []*types.Rule{
 			{
-				Tolerations: []corev1.Toleration{
-					{
-						Key:      "key",
-						Operator: "Equal",
-						Value:    "value",
-					},
-				},
+				Tolerations: []corev1.Toleration{ruleToleration},
 			},
 		},
 	}
@@ -51,4 +59,45 @@ func TestTolerations(t *testing.T) {
 	if patchOps[0].Op != "add" || patchOps[0].Path != "/spec/tolerations" {
 		t.Fatalf("not corrected patch %s", patchOps[0].String())
 	}
+
+	// rule tolerations replace pod tolerations
+	if want := []corev1.Toleration{ruleToleration}; !reflect.DeepEqual(patchOps[0].Value, want) {
+		t.Fatalf("want %+v, got %+v", want, patchOps[0].Value)
+	}
+}
+
+func TestNamespaceDefaultTolerations(t *testing.T) {
+	t.Parallel()
+
+	podToleration := corev1.Toleration{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "pod", Effect: corev1.TaintEffectNoSchedule} //nolint:lll
+	gpuToleration := corev1.Toleration{Key: "gpu", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}
+
+	containerInfo := &types.ContainerInfo{
+		NamespaceAnnotations: map[string]string{
+			types.AnnotationDefaultTolerations: `[{"key":"dedicated","operator":"Equal","value":"namespace","effect":"NoSchedule"},{"key":"gpu","operator":"Exists","effect":"NoSchedule"}]`, //nolint:lll
+		},
+		PodAnnotations: map[string]string{
+			// pod can not change namespace default tolerations
+			types.AnnotationDefaultTolerations: `[{"key":"team","operator":"Exists"}]`,
+		},
+		PodContainer: &types.PodContainer{
+			Pod: &corev1.Pod{
+				Spec: corev1.PodSpec{Tolerations: []corev1.Toleration{podToleration}},
+			},
+		},
+		SelectedRules: []*types.Rule{
+			{NamespaceScheduling: types.NamespaceScheduling{Enabled: true}},
+		},
+	}
+
+	patchOps, err := (&tolerations.Patch{}).Create(t.Context(), containerInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []corev1.Toleration{podToleration, gpuToleration}
+
+	if len(patchOps) != 1 || !reflect.DeepEqual(patchOps[0].Value, want) {
+		t.Fatalf("pod toleration must be kept, want %+v, got %+v", want, patchOps)
+	}
 }
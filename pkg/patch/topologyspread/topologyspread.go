@@ -20,31 +20,43 @@ import (
 	"github.com/maksim-paskal/pod-admission-controller/pkg/template"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
 	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-var podLabelsIgnore = []string{
-	"pod-template-hash",
-	"controller-revision-hash",
-	"statefulset.kubernetes.io/pod-name",
-	"apps.kubernetes.io/pod-index",
-}
+const patchName = "topologyspread"
 
 type Patch struct{}
 
-func (p *Patch) Create(_ context.Context, containerInfo *types.ContainerInfo) ([]types.PatchOperation, error) {
-	patch := make([]types.PatchOperation, 0)
+// returns pod topology spread constraints that will be after patch.
+func (p *Patch) GetFinalConstraints(ctx context.Context, containerInfo *types.ContainerInfo) ([]corev1.TopologySpreadConstraint, error) { //nolint:lll
+	var podConstraints []corev1.TopologySpreadConstraint
 
-	podLabels := map[string]string{}
+	if containerInfo.PodContainer != nil && containerInfo.PodContainer.Pod != nil {
+		podConstraints = containerInfo.PodContainer.Pod.Spec.TopologySpreadConstraints
+	}
 
-	for key, value := range containerInfo.PodLabels {
-		if slices.Contains(podLabelsIgnore, key) {
-			continue
-		}
+	if containerInfo.IgnorePatch(patchName) {
+		return podConstraints, nil
+	}
 
-		podLabels[key] = value
+	patchOps, err := p.Create(ctx, containerInfo)
+	if err != nil {
+		return nil, err
 	}
 
+	for _, patchOp := range patchOps {
+		if constraints, ok := patchOp.Value.([]corev1.TopologySpreadConstraint); ok {
+			return constraints, nil
+		}
+	}
+
+	return podConstraints, nil
+}
+
+func (p *Patch) Create(_ context.Context, containerInfo *types.ContainerInfo) ([]types.PatchOperation, error) {
+	patch := make([]types.PatchOperation, 0)
+
 	for _, selectedRule := range containerInfo.SelectedRules {
 		if !selectedRule.AddTopologySpread.Enabled {
 			continue
@@ -66,17 +78,40 @@ func (p *Patch) Create(_ context.Context, containerInfo *types.ContainerInfo) ([
 			return nil, errors.Wrap(err, "error unmarshal topologySpreadConstraints")
 		}
 
-		for i := range topologySpreadConstraints {
-			topologySpreadConstraints[i].LabelSelector = &metav1.LabelSelector{
-				MatchLabels: podLabels,
+		podConstraints := make([]corev1.TopologySpreadConstraint, 0)
+
+		if pod := containerInfo.PodContainer.Pod; pod != nil {
+			podConstraints = pod.Spec.TopologySpreadConstraints
+		}
+
+		newConstraints := make([]corev1.TopologySpreadConstraint, 0)
+
+		for _, constraint := range topologySpreadConstraints {
+			// pod constraint for the same topology key is kept
+			if slices.ContainsFunc(podConstraints, func(podConstraint corev1.TopologySpreadConstraint) bool {
+				return podConstraint.TopologyKey == constraint.TopologyKey
+			}) {
+				selectedRule.Logf("CreateTopologySpread: pod has constraint for %s", constraint.TopologyKey)
+
+				continue
+			}
+
+			if constraint.LabelSelector == nil {
+				constraint.LabelSelector = &metav1.LabelSelector{
+					MatchLabels: p.getPodLabels(containerInfo, selectedRule.AddTopologySpread.LabelKeys, constraint.MatchLabelKeys), //nolint:lll
+				}
 			}
+
+			newConstraints = append(newConstraints, constraint)
 		}
 
-		patch = append(patch, types.PatchOperation{
-			Op:    "add",
-			Path:  "/spec/topologySpreadConstraints",
-			Value: topologySpreadConstraints,
-		})
+		if len(newConstraints) > 0 {
+			patch = append(patch, types.PatchOperation{
+				Op:    "add",
+				Path:  "/spec/topologySpreadConstraints",
+				Value: slices.Concat(podConstraints, newConstraints),
+			})
+		}
 
 		// only one rule can be applied
 		break
@@ -84,3 +119,14 @@ func (p *Patch) Create(_ context.Context, containerInfo *types.ContainerInfo) ([
 
 	return patch, nil
 }
+
+// pod labels for label selector, keys from matchLabelKeys can not be in label selector.
+func (p *Patch) getPodLabels(containerInfo *types.ContainerInfo, labelKeys, matchLabelKeys []string) map[string]string {
+	podLabels := containerInfo.GetWorkloadLabels(labelKeys)
+
+	for _, key := range matchLabelKeys {
+		delete(podLabels, key)
+	}
+
+	return podLabels
+}
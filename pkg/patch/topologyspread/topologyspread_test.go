@@ -13,10 +13,12 @@ limitations under the License.
 package topologyspread_test
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/topologyspread"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/utils"
 	corev1 "k8s.io/api/core/v1"
 )
 
@@ -87,3 +89,109 @@ func TestLifeCyclePatch(t *testing.T) { //nolint:funlen
 		t.Fatalf("template value not rendered %s", got)
 	}
 }
+
+func TestMergeTopologySpread(t *testing.T) { //nolint:funlen
+	t.Parallel()
+
+	patch := topologyspread.Patch{}
+
+	podConstraint := corev1.TopologySpreadConstraint{
+		MaxSkew:           2,
+		TopologyKey:       corev1.LabelHostname,
+		WhenUnsatisfiable: corev1.ScheduleAnyway,
+	}
+
+	nodeAffinityPolicy := corev1.NodeInclusionPolicyHonor
+
+	containerInfo := &types.ContainerInfo{
+		PodLabels: map[string]string{
+			"app":               "test",
+			"version":           "v1",
+			"pod-template-hash": "123",
+		},
+		PodContainer: &types.PodContainer{
+			Type:      "container",
+			Container: &corev1.Container{},
+			Pod: &corev1.Pod{
+				Spec: corev1.PodSpec{
+					TopologySpreadConstraints: []corev1.TopologySpreadConstraint{podConstraint},
+				},
+			},
+		},
+		SelectedRules: []*types.Rule{
+			{
+				AddTopologySpread: types.AddTopologySpread{
+					Enabled:   true,
+					LabelKeys: []string{"app", "pod-template-hash"},
+					TopologySpreadConstraints: []corev1.TopologySpreadConstraint{
+						{
+							MaxSkew:           1,
+							TopologyKey:       corev1.LabelHostname,
+							WhenUnsatisfiable: corev1.DoNotSchedule,
+						},
+						{
+							MaxSkew:            1,
+							TopologyKey:        corev1.LabelTopologyZone,
+							WhenUnsatisfiable:  corev1.DoNotSchedule,
+							MatchLabelKeys:     []string{"pod-template-hash"},
+							MinDomains:         utils.Pnt(int32(3)),
+							NodeAffinityPolicy: &nodeAffinityPolicy,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	patchOps, err := patch.Create(t.Context(), containerInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(patchOps) != 1 {
+		t.Fatal("1 patch must be created")
+	}
+
+	constraints, ok := patchOps[0].Value.([]corev1.TopologySpreadConstraint)
+	if !ok {
+		t.Fatalf("not corrected patch value type %T", patchOps[0].Value)
+	}
+
+	if len(constraints) != 2 {
+		t.Fatalf("pod constraint must be merged with zone constraint, got %+v", constraints)
+	}
+
+	if !reflect.DeepEqual(constraints[0], podConstraint) {
+		t.Fatalf("pod constraint must be kept, got %+v", constraints[0])
+	}
+
+	zone := constraints[1]
+
+	if zone.TopologyKey != corev1.LabelTopologyZone {
+		t.Fatalf("not corrected topology key %s", zone.TopologyKey)
+	}
+
+	if !reflect.DeepEqual(zone.LabelSelector.MatchLabels, map[string]string{"app": "test"}) {
+		t.Fatalf("not corrected label selector %+v", zone.LabelSelector.MatchLabels)
+	}
+
+	if *zone.MinDomains != 3 || *zone.NodeAffinityPolicy != corev1.NodeInclusionPolicyHonor {
+		t.Fatalf("minDomains and nodeAffinityPolicy must be kept, got %+v", zone)
+	}
+
+	if !reflect.DeepEqual(zone.MatchLabelKeys, []string{"pod-template-hash"}) {
+		t.Fatalf("matchLabelKeys must be kept, got %+v", zone.MatchLabelKeys)
+	}
+
+	// all topology keys are in pod
+	containerInfo.PodContainer.Pod.Spec.TopologySpreadConstraints = constraints
+
+	patchOps, err = patch.Create(t.Context(), containerInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(patchOps) != 0 {
+		t.Fatalf("patch must not be created, got %+v", patchOps)
+	}
+}
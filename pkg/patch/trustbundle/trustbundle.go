@@ -0,0 +1,170 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package trustbundle
+
+import (
+	"context"
+	"slices"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/env"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/runtimeenv"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const volumeName = "pod-admission-controller-trust-bundle"
+
+// env that runtimes use to read ca bundle.
+var bundleEnv = []string{"SSL_CERT_FILE", "NODE_EXTRA_CA_CERTS", "REQUESTS_CA_BUNDLE"}
+
+type Patch struct{}
+
+// mount ca bundle from configmap to container and set env with bundle path.
+func (p *Patch) Create(ctx context.Context, containerInfo *types.ContainerInfo) ([]types.PatchOperation, error) {
+	podContainer := containerInfo.PodContainer
+	if podContainer == nil || podContainer.Pod == nil || podContainer.Container == nil {
+		return []types.PatchOperation{}, nil
+	}
+
+	for _, selectedRule := range containerInfo.SelectedRules {
+		trustBundle := selectedRule.TrustBundle
+
+		if !trustBundle.Enabled {
+			continue
+		}
+
+		selectedRule.Logf("CreateTrustBundle: %+v", trustBundle)
+
+		pod := podContainer.Pod
+		container := podContainer.Container
+		containerPath := podContainer.ContainerPath()
+
+		// pod can already have volume with bundle from the same configmap
+		bundleVolumeName, subPath, volumeExists := getVolume(pod, trustBundle.ConfigMapName, trustBundle.GetKey())
+
+		volumeMounts := make([]corev1.VolumeMount, 0)
+
+		for _, mountPath := range trustBundle.GetMountPaths() {
+			// container mounts own file
+			if slices.ContainsFunc(container.VolumeMounts, func(volumeMount corev1.VolumeMount) bool {
+				return volumeMount.MountPath == mountPath
+			}) {
+				continue
+			}
+
+			volumeMounts = append(volumeMounts, corev1.VolumeMount{
+				Name:      bundleVolumeName,
+				MountPath: mountPath,
+				SubPath:   subPath,
+				ReadOnly:  true,
+			})
+		}
+
+		// container mounts own files at all paths
+		if len(volumeMounts) == 0 {
+			return []types.PatchOperation{}, nil
+		}
+
+		patchOps := make([]types.PatchOperation, 0)
+
+		if !volumeExists {
+			volume := corev1.Volume{
+				Name: bundleVolumeName,
+				VolumeSource: corev1.VolumeSource{
+					ConfigMap: &corev1.ConfigMapVolumeSource{
+						LocalObjectReference: corev1.LocalObjectReference{Name: trustBundle.ConfigMapName},
+					},
+				},
+			}
+
+			if len(pod.Spec.Volumes) == 0 {
+				patchOps = append(patchOps, types.PatchOperation{
+					Op:    "add",
+					Path:  "/spec/volumes",
+					Value: []corev1.Volume{volume},
+				})
+			} else {
+				patchOps = append(patchOps, types.PatchOperation{
+					Op:    "add",
+					Path:  "/spec/volumes/-",
+					Value: volume,
+				})
+			}
+		}
+
+		if len(container.VolumeMounts) == 0 && len(volumeMounts) > 0 {
+			patchOps = append(patchOps, types.PatchOperation{
+				Op:    "add",
+				Path:  containerPath + "/volumeMounts",
+				Value: volumeMounts,
+			})
+		} else {
+			for _, volumeMount := range volumeMounts {
+				patchOps = append(patchOps, types.PatchOperation{
+					Op:    "add",
+					Path:  containerPath + "/volumeMounts/-",
+					Value: volumeMount,
+				})
+			}
+		}
+
+		// env points to file with bundle
+		envOps, err := p.createEnvPatch(ctx, containerInfo, volumeMounts[0].MountPath)
+		if err != nil {
+			return nil, err
+		}
+
+		return append(patchOps, envOps...), nil
+	}
+
+	return []types.PatchOperation{}, nil
+}
+
+func (p *Patch) createEnvPatch(ctx context.Context, containerInfo *types.ContainerInfo, bundlePath string) ([]types.PatchOperation, error) { //nolint:lll
+	// env that are added by env and runtimeenv patches are not changed
+	finalEnv, err := (&runtimeenv.Patch{}).GetFinalEnv(ctx, containerInfo)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting container env")
+	}
+
+	newEnv := make([]corev1.EnvVar, 0, len(bundleEnv))
+
+	for _, name := range bundleEnv {
+		newEnv = append(newEnv, corev1.EnvVar{Name: name, Value: bundlePath})
+	}
+
+	return env.CreateEnvPatch(containerInfo, finalEnv, newEnv), nil
+}
+
+// returns pod volume and subPath of bundle in volume, or new volume if pod does not have volume with bundle.
+func getVolume(pod *corev1.Pod, configMapName, key string) (string, string, bool) {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.ConfigMap == nil || volume.ConfigMap.Name != configMapName {
+			continue
+		}
+
+		// volume has all configmap keys
+		if len(volume.ConfigMap.Items) == 0 {
+			return volume.Name, key, true
+		}
+
+		for _, item := range volume.ConfigMap.Items {
+			if item.Key == key {
+				return volume.Name, item.Path, true
+			}
+		}
+	}
+
+	return volumeName, key, false
+}
@@ -0,0 +1,241 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package trustbundle_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/trustbundle"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestTrustBundle(t *testing.T) { //nolint:funlen
+	t.Parallel()
+
+	ruleTrustBundle := types.TrustBundle{
+		Enabled:       true,
+		ConfigMapName: "ca-bundle",
+		MountPaths:    []string{"/etc/ssl/certs/ca-certificates.crt", "/etc/pki/tls/certs/ca-bundle.crt"},
+	}
+
+	bundleVolume := corev1.Volume{
+		Name: "pod-admission-controller-trust-bundle",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "ca-bundle"},
+			},
+		},
+	}
+
+	bundleMount := func(name, mountPath string) corev1.VolumeMount {
+		return corev1.VolumeMount{
+			Name:      name,
+			MountPath: mountPath,
+			SubPath:   "ca-certificates.crt",
+			ReadOnly:  true,
+		}
+	}
+
+	bundleEnv := []corev1.EnvVar{
+		{Name: "SSL_CERT_FILE", Value: "/etc/ssl/certs/ca-certificates.crt"},
+		{Name: "NODE_EXTRA_CA_CERTS", Value: "/etc/ssl/certs/ca-certificates.crt"},
+		{Name: "REQUESTS_CA_BUNDLE", Value: "/etc/ssl/certs/ca-certificates.crt"},
+	}
+
+	tests := []struct {
+		name        string
+		pod         *corev1.Pod
+		annotations map[string]string
+		want        []types.PatchOperation
+	}{
+		{
+			name: "pod without volumes",
+			pod: &corev1.Pod{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "test"}}},
+			},
+			want: []types.PatchOperation{
+				{Op: "add", Path: "/spec/volumes", Value: []corev1.Volume{bundleVolume}},
+				{Op: "add", Path: "/spec/containers/0/volumeMounts", Value: []corev1.VolumeMount{
+					bundleMount("pod-admission-controller-trust-bundle", "/etc/ssl/certs/ca-certificates.crt"),
+					bundleMount("pod-admission-controller-trust-bundle", "/etc/pki/tls/certs/ca-bundle.crt"),
+				}},
+				{Op: "add", Path: "/spec/containers/0/env", Value: bundleEnv},
+			},
+		},
+		{
+			name: "pod with configmap volume and mounts",
+			pod: &corev1.Pod{
+				Spec: corev1.PodSpec{
+					Volumes: []corev1.Volume{{
+						Name: "certs",
+						VolumeSource: corev1.VolumeSource{
+							ConfigMap: &corev1.ConfigMapVolumeSource{
+								LocalObjectReference: corev1.LocalObjectReference{Name: "ca-bundle"},
+							},
+						},
+					}},
+					Containers: []corev1.Container{{
+						Name: "test",
+						VolumeMounts: []corev1.VolumeMount{
+							{Name: "certs", MountPath: "/etc/ssl/certs/ca-certificates.crt"},
+						},
+						Env: []corev1.EnvVar{{Name: "SSL_CERT_FILE", Value: "/custom.crt"}},
+					}},
+				},
+			},
+			want: []types.PatchOperation{
+				{
+					Op:    "add",
+					Path:  "/spec/containers/0/volumeMounts/-",
+					Value: bundleMount("certs", "/etc/pki/tls/certs/ca-bundle.crt"),
+				},
+				// env points to path where bundle is mounted
+				{Op: "add", Path: "/spec/containers/0/env/-", Value: corev1.EnvVar{
+					Name:  "NODE_EXTRA_CA_CERTS",
+					Value: "/etc/pki/tls/certs/ca-bundle.crt",
+				}},
+				{Op: "add", Path: "/spec/containers/0/env/-", Value: corev1.EnvVar{
+					Name:  "REQUESTS_CA_BUNDLE",
+					Value: "/etc/pki/tls/certs/ca-bundle.crt",
+				}},
+			},
+		},
+		{
+			name: "configmap volume with other items",
+			pod: &corev1.Pod{
+				Spec: corev1.PodSpec{
+					Volumes: []corev1.Volume{{
+						Name: "certs",
+						VolumeSource: corev1.VolumeSource{
+							ConfigMap: &corev1.ConfigMapVolumeSource{
+								LocalObjectReference: corev1.LocalObjectReference{Name: "ca-bundle"},
+								Items:                []corev1.KeyToPath{{Key: "other.crt", Path: "other.crt"}},
+							},
+						},
+					}},
+					Containers: []corev1.Container{{Name: "test"}},
+				},
+			},
+			want: []types.PatchOperation{
+				{Op: "add", Path: "/spec/volumes/-", Value: bundleVolume},
+				{Op: "add", Path: "/spec/containers/0/volumeMounts", Value: []corev1.VolumeMount{
+					bundleMount("pod-admission-controller-trust-bundle", "/etc/ssl/certs/ca-certificates.crt"),
+					bundleMount("pod-admission-controller-trust-bundle", "/etc/pki/tls/certs/ca-bundle.crt"),
+				}},
+				{Op: "add", Path: "/spec/containers/0/env", Value: bundleEnv},
+			},
+		},
+		{
+			name: "configmap volume with bundle item",
+			pod: &corev1.Pod{
+				Spec: corev1.PodSpec{
+					Volumes: []corev1.Volume{{
+						Name: "certs",
+						VolumeSource: corev1.VolumeSource{
+							ConfigMap: &corev1.ConfigMapVolumeSource{
+								LocalObjectReference: corev1.LocalObjectReference{Name: "ca-bundle"},
+								Items:                []corev1.KeyToPath{{Key: "ca-certificates.crt", Path: "bundle.pem"}},
+							},
+						},
+					}},
+					Containers: []corev1.Container{{
+						Name: "test",
+						VolumeMounts: []corev1.VolumeMount{
+							{Name: "certs", MountPath: "/etc/pki/tls/certs/ca-bundle.crt"},
+						},
+					}},
+				},
+			},
+			want: []types.PatchOperation{
+				{Op: "add", Path: "/spec/containers/0/volumeMounts/-", Value: corev1.VolumeMount{
+					Name:      "certs",
+					MountPath: "/etc/ssl/certs/ca-certificates.crt",
+					SubPath:   "bundle.pem",
+					ReadOnly:  true,
+				}},
+				{Op: "add", Path: "/spec/containers/0/env", Value: bundleEnv},
+			},
+		},
+		{
+			name: "container mounts own files",
+			pod: &corev1.Pod{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name: "test",
+						VolumeMounts: []corev1.VolumeMount{
+							{Name: "certs", MountPath: "/etc/ssl/certs/ca-certificates.crt"},
+							{Name: "certs", MountPath: "/etc/pki/tls/certs/ca-bundle.crt"},
+						},
+					}},
+				},
+			},
+			want: []types.PatchOperation{},
+		},
+		{
+			name: "pod with other volumes",
+			pod: &corev1.Pod{
+				Spec: corev1.PodSpec{
+					Volumes:    []corev1.Volume{{Name: "data"}},
+					Containers: []corev1.Container{{Name: "test"}},
+				},
+			},
+			want: []types.PatchOperation{
+				{Op: "add", Path: "/spec/volumes/-", Value: bundleVolume},
+				{Op: "add", Path: "/spec/containers/0/volumeMounts", Value: []corev1.VolumeMount{
+					bundleMount("pod-admission-controller-trust-bundle", "/etc/ssl/certs/ca-certificates.crt"),
+					bundleMount("pod-admission-controller-trust-bundle", "/etc/pki/tls/certs/ca-bundle.crt"),
+				}},
+				{Op: "add", Path: "/spec/containers/0/env", Value: bundleEnv},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			containerInfo := &types.ContainerInfo{
+				ContainerName: "test",
+				PodContainer: &types.PodContainer{
+					Pod:       tc.pod,
+					Type:      types.PodContainerTypeContainer,
+					Container: &tc.pod.Spec.Containers[0],
+				},
+				SelectedRules: []*types.Rule{{TrustBundle: ruleTrustBundle}},
+			}
+
+			patchOps, err := (&trustbundle.Patch{}).Create(t.Context(), containerInfo)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(patchOps, tc.want) {
+				t.Fatalf("want %+v, got %+v", tc.want, patchOps)
+			}
+		})
+	}
+}
+
+func TestTrustBundleValidate(t *testing.T) {
+	t.Parallel()
+
+	if err := (&types.TrustBundle{Enabled: true}).Validate(); err == nil {
+		t.Fatal("configMapName must be required")
+	}
+
+	if err := (&types.TrustBundle{}).Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,282 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package volumezone
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/client"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/affinity"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/schedulingprofile"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/topologyspread"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const patchName = "volumezone"
+
+// zone labels of nodes and persistent volumes.
+var zoneLabels = []string{corev1.LabelTopologyZone, corev1.LabelFailureDomainBetaZone}
+
+type Patch struct{}
+
+// pin pod to zone of bound persistent volumes, zone topology spread constraints of pod are relaxed.
+func (p *Patch) Create(ctx context.Context, containerInfo *types.ContainerInfo) ([]types.PatchOperation, error) {
+	patchOps := make([]types.PatchOperation, 0)
+
+	selectedRule := p.getSelectedRule(containerInfo)
+	if selectedRule == nil {
+		return patchOps, nil
+	}
+
+	selectedRule.Logf("CreateVolumeZoneAffinity: %+v", selectedRule.VolumeZoneAffinity)
+
+	zones, err := GetPodZones(containerInfo)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting zones of pod volumes")
+	}
+
+	selectedRule.Logf("CreateVolumeZoneAffinity: zones=%v", zones)
+
+	// pod has no zonal volumes
+	if zones == nil {
+		return patchOps, nil
+	}
+
+	if len(zones) == 0 {
+		containerInfo.AddWarning("pod volumes are in different zones, zone affinity is not added")
+
+		return patchOps, nil
+	}
+
+	podAffinity, err := (&schedulingprofile.Patch{}).GetFinalAffinity(containerInfo)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting pod affinity")
+	}
+
+	if zoneAffinity := mergeZoneAffinity(podAffinity, zones); !reflect.DeepEqual(zoneAffinity, podAffinity) {
+		patchOps = append(patchOps, types.PatchOperation{
+			Op:    "add",
+			Path:  "/spec/affinity",
+			Value: zoneAffinity,
+		})
+	}
+
+	podConstraints, err := (&topologyspread.Patch{}).GetFinalConstraints(ctx, containerInfo)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting topology spread constraints")
+	}
+
+	if constraints := RelaxTopologySpread(podConstraints, selectedRule.VolumeZoneAffinity.TopologySpreadPolicy); !reflect.DeepEqual(constraints, podConstraints) { //nolint:lll
+		patchOps = append(patchOps, types.PatchOperation{
+			Op:    "add",
+			Path:  "/spec/topologySpreadConstraints",
+			Value: constraints,
+		})
+	}
+
+	return patchOps, nil
+}
+
+// returns pod affinity that will be after patch, used by patches that also change affinity.
+func (p *Patch) GetFinalAffinity(containerInfo *types.ContainerInfo) (*corev1.Affinity, error) {
+	podAffinity, err := (&schedulingprofile.Patch{}).GetFinalAffinity(containerInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	if containerInfo.IgnorePatch(patchName) || p.getSelectedRule(containerInfo) == nil {
+		return podAffinity, nil
+	}
+
+	zones, err := GetPodZones(containerInfo)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting zones of pod volumes")
+	}
+
+	if len(zones) == 0 {
+		return podAffinity, nil
+	}
+
+	return mergeZoneAffinity(podAffinity, zones), nil
+}
+
+func (p *Patch) getSelectedRule(containerInfo *types.ContainerInfo) *types.Rule {
+	if containerInfo.PodContainer == nil || containerInfo.PodContainer.Pod == nil {
+		return nil
+	}
+
+	for _, selectedRule := range containerInfo.SelectedRules {
+		if selectedRule.VolumeZoneAffinity.Enabled {
+			return selectedRule
+		}
+	}
+
+	return nil
+}
+
+// returns zones where all zonal volumes of pod are available,
+// nil if pod has no bound zonal volumes, empty if volumes are in different zones.
+func GetPodZones(containerInfo *types.ContainerInfo) ([]string, error) {
+	if client.Informers() == nil {
+		return nil, nil
+	}
+
+	var result []string
+
+	for _, claimName := range containerInfo.PodContainer.PodPVCNames() {
+		claim, err := client.Informers().Core().V1().PersistentVolumeClaims().Lister().PersistentVolumeClaims(containerInfo.Namespace).Get(claimName) //nolint:lll
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting claim %s", claimName)
+		}
+
+		// volume will be provisioned in zone of pod node
+		if len(claim.Spec.VolumeName) == 0 {
+			continue
+		}
+
+		volume, err := client.Informers().Core().V1().PersistentVolumes().Lister().Get(claim.Spec.VolumeName)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting volume %s", claim.Spec.VolumeName)
+		}
+
+		volumeZones := GetVolumeZones(volume)
+		if volumeZones == nil {
+			continue
+		}
+
+		if result == nil {
+			result = volumeZones
+
+			continue
+		}
+
+		result = slices.DeleteFunc(result, func(zone string) bool {
+			return !slices.Contains(volumeZones, zone)
+		})
+	}
+
+	return result, nil
+}
+
+// returns sorted zones of persistent volume, nil if volume is available in all zones.
+func GetVolumeZones(volume *corev1.PersistentVolume) []string {
+	result := make([]string, 0)
+
+	if nodeAffinity := volume.Spec.NodeAffinity; nodeAffinity != nil && nodeAffinity.Required != nil && len(nodeAffinity.Required.NodeSelectorTerms) > 0 { //nolint:lll
+		for _, term := range nodeAffinity.Required.NodeSelectorTerms {
+			termZones, ok := getTermZones(term)
+
+			// terms are ORed, volume is available in all zones
+			if !ok {
+				return nil
+			}
+
+			result = append(result, termZones...)
+		}
+	} else {
+		// zone labels of volumes from in-tree plugins, multiple zones are separated by __
+		for _, label := range zoneLabels {
+			if zones, ok := volume.Labels[label]; ok {
+				result = strings.Split(zones, "__")
+
+				break
+			}
+		}
+
+		if len(result) == 0 {
+			return nil
+		}
+	}
+
+	slices.Sort(result)
+
+	return slices.Compact(result)
+}
+
+// returns zones of node selector term, false if term has no zone requirement.
+func getTermZones(term corev1.NodeSelectorTerm) ([]string, bool) {
+	var result []string
+
+	found := false
+
+	for _, expression := range term.MatchExpressions {
+		if !slices.Contains(zoneLabels, expression.Key) || expression.Operator != corev1.NodeSelectorOpIn {
+			continue
+		}
+
+		if !found {
+			result = slices.Clone(expression.Values)
+			found = true
+
+			continue
+		}
+
+		// expressions of term are ANDed
+		result = slices.DeleteFunc(result, func(zone string) bool {
+			return !slices.Contains(expression.Values, zone)
+		})
+	}
+
+	return result, found
+}
+
+// zone spread constraints can not be satisfied by pod that is pinned to zone.
+func RelaxTopologySpread(constraints []corev1.TopologySpreadConstraint, policy string) []corev1.TopologySpreadConstraint {
+	result := make([]corev1.TopologySpreadConstraint, 0, len(constraints))
+
+	for _, constraint := range constraints {
+		if !slices.Contains(zoneLabels, constraint.TopologyKey) || constraint.WhenUnsatisfiable != corev1.DoNotSchedule {
+			result = append(result, constraint)
+
+			continue
+		}
+
+		if policy == types.VolumeZoneTopologySpreadDrop {
+			continue
+		}
+
+		constraint.WhenUnsatisfiable = corev1.ScheduleAnyway
+
+		result = append(result, constraint)
+	}
+
+	if len(constraints) == 0 {
+		return constraints
+	}
+
+	return result
+}
+
+func mergeZoneAffinity(podAffinity *corev1.Affinity, zones []string) *corev1.Affinity {
+	return affinity.MergeRequiredNodeAffinity(podAffinity, []corev1.NodeSelectorRequirement{
+		{
+			Key:      corev1.LabelTopologyZone,
+			Operator: corev1.NodeSelectorOpIn,
+			Values:   zones,
+		},
+	})
+}
@@ -0,0 +1,252 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package volumezone_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/client"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/patch/volumezone"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newVolume(name string, zones ...string) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: corev1.PersistentVolumeSpec{
+			NodeAffinity: &corev1.VolumeNodeAffinity{
+				Required: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{
+						{
+							MatchExpressions: []corev1.NodeSelectorRequirement{
+								{Key: corev1.LabelTopologyZone, Operator: corev1.NodeSelectorOpIn, Values: zones},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func newClaim(name, volumeName string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test"},
+		Spec:       corev1.PersistentVolumeClaimSpec{VolumeName: volumeName},
+	}
+}
+
+func TestGetVolumeZones(t *testing.T) { //nolint:funlen
+	t.Parallel()
+
+	tests := []struct {
+		volume *corev1.PersistentVolume
+		want   []string
+	}{
+		{volume: newVolume("pv", "zone-b", "zone-a"), want: []string{"zone-a", "zone-b"}},
+		{volume: &corev1.PersistentVolume{}, want: nil},
+		{
+			volume: &corev1.PersistentVolume{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{corev1.LabelFailureDomainBetaZone: "zone-a__zone-c"},
+				},
+			},
+			want: []string{"zone-a", "zone-c"},
+		},
+		{
+			volume: &corev1.PersistentVolume{
+				Spec: corev1.PersistentVolumeSpec{
+					NodeAffinity: &corev1.VolumeNodeAffinity{
+						Required: &corev1.NodeSelector{
+							NodeSelectorTerms: []corev1.NodeSelectorTerm{
+								{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "disk", Operator: corev1.NodeSelectorOpExists}}},
+							},
+						},
+					},
+				},
+			},
+			want: nil,
+		},
+		{
+			// zone labels are not used if one of terms has no zone requirement
+			volume: &corev1.PersistentVolume{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{corev1.LabelTopologyZone: "zone-a"},
+				},
+				Spec: corev1.PersistentVolumeSpec{
+					NodeAffinity: &corev1.VolumeNodeAffinity{
+						Required: &corev1.NodeSelector{
+							NodeSelectorTerms: []corev1.NodeSelectorTerm{
+								{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: corev1.LabelTopologyZone, Operator: corev1.NodeSelectorOpIn, Values: []string{"zone-a"}}}}, //nolint:lll
+								{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "disk", Operator: corev1.NodeSelectorOpExists}}},
+							},
+						},
+					},
+				},
+			},
+			want: nil,
+		},
+		{
+			// expressions of term are ANDed
+			volume: &corev1.PersistentVolume{
+				Spec: corev1.PersistentVolumeSpec{
+					NodeAffinity: &corev1.VolumeNodeAffinity{
+						Required: &corev1.NodeSelector{
+							NodeSelectorTerms: []corev1.NodeSelectorTerm{
+								{
+									MatchExpressions: []corev1.NodeSelectorRequirement{
+										{Key: corev1.LabelTopologyZone, Operator: corev1.NodeSelectorOpIn, Values: []string{"zone-a", "zone-b"}},
+										{Key: corev1.LabelFailureDomainBetaZone, Operator: corev1.NodeSelectorOpIn, Values: []string{"zone-b", "zone-c"}},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			want: []string{"zone-b"},
+		},
+	}
+
+	for _, tc := range tests {
+		if got := volumezone.GetVolumeZones(tc.volume); !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("want %v, got %v", tc.want, got)
+		}
+	}
+}
+
+func TestRelaxTopologySpread(t *testing.T) {
+	t.Parallel()
+
+	constraints := []corev1.TopologySpreadConstraint{
+		{MaxSkew: 1, TopologyKey: corev1.LabelHostname, WhenUnsatisfiable: corev1.DoNotSchedule},
+		{MaxSkew: 1, TopologyKey: corev1.LabelTopologyZone, WhenUnsatisfiable: corev1.DoNotSchedule},
+	}
+
+	relaxed := volumezone.RelaxTopologySpread(constraints, "")
+	if relaxed[0].WhenUnsatisfiable != corev1.DoNotSchedule || relaxed[1].WhenUnsatisfiable != corev1.ScheduleAnyway {
+		t.Fatalf("zone constraint must be relaxed, got %+v", relaxed)
+	}
+
+	if constraints[1].WhenUnsatisfiable != corev1.DoNotSchedule {
+		t.Fatal("pod constraints must not be changed")
+	}
+
+	dropped := volumezone.RelaxTopologySpread(constraints, types.VolumeZoneTopologySpreadDrop)
+	if len(dropped) != 1 || dropped[0].TopologyKey != corev1.LabelHostname {
+		t.Fatalf("zone constraint must be dropped, got %+v", dropped)
+	}
+}
+
+// test uses global informers.
+func TestVolumeZone(t *testing.T) { //nolint:paralleltest
+	clientset := fake.NewClientset(
+		newVolume("pv-1", "zone-a", "zone-b"),
+		newVolume("pv-2", "zone-b", "zone-c"),
+		newVolume("pv-3", "zone-c"),
+		newClaim("data-1", "pv-1"),
+		newClaim("data-2", "pv-2"),
+		newClaim("data-3", "pv-3"),
+		newClaim("pending", ""),
+	)
+
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	factory.Core().V1().PersistentVolumeClaims().Informer()
+	factory.Core().V1().PersistentVolumes().Informer()
+	factory.Start(t.Context().Done())
+	factory.WaitForCacheSync(t.Context().Done())
+
+	client.SetInformers(factory)
+	t.Cleanup(func() { client.SetInformers(nil) })
+
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{
+			TopologySpreadConstraints: []corev1.TopologySpreadConstraint{
+				{MaxSkew: 1, TopologyKey: corev1.LabelTopologyZone, WhenUnsatisfiable: corev1.DoNotSchedule},
+			},
+		},
+	}
+
+	for _, claimName := range []string{"data-1", "data-2", "pending", "not-found"} {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: claimName,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+			},
+		})
+	}
+
+	containerInfo := &types.ContainerInfo{
+		Namespace: "test",
+		PodContainer: &types.PodContainer{
+			Type:      "container",
+			Container: &corev1.Container{},
+			Pod:       pod,
+		},
+		SelectedRules: []*types.Rule{
+			{
+				VolumeZoneAffinity: types.VolumeZoneAffinity{Enabled: true},
+			},
+		},
+	}
+
+	patchOps, err := (&volumezone.Patch{}).Create(t.Context(), containerInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(patchOps) != 2 || patchOps[0].Path != "/spec/affinity" || patchOps[1].Path != "/spec/topologySpreadConstraints" {
+		t.Fatalf("not corrected patch %+v", patchOps)
+	}
+
+	podAffinity, ok := patchOps[0].Value.(*corev1.Affinity)
+	if !ok {
+		t.Fatalf("not corrected patch value type %T", patchOps[0].Value)
+	}
+
+	expression := podAffinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions[0] //nolint:lll
+	if expression.Key != corev1.LabelTopologyZone || !reflect.DeepEqual(expression.Values, []string{"zone-b"}) {
+		t.Fatalf("pod must be pinned to zone-b, got %+v", expression)
+	}
+
+	constraints, ok := patchOps[1].Value.([]corev1.TopologySpreadConstraint)
+	if !ok || constraints[0].WhenUnsatisfiable != corev1.ScheduleAnyway {
+		t.Fatalf("zone constraint must be relaxed, got %+v", patchOps[1])
+	}
+
+	// volumes in different zones
+	containerInfo.PodContainer.Pod.Spec.Volumes = []corev1.Volume{
+		{
+			Name:         "data-1",
+			VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data-1"}},
+		},
+		{
+			Name:         "data-3",
+			VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data-3"}},
+		},
+	}
+
+	patchOps, err = (&volumezone.Patch{}).Create(t.Context(), containerInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(patchOps) != 0 || len(containerInfo.Warnings) != 1 {
+		t.Fatalf("patch must not be created, got %+v, warnings %v", patchOps, containerInfo.Warnings)
+	}
+}
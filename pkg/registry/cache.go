@@ -0,0 +1,67 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package registry
+
+import (
+	"sync"
+	"time"
+)
+
+type cacheEntry[T any] struct {
+	value   T
+	expires time.Time
+}
+
+// cache with ttl, expired entries are removed when new entry is added.
+type ttlCache[T any] struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry[T]
+}
+
+func newTTLCache[T any]() *ttlCache[T] {
+	return &ttlCache[T]{
+		entries: make(map[string]cacheEntry[T]),
+	}
+}
+
+func (c *ttlCache[T]) get(key string) (T, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		var empty T
+
+		return empty, false
+	}
+
+	return entry.value, true
+}
+
+func (c *ttlCache[T]) set(key string, value T, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+
+	for entryKey, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, entryKey)
+		}
+	}
+
+	c.entries[key] = cacheEntry[T]{
+		value:   value,
+		expires: now.Add(ttl),
+	}
+}
@@ -0,0 +1,185 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/client"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type dockerConfigEntry struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Auth     string `json:"auth"`
+}
+
+type dockerConfigJSON struct {
+	Auths map[string]dockerConfigEntry `json:"auths"`
+}
+
+// returns registry credentials from image pull secret.
+func CredentialsFromSecret(secret *corev1.Secret) (map[string]Credentials, error) {
+	entries := make(map[string]dockerConfigEntry)
+
+	switch secret.Type { //nolint:exhaustive
+	case corev1.SecretTypeDockerConfigJson:
+		config := dockerConfigJSON{}
+
+		if err := json.Unmarshal(secret.Data[corev1.DockerConfigJsonKey], &config); err != nil {
+			return nil, errors.Wrapf(err, "error parsing secret %s", secret.Name)
+		}
+
+		entries = config.Auths
+	case corev1.SecretTypeDockercfg:
+		if err := json.Unmarshal(secret.Data[corev1.DockerConfigKey], &entries); err != nil {
+			return nil, errors.Wrapf(err, "error parsing secret %s", secret.Name)
+		}
+	default:
+		return nil, errors.Errorf("secret %s has unsupported type %s", secret.Name, secret.Type)
+	}
+
+	result := make(map[string]Credentials)
+
+	for registry, entry := range entries {
+		credentials := Credentials{
+			Username: entry.Username,
+			Password: entry.Password,
+		}
+
+		if len(entry.Auth) > 0 {
+			auth, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error decoding auth for %s in secret %s", registry, secret.Name)
+			}
+
+			credentials.Username, credentials.Password, _ = strings.Cut(string(auth), ":")
+		}
+
+		result[normalizeRegistry(registry)] = credentials
+	}
+
+	return result, nil
+}
+
+// docker config keys can be urls, for example https://index.docker.io/v1/
+func normalizeRegistry(registry string) string {
+	registry = strings.TrimPrefix(registry, "https://")
+	registry = strings.TrimPrefix(registry, "http://")
+	registry, _, _ = strings.Cut(registry, "/")
+
+	switch registry {
+	case "index.docker.io", dockerHubRegistry:
+		return dockerHubDomain
+	}
+
+	return registry
+}
+
+// returns registry options with credentials from pod pull secrets and rule pull secrets.
+func GetOptions(ctx context.Context, containerInfo *types.ContainerInfo, registryOptions types.RegistryOptions) (Options, error) { //nolint:lll
+	credentials, err := getCredentials(ctx, containerInfo, registryOptions.PullSecrets, registryOptions.GetCacheTTL())
+	if err != nil {
+		return Options{}, errors.Wrap(err, "error getting registry credentials")
+	}
+
+	return Options{
+		InsecureRegistries: registryOptions.InsecureRegistries,
+		Credentials:        credentials,
+		Timeout:            registryOptions.GetTimeout(),
+		CacheTTL:           registryOptions.GetCacheTTL(),
+	}, nil
+}
+
+// parsed pull secrets by namespace/name, secrets are not read on every admission.
+var secretCredentialsCache = newTTLCache[map[string]Credentials]()
+
+func getCredentials(ctx context.Context, containerInfo *types.ContainerInfo, pullSecrets []string, cacheTTL time.Duration) (map[string]Credentials, error) { //nolint:lll
+	secretNames := make([]string, 0)
+
+	if containerInfo.PodContainer != nil && containerInfo.PodContainer.Pod != nil {
+		for _, pullSecret := range containerInfo.PodContainer.Pod.Spec.ImagePullSecrets {
+			secretNames = append(secretNames, pullSecret.Name)
+		}
+	}
+
+	for _, rule := range containerInfo.SelectedRules {
+		for _, pullSecret := range rule.ImagePullSecrets {
+			secretNames = append(secretNames, pullSecret.Name)
+		}
+	}
+
+	secretNames = append(secretNames, pullSecrets...)
+
+	result := make(map[string]Credentials)
+
+	if len(secretNames) == 0 || client.KubeClient() == nil {
+		return result, nil
+	}
+
+	for _, secretName := range secretNames {
+		credentials, err := getSecretCredentials(ctx, containerInfo.Namespace, secretName, cacheTTL)
+		if err != nil {
+			return nil, err
+		}
+
+		// first secret with registry credentials is used
+		for registryDomain, registryCredentials := range credentials {
+			if _, ok := result[registryDomain]; !ok {
+				result[registryDomain] = registryCredentials
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// returns credentials from pull secret, missing secret has no credentials,
+// for example rule pull secret that is not created yet.
+func getSecretCredentials(ctx context.Context, namespace, secretName string, cacheTTL time.Duration) (map[string]Credentials, error) { //nolint:lll
+	cacheKey := namespace + "/" + secretName
+
+	if credentials, ok := secretCredentialsCache.get(cacheKey); ok {
+		return credentials, nil
+	}
+
+	secret, err := client.KubeClient().CoreV1().Secrets(namespace).Get(ctx, secretName, metav1.GetOptions{})
+	if k8sErrors.IsNotFound(err) {
+		log.Debugf("pull secret %s is not found", cacheKey)
+
+		return map[string]Credentials{}, nil
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting secret %s", cacheKey)
+	}
+
+	credentials, err := CredentialsFromSecret(secret)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing pull secret")
+	}
+
+	secretCredentialsCache.set(cacheKey, credentials, cacheTTL)
+
+	return credentials, nil
+}
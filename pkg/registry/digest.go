@@ -0,0 +1,286 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	dockerHubDomain   = "docker.io"
+	dockerHubRegistry = "registry-1.docker.io"
+	// limit for manifest body, manifests are small json documents
+	maxManifestSize = 4 * 1024 * 1024
+)
+
+// manifest types that registry can return for image tag.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+var authParamRegexp = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+type Credentials struct {
+	Username string
+	Password string
+}
+
+type Options struct {
+	// registries that are accessed with http
+	InsecureRegistries []string
+	// registry credentials by registry domain
+	Credentials map[string]Credentials
+	Timeout     time.Duration
+	CacheTTL    time.Duration
+}
+
+func (o *Options) scheme(domain string) string {
+	for _, registry := range o.InsecureRegistries {
+		if registry == domain {
+			return "http"
+		}
+	}
+
+	return "https"
+}
+
+// returns registry api url of repository.
+func (o *Options) repositoryURL(domain, path string) string {
+	registryHost := domain
+	if domain == dockerHubDomain {
+		registryHost = dockerHubRegistry
+	}
+
+	return fmt.Sprintf("%s://%s/v2/%s", o.scheme(domain), registryHost, path)
+}
+
+var digestCache = newTTLCache[string]()
+
+type admissionStartKey struct{}
+
+// returns context with admission start time, registry requests of all pod images
+// share one deadline that is counted from admission start.
+func WithAdmissionStart(ctx context.Context) context.Context {
+	return context.WithValue(ctx, admissionStartKey{}, time.Now())
+}
+
+// returns context with deadline for registry requests, request context deadline is kept if it is earlier.
+func (o *Options) withDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	start, ok := ctx.Value(admissionStartKey{}).(time.Time)
+	if !ok {
+		start = time.Now()
+	}
+
+	return context.WithDeadline(ctx, start.Add(o.Timeout))
+}
+
+// resolve image tag to digest using registry manifest api.
+func GetDigest(ctx context.Context, domain, path, tag string, opts Options) (string, error) {
+	cacheKey := fmt.Sprintf("%s/%s:%s", domain, path, tag)
+
+	if digest, ok := digestCache.get(cacheKey); ok {
+		return digest, nil
+	}
+
+	ctx, cancel := opts.withDeadline(ctx)
+	defer cancel()
+
+	manifestURL := fmt.Sprintf("%s/manifests/%s", opts.repositoryURL(domain, path), tag)
+
+	digest, err := getManifestDigest(ctx, manifestURL, path, opts.Credentials[domain])
+	if err != nil {
+		return "", errors.Wrapf(err, "error getting digest of %s", cacheKey)
+	}
+
+	digestCache.set(cacheKey, digest, opts.CacheTTL)
+
+	return digest, nil
+}
+
+func getManifestDigest(ctx context.Context, manifestURL, path string, credentials Credentials) (string, error) {
+	resp, err := registryGet(ctx, manifestURL, path, credentials, manifestMediaTypes)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if digest := resp.Header.Get("Docker-Content-Digest"); len(digest) > 0 {
+		return digest, nil
+	}
+
+	// registry does not return digest header, calculate it from manifest
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return "", errors.Wrap(err, "error reading manifest")
+	}
+
+	hash := sha256.Sum256(body)
+
+	return "sha256:" + hex.EncodeToString(hash[:]), nil
+}
+
+// get registry url, authorizes request if registry returns challenge.
+func registryGet(ctx context.Context, registryURL, path string, credentials Credentials, accept []string) (*http.Response, error) { //nolint:lll
+	resp, err := doRequest(ctx, registryURL, "", accept)
+	if err != nil {
+		return nil, err
+	}
+
+	// registry requires authentication
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+
+		authorization, err := getAuthorization(ctx, resp.Header.Get("WWW-Authenticate"), path, credentials)
+		if err != nil {
+			return nil, errors.Wrap(err, "error getting authorization")
+		}
+
+		resp, err = doRequest(ctx, registryURL, authorization, accept)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+
+		return nil, errors.Errorf("registry returned status %d", resp.StatusCode)
+	}
+
+	return resp, nil
+}
+
+func doRequest(ctx context.Context, registryURL, authorization string, accept []string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, registryURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating request")
+	}
+
+	if len(accept) > 0 {
+		req.Header.Set("Accept", strings.Join(accept, ","))
+	}
+
+	if len(authorization) > 0 {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error requesting registry")
+	}
+
+	return resp, nil
+}
+
+// returns Authorization header for registry challenge.
+func getAuthorization(ctx context.Context, challenge, path string, credentials Credentials) (string, error) {
+	scheme, _, _ := strings.Cut(challenge, " ")
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if len(credentials.Username) == 0 {
+			return "", errors.New("registry requires credentials")
+		}
+
+		req := http.Request{Header: http.Header{}}
+		req.SetBasicAuth(credentials.Username, credentials.Password)
+
+		return req.Header.Get("Authorization"), nil
+	case "bearer":
+		token, err := getBearerToken(ctx, challenge, path, credentials)
+		if err != nil {
+			return "", err
+		}
+
+		return "Bearer " + token, nil
+	}
+
+	return "", errors.Errorf("unknown authentication challenge %q", challenge)
+}
+
+// token authentication https://distribution.github.io/distribution/spec/auth/token/
+func getBearerToken(ctx context.Context, challenge, path string, credentials Credentials) (string, error) {
+	params := make(map[string]string)
+
+	for _, param := range authParamRegexp.FindAllStringSubmatch(challenge, -1) {
+		params[strings.ToLower(param[1])] = param[2]
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || len(realm.Host) == 0 {
+		return "", errors.Errorf("invalid realm in challenge %q", challenge)
+	}
+
+	query := realm.Query()
+
+	if service := params["service"]; len(service) > 0 {
+		query.Set("service", service)
+	}
+
+	query.Set("scope", fmt.Sprintf("repository:%s:pull", path))
+
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", errors.Wrap(err, "error creating request")
+	}
+
+	if len(credentials.Username) > 0 {
+		req.SetBasicAuth(credentials.Username, credentials.Password)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "error getting token")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("token service returned status %d", resp.StatusCode)
+	}
+
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"` //nolint:tagliatelle
+	}{}
+
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", errors.Wrap(err, "error decoding token")
+	}
+
+	if len(token.Token) > 0 {
+		return token.Token, nil
+	}
+
+	if len(token.AccessToken) > 0 {
+		return token.AccessToken, nil
+	}
+
+	return "", errors.New("token service returned empty token")
+}
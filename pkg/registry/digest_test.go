@@ -0,0 +1,232 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package registry_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/client"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/registry"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	testDigest   = "sha256:8fd21d59428507671ce0fb47f818b1d859c92d2ad07bb7c947268d433030ba98"
+	testManifest = `{"schemaVersion":2}`
+)
+
+// local registry with token authentication.
+func newTestRegistry(t *testing.T, manifestRequests *atomic.Int32) *httptest.Server {
+	t.Helper()
+
+	var server *httptest.Server
+
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			if username, password, ok := r.BasicAuth(); !ok || username != "user" || password != "password" {
+				w.WriteHeader(http.StatusUnauthorized)
+
+				return
+			}
+
+			if r.URL.Query().Get("scope") != "repository:private/app:pull" {
+				w.WriteHeader(http.StatusForbidden)
+
+				return
+			}
+
+			_, _ = w.Write([]byte(`{"token":"test-token"}`))
+		case "/v2/private/app/manifests/v1":
+			manifestRequests.Add(1)
+
+			if r.Header.Get("Authorization") != "Bearer test-token" {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, server.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+
+				return
+			}
+
+			w.Header().Set("Docker-Content-Digest", testDigest)
+			_, _ = w.Write([]byte(testManifest))
+		case "/v2/public/app/manifests/v1":
+			_, _ = w.Write([]byte(testManifest))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestGetDigest(t *testing.T) {
+	t.Parallel()
+
+	var manifestRequests atomic.Int32
+
+	server := newTestRegistry(t, &manifestRequests)
+	domain := strings.TrimPrefix(server.URL, "http://")
+
+	opts := registry.Options{
+		InsecureRegistries: []string{domain},
+		Timeout:            time.Second,
+		CacheTTL:           time.Minute,
+	}
+
+	// private image without credentials
+	if _, err := registry.GetDigest(t.Context(), domain, "private/app", "v1", opts); err == nil {
+		t.Fatal("private image without credentials must return error")
+	}
+
+	opts.Credentials = map[string]registry.Credentials{
+		domain: {Username: "user", Password: "password"},
+	}
+
+	digest, err := registry.GetDigest(t.Context(), domain, "private/app", "v1", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if digest != testDigest {
+		t.Fatalf("digest must be %s, got %s", testDigest, digest)
+	}
+
+	requests := manifestRequests.Load()
+
+	// digest must be cached
+	if _, err := registry.GetDigest(t.Context(), domain, "private/app", "v1", opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if manifestRequests.Load() != requests {
+		t.Fatal("digest must be returned from cache")
+	}
+
+	// registry without digest header
+	digest, err = registry.GetDigest(t.Context(), domain, "public/app", "v1", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash := sha256.Sum256([]byte(testManifest))
+
+	if required := "sha256:" + hex.EncodeToString(hash[:]); digest != required {
+		t.Fatalf("digest must be %s, got %s", required, digest)
+	}
+
+	if _, err := registry.GetDigest(t.Context(), domain, "public/app", "unknown", opts); err == nil {
+		t.Fatal("unknown tag must return error")
+	}
+}
+
+func TestGetDigestAdmissionDeadline(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(400 * time.Millisecond)
+
+		_, _ = w.Write([]byte(testManifest))
+	}))
+	t.Cleanup(server.Close)
+
+	domain := strings.TrimPrefix(server.URL, "http://")
+
+	opts := registry.Options{
+		InsecureRegistries: []string{domain},
+		Timeout:            600 * time.Millisecond,
+		CacheTTL:           time.Minute,
+	}
+
+	ctx := registry.WithAdmissionStart(t.Context())
+
+	if _, err := registry.GetDigest(ctx, domain, "slow/app", "v1", opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// images of one admission share deadline
+	if _, err := registry.GetDigest(ctx, domain, "slow/app", "v2", opts); err == nil {
+		t.Fatal("second image must exceed admission deadline")
+	}
+}
+
+func TestCredentialsFromSecret(t *testing.T) {
+	t.Parallel()
+
+	secret := &corev1.Secret{
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			// test:password
+			corev1.DockerConfigJsonKey: []byte(`{"auths":{"https://index.docker.io/v1/":{"auth":"dGVzdDpwYXNzd29yZA=="},"registry.example.com":{"username":"user","password":"secret"}}}`), //nolint:lll
+		},
+	}
+
+	credentials, err := registry.CredentialsFromSecret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if credentials["docker.io"].Username != "test" || credentials["docker.io"].Password != "password" {
+		t.Fatalf("not valid docker.io credentials %+v", credentials["docker.io"])
+	}
+
+	if credentials["registry.example.com"].Username != "user" || credentials["registry.example.com"].Password != "secret" {
+		t.Fatalf("not valid registry.example.com credentials %+v", credentials["registry.example.com"])
+	}
+
+	if _, err := registry.CredentialsFromSecret(&corev1.Secret{Type: corev1.SecretTypeOpaque}); err == nil {
+		t.Fatal("opaque secret must return error")
+	}
+}
+
+// test uses global kube client.
+func TestGetOptionsMissingSecret(t *testing.T) { //nolint:paralleltest
+	client.SetKubeClient(fake.NewClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "registry-credentials"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths":{"registry.example.com":{"username":"user","password":"secret"}}}`),
+		},
+	}))
+	t.Cleanup(func() { client.SetKubeClient(nil) })
+
+	containerInfo := &types.ContainerInfo{
+		Namespace: "test",
+		SelectedRules: []*types.Rule{
+			{ImagePullSecrets: []corev1.LocalObjectReference{{Name: "not-created"}}},
+		},
+	}
+
+	opts, err := registry.GetOptions(t.Context(), containerInfo, types.RegistryOptions{
+		PullSecrets: []string{"registry-credentials"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if opts.Credentials["registry.example.com"].Username != "user" {
+		t.Fatalf("not valid credentials %+v", opts.Credentials)
+	}
+}
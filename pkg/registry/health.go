@@ -0,0 +1,202 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package registry
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/metrics"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const healthCheckTick = time.Second
+
+type mirrorHealth struct {
+	settings  types.MirrorHealthCheck
+	healthy   bool
+	checking  bool
+	lastCheck time.Time
+}
+
+// mirror health checks, mirrors from config are registered on start, other mirrors on first use.
+type HealthChecker struct {
+	mu      sync.Mutex
+	mirrors map[string]*mirrorHealth
+}
+
+func NewHealthChecker() *HealthChecker {
+	return &HealthChecker{
+		mirrors: make(map[string]*mirrorHealth),
+	}
+}
+
+var healthChecker = NewHealthChecker()
+
+func MirrorHealth() *HealthChecker {
+	return healthChecker
+}
+
+// returns mirror status, mirror is not healthy until first check,
+// so images are not rewritten to mirror that can be down.
+func (h *HealthChecker) IsHealthy(host string, settings types.MirrorHealthCheck) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	mirror, ok := h.mirrors[host]
+	if !ok {
+		h.register(host, settings)
+
+		return false
+	}
+
+	return mirror.healthy
+}
+
+// register mirrors of rules with enabled health check, mirrors are checked on Run start.
+func (h *HealthChecker) RegisterMirrors(rules []*types.Rule) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, rule := range rules {
+		replaceHost := rule.ReplaceContainerImageHost
+
+		if !replaceHost.Enabled || !replaceHost.HealthCheck.Enabled {
+			continue
+		}
+
+		for _, mirror := range replaceHost.Mirrors {
+			if host := MirrorHost(mirror.Mirror); h.mirrors[host] == nil {
+				h.register(host, replaceHost.HealthCheck)
+			}
+		}
+	}
+}
+
+func (h *HealthChecker) register(host string, settings types.MirrorHealthCheck) {
+	h.mirrors[host] = &mirrorHealth{
+		settings: settings,
+	}
+
+	metrics.MirrorHealthy.WithLabelValues(host).Set(0)
+}
+
+// check registered mirrors until context is done, first check is on start.
+func (h *HealthChecker) Run(ctx context.Context) {
+	ticker := time.NewTicker(healthCheckTick)
+	defer ticker.Stop()
+
+	for {
+		for _, host := range h.dueMirrors() {
+			go h.Check(ctx, host)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// mirrors that need to be checked, marks them as checking.
+func (h *HealthChecker) dueMirrors() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	result := make([]string, 0)
+
+	for host, mirror := range h.mirrors {
+		if mirror.checking || time.Since(mirror.lastCheck) < mirror.settings.GetInterval() {
+			continue
+		}
+
+		mirror.checking = true
+
+		result = append(result, host)
+	}
+
+	return result
+}
+
+// probe registered mirror and save result.
+func (h *HealthChecker) Check(ctx context.Context, host string) {
+	h.mu.Lock()
+	mirror, ok := h.mirrors[host]
+	h.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	err := Probe(ctx, host, mirror.settings)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err != nil && (mirror.healthy || mirror.lastCheck.IsZero()) {
+		log.WithError(err).Warnf("mirror %s is unhealthy, images will not be rewritten", host)
+	}
+
+	if err == nil && !mirror.healthy {
+		log.Infof("mirror %s is healthy", host)
+	}
+
+	mirror.healthy = err == nil
+	mirror.checking = false
+	mirror.lastCheck = time.Now()
+
+	if mirror.healthy {
+		metrics.MirrorHealthy.WithLabelValues(host).Set(1)
+	} else {
+		metrics.MirrorHealthy.WithLabelValues(host).Set(0)
+	}
+}
+
+// registry api version check, registry that requires authentication is healthy.
+func Probe(ctx context.Context, host string, settings types.MirrorHealthCheck) error {
+	ctx, cancel := context.WithTimeout(ctx, settings.GetTimeout())
+	defer cancel()
+
+	scheme := "https"
+	if settings.Insecure {
+		scheme = "http"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, scheme+"://"+host+"/v2/", nil)
+	if err != nil {
+		return errors.Wrap(err, "error creating request")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "error probing registry")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnauthorized {
+		return errors.Errorf("registry returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// returns host of mirror, for example mirror.domain.com/dockerhub -> mirror.domain.com.
+func MirrorHost(mirror string) string {
+	return strings.SplitN(mirror, "/", 2)[0] //nolint:mnd
+}
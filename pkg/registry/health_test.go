@@ -0,0 +1,174 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package registry_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/registry"
+	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
+)
+
+func TestProbe(t *testing.T) {
+	t.Parallel()
+
+	tests := map[int]bool{
+		http.StatusOK:                  true,
+		http.StatusUnauthorized:        true,
+		http.StatusNotFound:            false,
+		http.StatusInternalServerError: false,
+	}
+
+	settings := types.MirrorHealthCheck{
+		Enabled:  true,
+		Insecure: true,
+	}
+
+	for statusCode, healthy := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v2/" {
+				w.WriteHeader(http.StatusNotFound)
+
+				return
+			}
+
+			w.WriteHeader(statusCode)
+		}))
+
+		err := registry.Probe(t.Context(), strings.TrimPrefix(server.URL, "http://"), settings)
+
+		server.Close()
+
+		if healthy && err != nil {
+			t.Fatalf("status %d must be healthy, got %s", statusCode, err)
+		}
+
+		if !healthy && err == nil {
+			t.Fatalf("status %d must be unhealthy", statusCode)
+		}
+	}
+}
+
+func TestHealthChecker(t *testing.T) {
+	t.Parallel()
+
+	var statusCode atomic.Int32
+
+	statusCode.Store(http.StatusOK)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(int(statusCode.Load()))
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	settings := types.MirrorHealthCheck{
+		Enabled:  true,
+		Insecure: true,
+	}
+
+	healthChecker := registry.NewHealthChecker()
+
+	// not registered mirror
+	healthChecker.Check(t.Context(), host)
+
+	if healthChecker.IsHealthy(host, settings) {
+		t.Fatal("mirror must be not healthy before first check")
+	}
+
+	healthChecker.Check(t.Context(), host)
+
+	if !healthChecker.IsHealthy(host, settings) {
+		t.Fatal("mirror must be healthy after check")
+	}
+
+	statusCode.Store(http.StatusServiceUnavailable)
+
+	healthChecker.Check(t.Context(), host)
+
+	if healthChecker.IsHealthy(host, settings) {
+		t.Fatal("mirror must be unhealthy")
+	}
+
+	statusCode.Store(http.StatusOK)
+
+	healthChecker.Check(t.Context(), host)
+
+	if !healthChecker.IsHealthy(host, settings) {
+		t.Fatal("mirror must be healthy")
+	}
+}
+
+func TestHealthCheckerRegisterMirrors(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	settings := types.MirrorHealthCheck{
+		Enabled:  true,
+		Insecure: true,
+	}
+
+	healthChecker := registry.NewHealthChecker()
+	healthChecker.RegisterMirrors([]*types.Rule{
+		{
+			ReplaceContainerImageHost: types.ReplaceContainerImageHost{
+				Enabled:     true,
+				Mirrors:     []types.ImageMirror{{Registry: "docker.io", Mirror: host + "/dockerhub"}},
+				HealthCheck: settings,
+			},
+		},
+	})
+
+	if healthChecker.IsHealthy(host, settings) {
+		t.Fatal("mirror must be not healthy before first check")
+	}
+
+	// registered mirrors are checked on start
+	go healthChecker.Run(t.Context())
+
+	timeout := time.After(5 * time.Second)
+
+	for !healthChecker.IsHealthy(host, settings) {
+		select {
+		case <-timeout:
+			t.Fatal("mirror must be healthy after check")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestMirrorHost(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"mirror.example.com":                    "mirror.example.com",
+		"mirror.example.com:5000/dockerhub":     "mirror.example.com:5000",
+		"mirror.example.com/library/alpine:3.1": "mirror.example.com",
+	}
+
+	for mirror, host := range tests {
+		if result := registry.MirrorHost(mirror); result != host {
+			t.Fatalf("mirror %s must have host %s, got %s", mirror, host, result)
+		}
+	}
+}
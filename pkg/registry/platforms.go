@@ -0,0 +1,112 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/pkg/errors"
+)
+
+const platformOSLinux = "linux"
+
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+type manifest struct {
+	// manifest list or image index
+	Manifests []struct {
+		Digest   string    `json:"digest"`
+		Platform *Platform `json:"platform"`
+	} `json:"manifests"`
+	// image manifest
+	Config struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+}
+
+var architecturesCache = newTTLCache[[]string]()
+
+// returns sorted linux architectures of image, reference is tag or digest.
+func GetArchitectures(ctx context.Context, domain, path, reference string, opts Options) ([]string, error) {
+	cacheKey := fmt.Sprintf("%s/%s:%s", domain, path, reference)
+
+	if architectures, ok := architecturesCache.get(cacheKey); ok {
+		return architectures, nil
+	}
+
+	ctx, cancel := opts.withDeadline(ctx)
+	defer cancel()
+
+	repositoryURL := opts.repositoryURL(domain, path)
+	credentials := opts.Credentials[domain]
+
+	imageManifest := manifest{}
+
+	if err := getJSON(ctx, repositoryURL+"/manifests/"+reference, path, credentials, manifestMediaTypes, &imageManifest); err != nil { //nolint:lll
+		return nil, errors.Wrapf(err, "error getting manifest of %s", cacheKey)
+	}
+
+	architectures := make([]string, 0)
+
+	if len(imageManifest.Manifests) > 0 {
+		for _, item := range imageManifest.Manifests {
+			// attestation manifests have unknown platform
+			if item.Platform == nil || item.Platform.OS != platformOSLinux {
+				continue
+			}
+
+			architectures = append(architectures, item.Platform.Architecture)
+		}
+	} else {
+		// single platform image, platform is in image config
+		imageConfig := Platform{}
+
+		if err := getJSON(ctx, repositoryURL+"/blobs/"+imageManifest.Config.Digest, path, credentials, nil, &imageConfig); err != nil { //nolint:lll
+			return nil, errors.Wrapf(err, "error getting config of %s", cacheKey)
+		}
+
+		if imageConfig.OS == platformOSLinux {
+			architectures = append(architectures, imageConfig.Architecture)
+		}
+	}
+
+	slices.Sort(architectures)
+
+	architectures = slices.Compact(architectures)
+
+	architecturesCache.set(cacheKey, architectures, opts.CacheTTL)
+
+	return architectures, nil
+}
+
+func getJSON(ctx context.Context, registryURL, path string, credentials Credentials, accept []string, result interface{}) error { //nolint:lll
+	resp, err := registryGet(ctx, registryURL, path, credentials, accept)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(result); err != nil {
+		return errors.Wrap(err, "error decoding response")
+	}
+
+	return nil
+}
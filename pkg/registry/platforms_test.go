@@ -0,0 +1,75 @@
+/*
+Copyright paskal.maksim@gmail.com
+Licensed under the Apache License, Version 2.0 (the "License")
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package registry_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maksim-paskal/pod-admission-controller/pkg/registry"
+)
+
+func TestGetArchitectures(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/multi/app/manifests/v1":
+			_, _ = w.Write([]byte(`{"manifests":[
+				{"digest":"sha256:1","platform":{"os":"linux","architecture":"arm64","variant":"v8"}},
+				{"digest":"sha256:2","platform":{"os":"linux","architecture":"amd64"}},
+				{"digest":"sha256:3","platform":{"os":"unknown","architecture":"unknown"}},
+				{"digest":"sha256:4","platform":{"os":"windows","architecture":"amd64"}}
+			]}`))
+		case "/v2/single/app/manifests/v1":
+			_, _ = w.Write([]byte(`{"config":{"digest":"sha256:config"}}`))
+		case "/v2/single/app/blobs/sha256:config":
+			_, _ = w.Write([]byte(`{"os":"linux","architecture":"arm64"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	domain := strings.TrimPrefix(server.URL, "http://")
+
+	opts := registry.Options{
+		InsecureRegistries: []string{domain},
+		Timeout:            time.Second,
+		CacheTTL:           time.Minute,
+	}
+
+	tests := map[string][]string{
+		"multi/app":  {"amd64", "arm64"},
+		"single/app": {"arm64"},
+	}
+
+	for path, required := range tests {
+		architectures, err := registry.GetArchitectures(t.Context(), domain, path, "v1", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(architectures, required) {
+			t.Fatalf("image %s must have architectures %v, got %v", path, required, architectures)
+		}
+	}
+
+	if _, err := registry.GetArchitectures(t.Context(), domain, "unknown/app", "v1", opts); err == nil {
+		t.Fatal("unknown image must return error")
+	}
+}
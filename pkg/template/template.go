@@ -20,12 +20,12 @@ import (
 
 	"github.com/Masterminds/sprig/v3"
 	"github.com/maksim-paskal/pod-admission-controller/pkg/sentry"
-	"github.com/maksim-paskal/pod-admission-controller/pkg/types"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
-func Get(containerInfo *types.ContainerInfo, value string) (string, error) {
+// data is *types.ContainerInfo for pods or *types.IngressInfo for ingresses.
+func Get(data any, value string) (string, error) {
 	tmpl, err := template.New("tmpl").Option("missingkey=zero").Funcs(sprig.FuncMap()).Funcs(template.FuncMap{
 		// regexp string by pattern
 		"regexp": func(pattern string, value string) []string {
@@ -70,7 +70,7 @@ func Get(containerInfo *types.ContainerInfo, value string) (string, error) {
 
 	var tpl bytes.Buffer
 
-	err = tmpl.Execute(&tpl, containerInfo)
+	err = tmpl.Execute(&tpl, data)
 	if err != nil {
 		return "", errors.Wrapf(err, "error executing template %s", value)
 	}
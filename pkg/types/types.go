@@ -15,19 +15,30 @@ package types
 import (
 	"encoding/json"
 	"fmt"
+	"maps"
 	"regexp"
 	"slices"
 	"strings"
+	"time"
 
+	"github.com/Masterminds/semver/v3"
+	"github.com/distribution/reference"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 const (
 	annotationPrefix = "pod-admission-controller"
 	// label for namespaces that managed by pod-admission-controller.
 	LabelManaged = annotationPrefix + "/managed"
+	// label with scheduling profile that was selected for pod.
+	LabelSchedulingProfile = annotationPrefix + "/scheduling-profile"
+	// namespace annotations of PodTolerationRestriction and PodNodeSelector admission plugins.
+	AnnotationDefaultTolerations   = "scheduler.alpha.kubernetes.io/defaultTolerations"
+	AnnotationTolerationsWhitelist = "scheduler.alpha.kubernetes.io/tolerationsWhitelist"
+	AnnotationNodeSelector         = "scheduler.alpha.kubernetes.io/node-selector"
 	// annotation that will added to pod if mutation executes.
 	AnnotationInjected = annotationPrefix + "/injected"
 	// skip mutation.
@@ -42,6 +53,8 @@ const (
 	AnnotationDefaultResourcesCPU = annotationPrefix + "/defaultResourcesCPU"
 	// Default Memory requests.
 	AnnotationDefaultResourcesMemory = annotationPrefix + "/defaultResourcesMemory"
+	// skip patch for containers, pod-admission-controller/ignore-<patch-name>=<container-name>[,<container-name>].
+	AnnotationIgnorePatchPrefix = annotationPrefix + "/ignore-"
 	// ingress default suffix.
 	AnnotationDefaultIngressSuffix = annotationPrefix + "/ingressSuffix"
 	// warning when AnnotationIgnore is enabled.
@@ -66,18 +79,260 @@ type ReplaceContainerImageHost struct {
 	Enabled bool
 	From    string
 	To      string
+	// registry to mirror table, used instead of From and To
+	Mirrors []ImageMirror
+	// keep original image while mirror is unhealthy
+	HealthCheck MirrorHealthCheck
+}
+
+func (r *ReplaceContainerImageHost) Validate() error {
+	for _, mirror := range r.Mirrors {
+		if len(mirror.Registry) == 0 || len(mirror.Mirror) == 0 {
+			return errors.New("registry and mirror must be set")
+		}
+
+		if _, err := regexp.Compile(mirror.PathFrom); err != nil {
+			return errors.Wrapf(err, "error in regexp %s", mirror.PathFrom)
+		}
+	}
+
+	if r.HealthCheck.IntervalSeconds < 0 || r.HealthCheck.TimeoutSeconds < 0 {
+		return errors.New("health check interval and timeout must be positive")
+	}
+
+	return nil
+}
+
+const (
+	defaultMirrorHealthCheckInterval = 10 * time.Second
+	defaultMirrorHealthCheckTimeout  = 3 * time.Second
+)
+
+type MirrorHealthCheck struct {
+	Enabled bool
+	// use http instead of https for registry probe
+	Insecure        bool
+	IntervalSeconds int
+	TimeoutSeconds  int
+}
+
+func (h *MirrorHealthCheck) GetInterval() time.Duration {
+	if h.IntervalSeconds == 0 {
+		return defaultMirrorHealthCheckInterval
+	}
+
+	return time.Duration(h.IntervalSeconds) * time.Second
+}
+
+func (h *MirrorHealthCheck) GetTimeout() time.Duration {
+	if h.TimeoutSeconds == 0 {
+		return defaultMirrorHealthCheckTimeout
+	}
+
+	return time.Duration(h.TimeoutSeconds) * time.Second
+}
+
+type ImageMirror struct {
+	// image registry, for example docker.io
+	Registry string
+	// mirror host with optional path, for example mirror.domain.com/dockerhub
+	Mirror string
+	// optional regexp for image path rewrite, for example ^library/(.+)$
+	PathFrom string
+	PathTo   string
+}
+
+const (
+	FailPolicyOpen   = "open"
+	FailPolicyClosed = "closed"
+
+	// registry requests of all pod images share one deadline that must be less than webhook timeout
+	defaultRegistryTimeout  = 2 * time.Second
+	defaultRegistryCacheTTL = 5 * time.Minute
+)
+
+// registry access settings for patches that read image manifests.
+type RegistryOptions struct {
+	// pull secrets in pod namespace with registry credentials, pod pull secrets are used too
+	PullSecrets []string
+	// registries that are accessed with http, for example localhost:5000
+	InsecureRegistries []string
+	TimeoutSeconds     int
+	CacheTTLSeconds    int
+}
+
+func (r *RegistryOptions) Validate() error {
+	if r.TimeoutSeconds < 0 || r.CacheTTLSeconds < 0 {
+		return errors.New("timeout and cache ttl must be positive")
+	}
+
+	return nil
+}
+
+func (r *RegistryOptions) GetTimeout() time.Duration {
+	if r.TimeoutSeconds == 0 {
+		return defaultRegistryTimeout
+	}
+
+	return time.Duration(r.TimeoutSeconds) * time.Second
+}
+
+func (r *RegistryOptions) GetCacheTTL() time.Duration {
+	if r.CacheTTLSeconds == 0 {
+		return defaultRegistryCacheTTL
+	}
+
+	return time.Duration(r.CacheTTLSeconds) * time.Second
+}
+
+type PinImageDigest struct {
+	Enabled bool
+	// open - keep image tag, closed - deny pod, when digest can not be resolved, default open
+	FailPolicy string
+	RegistryOptions
+}
+
+func (p *PinImageDigest) Validate() error {
+	if p.FailPolicy != "" && p.FailPolicy != FailPolicyOpen && p.FailPolicy != FailPolicyClosed {
+		return errors.Errorf("unknown fail policy %s", p.FailPolicy)
+	}
+
+	return p.RegistryOptions.Validate()
+}
+
+// node architectures that are used when rule does not set them.
+var defaultNodeArchitectures = []string{"amd64", "arm64"}
+
+type NodeArchAffinity struct {
+	Enabled bool
+	// architectures of cluster nodes, default amd64 and arm64
+	Architectures []string
+	RegistryOptions
+}
+
+func (n *NodeArchAffinity) GetArchitectures() []string {
+	if len(n.Architectures) == 0 {
+		return defaultNodeArchitectures
+	}
+
+	return n.Architectures
+}
+
+const (
+	ImagePolicyActionDeny    = "deny"
+	ImagePolicyActionWarn    = "warn"
+	ImagePolicyActionRewrite = "rewrite"
+)
+
+type ImagePolicy struct {
+	Enabled bool
+	// regexp patterns of allowed image registries, all registries are allowed if empty
+	AllowedRegistries []string
+	// regexp patterns of denied image registries
+	DeniedRegistries []string
+	// deny images with latest tag or without tag and digest
+	DenyLatest bool
+	// regexp patterns of denied tags, for example ^(main|master|dev)$
+	DeniedTags []string
+	// deny images without digest
+	RequireDigest bool
+	// deny, warn or rewrite, rewrite replaces image host with replaceContainerImageHost, default deny
+	Action string
+}
+
+func (i *ImagePolicy) Validate() error {
+	if !slices.Contains([]string{"", ImagePolicyActionDeny, ImagePolicyActionWarn, ImagePolicyActionRewrite}, i.Action) {
+		return errors.Errorf("unknown action %s", i.Action)
+	}
+
+	for _, pattern := range slices.Concat(i.AllowedRegistries, i.DeniedRegistries, i.DeniedTags) {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return errors.Wrapf(err, "error in regexp %s", pattern)
+		}
+	}
+
+	return nil
 }
 
 type AddDefaultResources struct {
 	Enabled  bool
 	LimitCPU bool
+	// default requests for containers without requests, for example cpu: 100m, ephemeral-storage: 1Gi
+	Requests corev1.ResourceList
+	// default limits for containers without limits, for example memory: 1Gi
+	Limits corev1.ResourceList
+	// limit to request ratio for containers without limits, for example memory: 1.5
+	LimitRatio map[corev1.ResourceName]float64
+	// minimum and maximum values for container resources
+	Clamp ResourcesClamp
+	// use VerticalPodAutoscaler recommendations as container requests
+	FromVPA ResourcesFromVPA
 	// Deprecated: use custompatch instead
 	RemoveResources bool
 }
 
+type ResourcesFromVPA struct {
+	Enabled bool
+	// replace container requests with recommendations
+	Force bool
+}
+
+type ResourcesClamp struct {
+	Enabled     bool
+	MinRequests corev1.ResourceList
+	MaxRequests corev1.ResourceList
+	MinLimits   corev1.ResourceList
+	MaxLimits   corev1.ResourceList
+	// deny pod if container resources are out of bounds, instead of patching them
+	Deny bool
+}
+
+func (a *AddDefaultResources) Validate() error {
+	for resourceName, ratio := range a.LimitRatio {
+		if ratio < 1 {
+			return errors.Errorf("limit ratio for %s must be greater or equal 1, got %v", resourceName, ratio)
+		}
+	}
+
+	return nil
+}
+
+const (
+	RuntimeGo   = "go"
+	RuntimeJava = "java"
+	RuntimeNode = "node"
+)
+
+type RuntimeEnv struct {
+	Enabled bool
+	// runtime of container image: go, java or node
+	Runtime string
+	// percent of container memory limit that runtime can use, runtime default is used if empty
+	MemoryPercent int
+}
+
+func (r *RuntimeEnv) Validate() error {
+	if !r.Enabled {
+		return nil
+	}
+
+	if !slices.Contains([]string{RuntimeGo, RuntimeJava, RuntimeNode}, r.Runtime) {
+		return errors.Errorf("unknown runtime %s", r.Runtime)
+	}
+
+	if r.MemoryPercent < 0 || r.MemoryPercent > 100 {
+		return errors.Errorf("memory percent must be between 0 and 100, got %d", r.MemoryPercent)
+	}
+
+	return nil
+}
+
 type AddTopologySpread struct {
-	Enabled                   bool
+	Enabled bool
+	// constraints are added only for topology keys that pod does not have
 	TopologySpreadConstraints []corev1.TopologySpreadConstraint
+	// pod label keys for constraints label selector, all pod labels except generated are used if empty
+	LabelKeys []string
 }
 
 func (a *AddTopologySpread) Clone() AddTopologySpread {
@@ -95,6 +350,330 @@ func (a *AddTopologySpread) Clone() AddTopologySpread {
 	return clone
 }
 
+const (
+	AffinityTypeRequired  = "required"
+	AffinityTypePreferred = "preferred"
+)
+
+type Affinity struct {
+	Enabled bool
+	// anti affinity to pods of the same workload
+	PodAntiAffinity PodAntiAffinity
+	// weighted node affinity terms, for example spot node pools
+	PreferredNodeAffinity []corev1.PreferredSchedulingTerm
+	// node requirements that are added to every required node selector term of pod
+	RequiredNodeAffinity []corev1.NodeSelectorRequirement
+}
+
+type PodAntiAffinity struct {
+	Enabled bool
+	// required or preferred, default preferred
+	Type string
+	// default kubernetes.io/hostname
+	TopologyKey string
+	// weight of preferred term, 0 means 100
+	Weight int32
+	// pod label keys for label selector, all pod labels except generated are used if empty
+	LabelKeys []string
+}
+
+func (a *Affinity) Validate() error {
+	if !slices.Contains([]string{"", AffinityTypeRequired, AffinityTypePreferred}, a.PodAntiAffinity.Type) {
+		return errors.Errorf("unknown pod anti affinity type %s", a.PodAntiAffinity.Type)
+	}
+
+	// 0 is not set weight
+	if a.PodAntiAffinity.Weight < 0 || a.PodAntiAffinity.Weight > 100 {
+		return errors.Errorf("pod anti affinity weight must be in range 0-100, 0 means 100, got %d", a.PodAntiAffinity.Weight)
+	}
+
+	for _, term := range a.PreferredNodeAffinity {
+		if term.Weight < 1 || term.Weight > 100 {
+			return errors.Errorf("preferred node affinity weight must be in range 1-100, got %d", term.Weight)
+		}
+	}
+
+	return nil
+}
+
+type SchedulingProfiles struct {
+	Enabled bool
+	// pods of the same owner are split between profiles by weights
+	Profiles []SchedulingProfile
+}
+
+type SchedulingProfile struct {
+	Name                  string
+	Weight                int
+	Tolerations           []corev1.Toleration
+	PreferredNodeAffinity []corev1.PreferredSchedulingTerm
+	RequiredNodeAffinity  []corev1.NodeSelectorRequirement
+}
+
+func (s *SchedulingProfiles) Validate() error {
+	if !s.Enabled {
+		return nil
+	}
+
+	totalWeight := 0
+	names := make(map[string]bool)
+
+	for _, profile := range s.Profiles {
+		if len(profile.Name) == 0 {
+			return errors.New("scheduling profile name is required")
+		}
+
+		if names[profile.Name] {
+			return errors.Errorf("scheduling profile %s is duplicated", profile.Name)
+		}
+
+		names[profile.Name] = true
+
+		if profile.Weight < 0 {
+			return errors.Errorf("scheduling profile %s weight must be positive", profile.Name)
+		}
+
+		totalWeight += profile.Weight
+	}
+
+	if totalWeight == 0 {
+		return errors.New("scheduling profiles total weight must be positive")
+	}
+
+	return nil
+}
+
+const (
+	VolumeZoneTopologySpreadRelax = "relax"
+	VolumeZoneTopologySpreadDrop  = "drop"
+)
+
+type VolumeZoneAffinity struct {
+	Enabled bool
+	// relax or drop zone topology spread constraints of pod, default relax
+	TopologySpreadPolicy string
+}
+
+func (v *VolumeZoneAffinity) Validate() error {
+	if !slices.Contains([]string{"", VolumeZoneTopologySpreadRelax, VolumeZoneTopologySpreadDrop}, v.TopologySpreadPolicy) { //nolint:lll
+		return errors.Errorf("unknown topology spread policy %s", v.TopologySpreadPolicy)
+	}
+
+	return nil
+}
+
+const (
+	PodClassesPolicyKeep     = "keep"
+	PodClassesPolicyOverride = "override"
+)
+
+type PodClasses struct {
+	Enabled bool
+	// values can be templated, for example {{ index .NamespaceLabels "tier" }}
+	PriorityClassName string
+	RuntimeClassName  string
+	SchedulerName     string
+	// keep values that pod already has or override them, default keep
+	Policy string
+}
+
+func (p *PodClasses) Validate() error {
+	if !slices.Contains([]string{"", PodClassesPolicyKeep, PodClassesPolicyOverride}, p.Policy) {
+		return errors.Errorf("unknown pod classes policy %s", p.Policy)
+	}
+
+	return nil
+}
+
+const defaultTerminationGracePeriodMargin = 5
+
+type DefaultProbes struct {
+	Enabled bool
+	// probes and preStop can be templated, for example port: '{{ .PodContainer.ContainerPort `http` }}'
+	ReadinessProbe *corev1.Probe
+	LivenessProbe  *corev1.Probe
+	StartupProbe   *corev1.Probe
+	PreStop        *corev1.LifecycleHandler
+	// raise pod terminationGracePeriodSeconds to be more than preStop duration
+	AdjustTerminationGracePeriod bool
+	// seconds that are added to preStop duration, default 5
+	TerminationGracePeriodMarginSeconds int64
+}
+
+func (d *DefaultProbes) GetTerminationGracePeriodMargin() int64 {
+	if d.TerminationGracePeriodMarginSeconds > 0 {
+		return d.TerminationGracePeriodMarginSeconds
+	}
+
+	return defaultTerminationGracePeriodMargin
+}
+
+func (d *DefaultProbes) Validate() error {
+	if d.TerminationGracePeriodMarginSeconds < 0 {
+		return errors.New("terminationGracePeriodMarginSeconds must be positive")
+	}
+
+	return nil
+}
+
+type MetadataPolicy string
+
+const (
+	MetadataPolicyKeep      MetadataPolicy = "keep"
+	MetadataPolicyOverwrite MetadataPolicy = "overwrite"
+)
+
+func (p MetadataPolicy) Validate() error {
+	if !slices.Contains([]MetadataPolicy{"", MetadataPolicyKeep, MetadataPolicyOverwrite}, p) {
+		return errors.Errorf("unknown metadata policy %s", p)
+	}
+
+	return nil
+}
+
+// policy for ingress hosts that are used by ingresses in other namespaces, empty policy disables check.
+type DuplicateHostPolicy string
+
+const (
+	DuplicateHostPolicyWarn DuplicateHostPolicy = "warn"
+	DuplicateHostPolicyDeny DuplicateHostPolicy = "deny"
+)
+
+func (p DuplicateHostPolicy) Validate() error {
+	if !slices.Contains([]DuplicateHostPolicy{"", DuplicateHostPolicyWarn, DuplicateHostPolicyDeny}, p) {
+		return errors.Errorf("unknown duplicate host policy %s", p)
+	}
+
+	return nil
+}
+
+type PropagateNamespaceMetadata struct {
+	Enabled bool
+	// namespace labels and annotations that are copied to pod labels
+	Labels      []PropagationKey
+	Annotations []PropagationKey
+}
+
+type PropagationKey struct {
+	// namespace key or regexp for namespace keys
+	Key    string
+	Regexp string
+	// pod label key, regexp groups can be used like $1, default namespace key
+	Rename string
+}
+
+func (p *PropagateNamespaceMetadata) Validate() error {
+	for _, key := range slices.Concat(p.Labels, p.Annotations) {
+		if (len(key.Key) == 0) == (len(key.Regexp) == 0) {
+			return errors.New("one of key or regexp must be set")
+		}
+
+		if _, err := regexp.Compile(key.Regexp); err != nil {
+			return errors.Wrapf(err, "error compiling regexp %s", key.Regexp)
+		}
+	}
+
+	return nil
+}
+
+// returns pod label key for namespace key, empty if key does not match.
+func (k *PropagationKey) GetTarget(namespaceKey string) string {
+	if len(k.Key) > 0 {
+		if k.Key != namespaceKey {
+			return ""
+		}
+
+		if len(k.Rename) > 0 {
+			return k.Rename
+		}
+
+		return namespaceKey
+	}
+
+	re := regexp.MustCompile(k.Regexp)
+
+	match := re.FindStringSubmatchIndex(namespaceKey)
+	if match == nil {
+		return ""
+	}
+
+	if len(k.Rename) == 0 {
+		return namespaceKey
+	}
+
+	return string(re.ExpandString(nil, k.Rename, namespaceKey, match))
+}
+
+// default tolerations, tolerations whitelist and node selector from namespace annotations.
+type NamespaceScheduling struct {
+	Enabled bool
+}
+
+type DNSConfig struct {
+	Enabled     bool
+	Nameservers []string
+	Searches    []string
+	// options are merged with pod options by name
+	Options []corev1.PodDNSConfigOption
+	// change pods with hostNetwork or dnsPolicy None
+	Force bool
+}
+
+func (d *DNSConfig) Validate() error {
+	for _, option := range d.Options {
+		if len(option.Name) == 0 {
+			return errors.New("dns option name is required")
+		}
+	}
+
+	return nil
+}
+
+const defaultTrustBundleKey = "ca-certificates.crt"
+
+// ca bundle files of os distributions, bundle replaces them, so it must have all trusted certificates.
+var defaultTrustBundleMountPaths = []string{
+	// debian, ubuntu, alpine
+	"/etc/ssl/certs/ca-certificates.crt",
+	// fedora, rhel
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	// opensuse
+	"/etc/ssl/ca-bundle.pem",
+}
+
+type TrustBundle struct {
+	Enabled       bool
+	ConfigMapName string
+	// configmap key with bundle, default ca-certificates.crt
+	Key string
+	// files where bundle is mounted, default os bundle files
+	MountPaths []string
+}
+
+func (t *TrustBundle) Validate() error {
+	if t.Enabled && len(t.ConfigMapName) == 0 {
+		return errors.New("configMapName is required")
+	}
+
+	return nil
+}
+
+func (t *TrustBundle) GetKey() string {
+	if len(t.Key) > 0 {
+		return t.Key
+	}
+
+	return defaultTrustBundleKey
+}
+
+func (t *TrustBundle) GetMountPaths() []string {
+	if len(t.MountPaths) > 0 {
+		return t.MountPaths
+	}
+
+	return defaultTrustBundleMountPaths
+}
+
 type Rule struct {
 	Debug                     bool
 	Name                      string
@@ -103,10 +682,28 @@ type Rule struct {
 	AddDefaultResources       AddDefaultResources
 	RunAsNonRoot              RunAsNonRoot
 	ReplaceContainerImageHost ReplaceContainerImageHost
+	PinImageDigest            PinImageDigest
+	NodeArchAffinity          NodeArchAffinity
+	ImagePolicy               ImagePolicy
 	Tolerations               []corev1.Toleration
 	ImagePullSecrets          []corev1.LocalObjectReference
 	CustomPatches             []PatchOperation
 	AddTopologySpread         AddTopologySpread
+	RuntimeEnv                RuntimeEnv
+	Affinity                  Affinity
+	SchedulingProfiles        SchedulingProfiles
+	VolumeZoneAffinity        VolumeZoneAffinity
+	PodClasses                PodClasses
+	DefaultProbes             DefaultProbes
+	// pod labels and annotations, values can be templated
+	Labels      map[string]string
+	Annotations map[string]string
+	// keep or overwrite pod labels and annotations that already exist, default keep
+	MetadataPolicy             MetadataPolicy
+	PropagateNamespaceMetadata PropagateNamespaceMetadata
+	NamespaceScheduling        NamespaceScheduling
+	DNSConfig                  DNSConfig
+	TrustBundle                TrustBundle
 }
 
 func (r *Rule) Logf(format string, args ...interface{}) {
@@ -117,12 +714,155 @@ func (r *Rule) Logf(format string, args ...interface{}) {
 	}
 }
 
+// ingress fields that are available in ingress rule conditions and templates.
+type IngressInfo struct {
+	Name                 string
+	Namespace            string
+	NamespaceLabels      map[string]string
+	NamespaceAnnotations map[string]string
+	Labels               map[string]string
+	Annotations          map[string]string
+	IngressClassName     string
+	// host without trailing dot, set only in host template
+	Host          string
+	SelectedRules []*IngressRule
+}
+
+// gateway api HTTPRoute or GRPCRoute, only fields that are mutated.
+type GatewayRoute struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              GatewayRouteSpec `json:"spec"`
+}
+
+type GatewayRouteSpec struct {
+	Hostnames []string `json:"hostnames,omitempty"`
+}
+
+type DefaultIngressTLS struct {
+	Enabled bool
+	// wildcard domains, for example *.example.com
+	Domains []string
+	// secret with wildcard certificate, value can be templated
+	SecretName string
+}
+
+func (t *DefaultIngressTLS) Validate() error {
+	if !t.Enabled {
+		return nil
+	}
+
+	if len(t.SecretName) == 0 {
+		return errors.New("secretName is required")
+	}
+
+	for _, domain := range t.Domains {
+		if !strings.HasPrefix(domain, "*.") {
+			return errors.Errorf("domain %s must be wildcard domain", domain)
+		}
+	}
+
+	return nil
+}
+
+// returns true if host is covered by wildcard certificate of domains.
+func (t *DefaultIngressTLS) MatchHost(host string) bool {
+	for _, domain := range t.Domains {
+		name, ok := strings.CutSuffix(host, domain[1:])
+		if ok && len(name) > 0 && !strings.Contains(name, ".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+type IngressRule struct {
+	Debug      bool
+	Name       string
+	Conditions []Condition
+	// used if ingress does not have ingressClassName
+	IngressClassName string
+	// ingress annotations, values can be templated
+	Annotations map[string]string
+	// keep or overwrite ingress annotations that already exist, default keep
+	MetadataPolicy MetadataPolicy
+	// template for hosts that ends with dot, for example {{ .Host }}.{{ index .NamespaceLabels `team` }}.example.com
+	Host       string
+	DefaultTLS DefaultIngressTLS
+}
+
+func (r *IngressRule) Validate() error {
+	if err := r.MetadataPolicy.Validate(); err != nil {
+		return errors.Wrap(err, "error in validating metadataPolicy")
+	}
+
+	if err := r.DefaultTLS.Validate(); err != nil {
+		return errors.Wrap(err, "error in validating defaultTLS")
+	}
+
+	for _, condition := range r.Conditions {
+		if err := condition.Validate(); err != nil {
+			return errors.Wrap(err, "error in validating condition")
+		}
+
+		if err := condition.Operator.Validate(); err != nil {
+			return errors.Wrap(err, "error in validating operator")
+		}
+	}
+
+	return nil
+}
+
+func (r *IngressRule) Logf(format string, args ...interface{}) {
+	if r.Debug || log.IsLevelEnabled(log.DebugLevel) {
+		log.WithFields(log.Fields{
+			"name": r.Name,
+		}).Infof(format, args...)
+	}
+}
+
 type PatchOperation struct {
 	Op    string      `json:"op"`
 	Path  string      `json:"path"`
 	Value interface{} `json:"value,omitempty"`
 }
 
+// escape key for json patch path https://datatracker.ietf.org/doc/html/rfc6901
+func EscapeJSONPointer(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
+
+// returns patch operations that add keys to metadata map with path, for example /metadata/labels.
+// map is created if object does not have it.
+func NewMetadataPatch(path string, current, values map[string]string) []PatchOperation {
+	result := make([]PatchOperation, 0)
+
+	if len(values) == 0 {
+		return result
+	}
+
+	if current == nil {
+		result = append(result, PatchOperation{
+			Op:    "add",
+			Path:  path,
+			Value: map[string]string{},
+		})
+	}
+
+	keys := slices.Sorted(maps.Keys(values))
+
+	for _, key := range keys {
+		result = append(result, PatchOperation{
+			Op:    "add",
+			Path:  path + "/" + EscapeJSONPointer(key),
+			Value: values[key],
+		})
+	}
+
+	return result
+}
+
 func (p *PatchOperation) String() string {
 	out, err := json.Marshal(p)
 	if err != nil {
@@ -202,7 +942,90 @@ type ContainerImage struct {
 	Path   string
 	Name   string
 	Slug   string
+	// image tag, latest if image has no tag and digest
 	Tag    string
+	Digest string
+	// image name without tag and digest, for example registry.com/team/app
+	Repository string
+	// last segment of image path, for example app for registry.com/team/app:1.0
+	ShortName string
+	// image path without last segment, for example team for registry.com/team/app:1.0
+	Organization string
+	// parsed tag if tag is semver, for example 1.2.3 or v1.2.3-rc.1
+	Semver   ImageSemver
+	IsLatest bool
+}
+
+type ImageSemver struct {
+	Valid      bool
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	Prerelease string
+}
+
+var imageSlugRegexp = regexp.MustCompile("[^A-Za-z0-9]+")
+
+// parse image to repo, slug path and tag.
+func NewContainerImage(image string) (*ContainerImage, error) {
+	// check if image is has fully qualified name
+	refName, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing image name %s", image)
+	}
+
+	// get only lowered the image name
+	imageName := strings.ToLower(reference.Path(refName))
+
+	// replace all non alphanumeric characters with a dash
+	imageName = imageSlugRegexp.ReplaceAllString(imageName, "-")
+
+	imagePath := reference.Path(refName)
+	lastSegment := strings.LastIndex(imagePath, "/")
+
+	result := ContainerImage{
+		Domain:     reference.Domain(refName),
+		Path:       imagePath,
+		Name:       image,
+		Slug:       strings.Trim(imageName, "-"),
+		Repository: reference.FamiliarName(refName),
+		ShortName:  imagePath[lastSegment+1:],
+	}
+
+	if lastSegment > 0 {
+		result.Organization = imagePath[:lastSegment]
+	}
+
+	if digest, ok := refName.(reference.Digested); ok {
+		result.Digest = digest.Digest().String()
+	}
+
+	if tag, ok := refName.(reference.Tagged); ok {
+		result.Tag = tag.Tag()
+	} else if len(result.Digest) == 0 {
+		// container runtime pulls latest tag
+		result.Tag = "latest"
+	}
+
+	result.IsLatest = result.Tag == "latest"
+	result.Semver = parseSemver(result.Tag)
+
+	return &result, nil
+}
+
+func parseSemver(tag string) ImageSemver {
+	version, err := semver.StrictNewVersion(strings.TrimPrefix(tag, "v"))
+	if err != nil {
+		return ImageSemver{}
+	}
+
+	return ImageSemver{
+		Valid:      true,
+		Major:      version.Major(),
+		Minor:      version.Minor(),
+		Patch:      version.Patch(),
+		Prerelease: version.Prerelease(),
+	}
 }
 
 type ContainerInfo struct {
@@ -218,6 +1041,12 @@ type ContainerInfo struct {
 	PodAnnotations       map[string]string
 	PodLabels            map[string]string
 	SelectedRules        []*Rule
+	// warnings that will be returned to user in admission response
+	Warnings []string
+}
+
+func (c *ContainerInfo) AddWarning(format string, args ...interface{}) {
+	c.Warnings = append(c.Warnings, annotationPrefix+": "+fmt.Sprintf(format, args...))
 }
 
 // return JSON representation of the container info.
@@ -251,6 +1080,59 @@ func (c *ContainerInfo) GetPodAnnotation(key string) (string, bool) {
 	return "", false
 }
 
+// check that some of selected rules has enabled namespace scheduling.
+func (c *ContainerInfo) NamespaceSchedulingEnabled() bool {
+	for _, selectedRule := range c.SelectedRules {
+		if selectedRule.NamespaceScheduling.Enabled {
+			return true
+		}
+	}
+
+	return false
+}
+
+// check if patch is ignored for container by annotation
+// pod-admission-controller/ignore-<patch-name>=<container-name>[,<container-name>].
+func (c *ContainerInfo) IgnorePatch(patchName string) bool {
+	ignore, ok := c.GetPodAnnotation(AnnotationIgnorePatchPrefix + patchName)
+	if !ok {
+		return false
+	}
+
+	if ignore == "*" {
+		return true
+	}
+
+	return slices.Contains(strings.Split(ignore, ","), c.ContainerName)
+}
+
+// labels that are generated by controllers and differ between pods of the same workload.
+var generatedPodLabels = []string{
+	"pod-template-hash",
+	"controller-revision-hash",
+	"statefulset.kubernetes.io/pod-name",
+	"apps.kubernetes.io/pod-index",
+}
+
+// returns pod labels that are the same for all pods of workload, labelKeys limits labels if not empty.
+func (c *ContainerInfo) GetWorkloadLabels(labelKeys []string) map[string]string {
+	result := make(map[string]string)
+
+	for key, value := range c.PodLabels {
+		if len(labelKeys) > 0 && !slices.Contains(labelKeys, key) {
+			continue
+		}
+
+		if slices.Contains(generatedPodLabels, key) {
+			continue
+		}
+
+		result[key] = value
+	}
+
+	return result
+}
+
 func (c *ContainerInfo) GetSelectedRulesEnv() []corev1.EnvVar {
 	containerEnv := make([]corev1.EnvVar, 0)
 
@@ -264,8 +1146,9 @@ func (c *ContainerInfo) GetSelectedRulesEnv() []corev1.EnvVar {
 type PodContainerType string
 
 const (
-	PodContainerTypeInitContainer PodContainerType = "initContainer"
-	PodContainerTypeContainer     PodContainerType = "container"
+	PodContainerTypeInitContainer      PodContainerType = "initContainer"
+	PodContainerTypeContainer          PodContainerType = "container"
+	PodContainerTypeEphemeralContainer PodContainerType = "ephemeralContainer"
 )
 
 type PodContainer struct {
@@ -315,6 +1198,30 @@ func (c *PodContainer) OwnerKind() string {
 	return ""
 }
 
+// return container port by name, 0 if names are not found, first port if names are not set.
+// usage: .PodContainer.ContainerPort "http" "web"
+// example: 8080
+func (c *PodContainer) ContainerPort(names ...string) int32 {
+	if c.Container == nil || len(c.Container.Ports) == 0 {
+		return 0
+	}
+
+	if len(names) == 0 {
+		return c.Container.Ports[0].ContainerPort
+	}
+
+	for _, name := range names {
+		for _, port := range c.Container.Ports {
+			if port.Name == name {
+				return port.ContainerPort
+			}
+		}
+	}
+
+	// other port can be metrics or admin port
+	return 0
+}
+
 func (c *PodContainer) ContainerPath() string {
 	return fmt.Sprintf("/spec/%ss/%d", c.Type, c.Order)
 }
@@ -342,9 +1249,37 @@ func PodContainersFromPod(namespace *corev1.Namespace, pod *corev1.Pod) []*PodCo
 		})
 	}
 
+	for order := range pod.Spec.EphemeralContainers {
+		// ephemeral containers have the same fields as containers
+		container := corev1.Container(pod.Spec.EphemeralContainers[order].EphemeralContainerCommon)
+
+		podContainers = append(podContainers, &PodContainer{
+			Pod:       pod,
+			Namespace: namespace,
+			Order:     order,
+			Type:      PodContainerTypeEphemeralContainer,
+			Container: &container,
+		})
+	}
+
 	return podContainers
 }
 
+// patch can return this error to deny admission request.
+type DenyError struct {
+	Message string
+}
+
+func NewDenyError(format string, args ...interface{}) *DenyError {
+	return &DenyError{
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func (e *DenyError) Error() string {
+	return e.Message
+}
+
 type CreateSecret struct {
 	Name string
 	Type string
@@ -222,6 +222,35 @@ func TestPodContainerOwnerKind(t *testing.T) {
 	}
 }
 
+func TestPodContainerContainerPort(t *testing.T) {
+	t.Parallel()
+
+	podContainer := types.PodContainer{
+		Container: &corev1.Container{
+			Ports: []corev1.ContainerPort{
+				{Name: "metrics", ContainerPort: 9090},
+				{Name: "http", ContainerPort: 8080},
+			},
+		},
+	}
+
+	if port := podContainer.ContainerPort("grpc", "http"); port != 8080 {
+		t.Fatalf("expected to find 8080, got %d", port)
+	}
+
+	if port := podContainer.ContainerPort("grpc"); port != 0 {
+		t.Fatalf("expected 0 for not found names, got %d", port)
+	}
+
+	if port := podContainer.ContainerPort(); port != 9090 {
+		t.Fatalf("expected first port 9090, got %d", port)
+	}
+
+	if port := (&types.PodContainer{Container: &corev1.Container{}}).ContainerPort("http"); port != 0 {
+		t.Fatalf("expected to 0, got %d", port)
+	}
+}
+
 func TestConditionOperator(t *testing.T) {
 	t.Parallel()
 
@@ -278,3 +307,31 @@ func TestConditionValidation(t *testing.T) {
 		t.Fatal("expected to find error")
 	}
 }
+
+func TestAffinityValidation(t *testing.T) {
+	t.Parallel()
+
+	valid := []types.Affinity{
+		// 0 means default weight
+		{PodAntiAffinity: types.PodAntiAffinity{Weight: 0}},
+		{PodAntiAffinity: types.PodAntiAffinity{Weight: 100}},
+	}
+
+	for _, affinity := range valid {
+		if err := affinity.Validate(); err != nil {
+			t.Fatalf("affinity %+v must be valid, got %v", affinity, err)
+		}
+	}
+
+	notValid := []types.Affinity{
+		{PodAntiAffinity: types.PodAntiAffinity{Weight: -1}},
+		{PodAntiAffinity: types.PodAntiAffinity{Weight: 101}},
+		{PreferredNodeAffinity: []corev1.PreferredSchedulingTerm{{Weight: 0}}},
+	}
+
+	for _, affinity := range notValid {
+		if err := affinity.Validate(); err == nil {
+			t.Fatalf("affinity %+v must be not valid", affinity)
+		}
+	}
+}